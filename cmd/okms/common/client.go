@@ -2,6 +2,7 @@ package common
 
 import (
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ovh/okms-cli/common/config"
@@ -13,6 +14,7 @@ import (
 var (
 	okmsId     uuid.UUID
 	restClient *okms.Client
+	customize  = func(*okms.Client) {}
 )
 
 func Client() *okms.Client {
@@ -37,22 +39,41 @@ func SetupRestApiFlags(command *cobra.Command, cust CustomizeFunc) {
 
 	config.SetupEndpointFlags(command, "restapi", func(command *cobra.Command, cfg config.EndpointConfig) {
 		okmsId = cfg.Auth.GetOkmsId()
-		clientCfg := okms.ClientConfig{
-			Timeout: timeout,
-			TlsCfg:  cfg.TlsConfig(""),
-			Retry: &okms.RetryConfig{
-				RetryMax: int(*retry),
-			},
-		}
-		if *debug {
-			clientCfg.Middleware = okms.DebugTransport(os.Stderr)
-		}
-		restClient = exit.OnErr2(okms.NewRestAPIClient(cfg.Endpoint, clientCfg))
-
-		if cfg.Auth.GetToken() != nil {
-			restClient.SetCustomHeader("Authorization", "Bearer "+*cfg.Auth.GetToken())
-		}
-
-		f(restClient)
+		customize = f
+		restClient = newRestClient(cfg, *debug, *retry, timeout)
+		keyResolver = NewKeyResolver(restClient, okmsId, config.SelectedProfile(command))
 	})
 }
+
+// ProfileClient returns a REST API client and the OKMS domain ID configured in the given profile of the
+// configuration file, to work with several domains at once. The global flags, like --debug or --retry, apply.
+func ProfileClient(cmd *cobra.Command, profile string) (*okms.Client, uuid.UUID, error) {
+	configFile, _ := cmd.Flags().GetString("config")
+	cfg, err := config.LoadProfileEndpointConfig("restapi", configFile, profile)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	debug, _ := cmd.Flags().GetBool("debug")
+	retry, _ := cmd.Flags().GetUint32("retry")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	return newRestClient(cfg, debug, retry, &timeout), cfg.Auth.GetOkmsId(), nil
+}
+
+func newRestClient(cfg config.EndpointConfig, debug bool, retry uint32, timeout *time.Duration) *okms.Client {
+	clientCfg := okms.ClientConfig{
+		Timeout: timeout,
+		TlsCfg:  cfg.TlsConfig(""),
+		Retry: &okms.RetryConfig{
+			RetryMax: int(retry),
+		},
+	}
+	if debug {
+		clientCfg.Middleware = okms.DebugTransport(os.Stderr)
+	}
+	client := exit.OnErr2(okms.NewRestAPIClient(cfg.Endpoint, clientCfg))
+	if cfg.Auth.GetToken() != nil {
+		client.SetCustomHeader("Authorization", "Bearer "+*cfg.Auth.GetToken())
+	}
+	customize(client)
+	return client
+}
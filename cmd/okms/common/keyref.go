@@ -0,0 +1,95 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ovh/okms-cli/common/config"
+	"github.com/ovh/okms-cli/common/utils"
+	"github.com/ovh/okms-sdk-go"
+	"github.com/ovh/okms-sdk-go/types"
+)
+
+// Prefixes of the key references which are not key IDs.
+const (
+	KeyRefNamePrefix  = utils.KeyRefNamePrefix
+	KeyRefAliasPrefix = utils.KeyRefAliasPrefix
+)
+
+// KeyRefHelp documents the key references, for the commands help.
+const KeyRefHelp = `KEY-ID is a key ID, name:NAME for the key with the given name, or alias:ALIAS for a key alias
+of the configuration profile (see "okms keys alias").`
+
+var keyResolver *KeyResolver
+
+// KeyResolver resolves the key references given to the commands into key IDs. A reference is a key ID,
+// name:NAME for the only key of the domain with the given name, or alias:ALIAS for a key alias defined in
+// the configuration profile.
+type KeyResolver struct {
+	client  *okms.Client
+	okmsId  uuid.UUID
+	profile string
+	// names caches the IDs of the domain keys by name, once listed.
+	names map[string][]uuid.UUID
+}
+
+// NewKeyResolver returns a resolver of the key references of a domain, using the key aliases of the given profile.
+func NewKeyResolver(client *okms.Client, okmsId uuid.UUID, profile string) *KeyResolver {
+	return &KeyResolver{client: client, okmsId: okmsId, profile: profile}
+}
+
+// ResolveKeyId resolves a key reference of the domain of the selected profile into a key ID.
+func ResolveKeyId(ctx context.Context, ref string) (uuid.UUID, error) {
+	return keyResolver.Resolve(ctx, ref)
+}
+
+// Resolve resolves a key reference into a key ID.
+func (r *KeyResolver) Resolve(ctx context.Context, ref string) (uuid.UUID, error) {
+	if name, ok := strings.CutPrefix(ref, KeyRefNamePrefix); ok {
+		return r.resolveName(ctx, name)
+	}
+	if alias, ok := strings.CutPrefix(ref, KeyRefAliasPrefix); ok {
+		id, ok := config.KeyAlias(r.profile, alias)
+		if !ok {
+			return uuid.Nil, fmt.Errorf("Key alias %q is not defined in profile %q", alias, r.profile)
+		}
+		keyId, err := uuid.Parse(id)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("Invalid key ID %q for key alias %q: %w", id, alias, err)
+		}
+		return keyId, nil
+	}
+	keyId, err := uuid.Parse(ref)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("Invalid key ID %q, expected a UUID, name:NAME or alias:ALIAS: %w", ref, err)
+	}
+	return keyId, nil
+}
+
+func (r *KeyResolver) resolveName(ctx context.Context, name string) (uuid.UUID, error) {
+	if r.names == nil {
+		names := map[string][]uuid.UUID{}
+		for key, err := range r.client.ListAllServiceKeys(r.okmsId, nil, utils.PtrTo(types.KeyStatesAll)).Iter(ctx) {
+			if err != nil {
+				return uuid.Nil, fmt.Errorf("Failed to list the keys: %w", err)
+			}
+			if key.Attributes != nil {
+				if state, _ := (*key.Attributes)["state"].(string); state == string(types.KeyStatesDestroyed) || state == string(types.KeyStatesDestroyedCompromised) {
+					continue
+				}
+			}
+			names[key.Name] = append(names[key.Name], key.Id)
+		}
+		r.names = names
+	}
+	switch ids := r.names[name]; len(ids) {
+	case 0:
+		return uuid.Nil, fmt.Errorf("No key is named %q", name)
+	case 1:
+		return ids[0], nil
+	default:
+		return uuid.Nil, fmt.Errorf("%d keys are named %q, use the key ID", len(ids), name)
+	}
+}
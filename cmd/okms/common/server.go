@@ -0,0 +1,30 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ShutdownTimeout is how long the servers wait for the pending requests once the command is interrupted.
+const ShutdownTimeout = 10 * time.Second
+
+// Serve runs serve, which is server.ListenAndServe or a variant of it, until ctx is done. The server is then shut
+// down gracefully, and Serve returns once the pending requests are completed, or after [ShutdownTimeout].
+func Serve(ctx context.Context, server *http.Server, serve func() error) error {
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(done)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	})
+	err := serve()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-done
+		return nil
+	}
+	stop()
+	return err
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"crypto/rsa"
+	"errors"
+
+	"github.com/ovh/okms-cli/common/utils/jwe"
+	"github.com/ovh/okms-sdk-go/types"
+)
+
+// UnwrapKey decrypts the wrapped key entries exported by the KMS with the transport private key, and returns the
+// first key material successfully parsed by parse. The entries are tried in order, and the errors of all of them
+// are returned if none is parsed.
+func UnwrapKey[T any](wrappedKeys []types.WrappedKeyEntry, transportKey *rsa.PrivateKey, parse func(entry types.WrappedKeyEntry, material []byte) (T, error)) (T, error) {
+	var (
+		zero T
+		errs []error
+	)
+	if len(wrappedKeys) == 0 {
+		return zero, errors.New("Server returned no wrapped key material")
+	}
+	for _, entry := range wrappedKeys {
+		material, _, err := jwe.Decrypt(entry.Ciphertext, transportKey)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		key, err := parse(entry, material)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return key, nil
+	}
+	return zero, errors.Join(errs...)
+}
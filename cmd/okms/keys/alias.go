@@ -0,0 +1,102 @@
+package keys
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/config"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/spf13/cobra"
+)
+
+func newAliasCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alias",
+		Short: "Manage the local key aliases of the configuration profile",
+		Long: `Manage the local key aliases of the configuration profile.
+
+Key aliases are stored per profile in the configuration file, and are used as alias:ALIAS instead of a key ID
+in the commands taking a KEY-ID.`,
+	}
+	cmd.AddCommand(
+		newAliasSetCmd(),
+		newAliasRemoveCmd(),
+		newAliasListCmd(),
+	)
+	return cmd
+}
+
+func newAliasSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set ALIAS KEY-ID",
+		Short: "Define or replace a key alias",
+		Long: `Define or replace a key alias. The key reference is resolved once, and the alias stores its key ID.
+
+` + common.KeyRefHelp,
+		Example: `  okms keys alias set prod-signing 0195a0b5-0000-7000-8000-000000000001
+  okms keys alias set prod-signing name:app-signing`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[1]))
+			profile := config.SelectedProfile(cmd)
+			file := loadConfigFile(cmd)
+			exit.OnErr(config.SetKeyAlias(profile, args[0], keyId.String()))
+			exit.OnErr(config.WriteToFile(file))
+			fmt.Printf("Alias %q set to key %s\n", args[0], keyId)
+		},
+	}
+}
+
+func newAliasRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "remove ALIAS [ALIAS...]",
+		Aliases: []string{"rm"},
+		Short:   "Remove key aliases",
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			profile := config.SelectedProfile(cmd)
+			file := loadConfigFile(cmd)
+			for _, alias := range args {
+				exit.OnErr(config.RemoveKeyAlias(profile, alias))
+			}
+			exit.OnErr(config.WriteToFile(file))
+		},
+	}
+}
+
+func newAliasListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the key aliases",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			aliases := config.KeyAliases(config.SelectedProfile(cmd))
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(aliases)
+				return
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			table.Header([]string{"Alias", "Key ID"})
+			for _, alias := range slices.Sorted(maps.Keys(aliases)) {
+				exit.OnErr(table.Append([]string{alias, aliases[alias]}))
+			}
+			exit.OnErr(table.Render())
+		},
+	}
+}
+
+// loadConfigFile reloads the configuration file, and returns its path to write it back.
+func loadConfigFile(cmd *cobra.Command) string {
+	file, err := config.LoadFromFile("okms", cmd.Flag("config").Value.String())
+	if err != nil {
+		exit.OnErr(fmt.Errorf("Failed to load config file: %w", err))
+	}
+	return file
+}
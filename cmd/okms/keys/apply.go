@@ -0,0 +1,122 @@
+package keys
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/keymanifest"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/spf13/cobra"
+)
+
+func newApplyCmd() *cobra.Command {
+	var (
+		manifestFile string
+		planOnly     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "apply -f MANIFEST",
+		Short: "Provision the keys declared in a manifest",
+		Long: `Provision the keys declared in a YAML manifest.
+
+The manifest is compared with the domain keys: the missing keys are created, and the keys matched by ID are renamed
+to their manifest name. The differences which cannot be fixed, like a changed type, size, curve, usage, protection
+level or a key which is not active, are reported as drift and make the command fail. With --plan, the changes are
+only printed.
+
+The keys are matched by ID when set in the manifest, by name otherwise. The context and the extractable attribute
+are only applied on creation.
+
+Manifest example:
+  keys:
+    - name: app-encryption
+      type: oct            # oct, rsa or ec
+      size: 256            # defaults to 256 for oct keys, 2048 for rsa keys
+      usage: [encrypt, decrypt]
+    - name: app-signing
+      id: 0195a0b5-0000-7000-8000-000000000001
+      type: ec
+      curve: P-256         # defaults to P-256
+      usage: [sign, verify]
+      protectionLevel: hsm # soft or hsm
+      extractable: false
+      context: app`,
+		Example: `  okms keys apply -f keys.yaml --plan
+  okms keys apply -f keys.yaml`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			manifest := exit.OnErr2(keymanifest.Load(manifestFile))
+
+			var existing []types.GetServiceKeyResponse
+			for key, err := range common.Client().ListAllServiceKeys(common.GetOkmsId(), nil, utils.PtrTo(types.KeyStatesAll)).Iter(cmd.Context()) {
+				exit.OnErr(err)
+				existing = append(existing, *key)
+			}
+			changes := manifest.Plan(existing)
+
+			if !planOnly {
+				for i := range changes {
+					change := &changes[i]
+					switch change.Action {
+					case keymanifest.ActionCreate:
+						resp := exit.OnErr2(common.Client().CreateImportServiceKey(cmd.Context(), common.GetOkmsId(), nil, change.Key.Request()))
+						change.Id = &resp.Id
+					case keymanifest.ActionRename:
+						exit.OnErr2(common.Client().UpdateServiceKey(cmd.Context(), common.GetOkmsId(), *change.Id, types.PatchServiceKeyRequest{Name: &change.Name}))
+					}
+				}
+			}
+
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(changes)
+			} else {
+				printPlan(changes, planOnly)
+			}
+
+			drifted := 0
+			for _, change := range changes {
+				if len(change.Drift) > 0 {
+					drifted++
+				}
+			}
+			if drifted > 0 {
+				exit.OnErr(fmt.Errorf("%d keys drifted from the manifest, and must be fixed manually", drifted))
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&manifestFile, "file", "f", "", "Path of the YAML key manifest")
+	cmd.Flags().BoolVar(&planOnly, "plan", false, "Only print the planned changes, without applying them")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+func printPlan(changes []keymanifest.Change, planOnly bool) {
+	created, renamed := "created", "renamed"
+	if planOnly {
+		created, renamed = "to create", "to rename"
+	}
+	for _, change := range changes {
+		name := change.Name
+		if change.Id != nil {
+			name += " " + change.Id.String()
+		}
+		switch change.Action {
+		case keymanifest.ActionCreate:
+			fmt.Printf("+ %s: %s\n", name, created)
+		case keymanifest.ActionRename:
+			fmt.Printf("~ %s: %s from %q\n", name, renamed, change.CurrentName)
+		default:
+			fmt.Printf("  %s: unchanged\n", name)
+		}
+		if len(change.Drift) > 0 {
+			fmt.Printf("! %s: drift: %s\n", name, strings.Join(change.Drift, ", "))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package keys
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/keyaudit"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/spf13/cobra"
+)
+
+func newAuditCmd() *cobra.Command {
+	var (
+		policyFile string
+		junitFile  string
+		listAll    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "audit --policy POLICY",
+		Short: "Audit the domain keys against a compliance policy",
+		Long: `Audit the domain keys against a YAML compliance policy, and print a pass/fail report.
+
+Only the active keys are audited, unless --all is set. The destroyed keys are never audited. With --junit, the
+report is also written as a JUnit XML file, with a test case per key. The command fails if a key violates the policy.
+
+A rule is reported as not checked on the keys which do not expose the attribute it needs.
+
+Policy example:
+  minRsaKeySize: 3072
+  minOctKeySize: 256
+  allowedCurves: [P-384, P-521]
+  forbidExtractable: true
+  requiredProtectionLevel: hsm # soft or hsm
+  maxAge: 365d                 # days (d), weeks (w) or a duration like 8760h
+  forbiddenUsages:             # usage combinations a key must not have all together
+    - [encrypt, sign]
+    - [wrapKey, decrypt]`,
+		Example: `  okms keys audit --policy policy.yaml
+  okms keys audit --policy policy.yaml --all --junit audit.xml`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			policy := exit.OnErr2(keyaudit.Load(policyFile))
+
+			stateFilter := types.KeyStatesActive
+			if listAll {
+				stateFilter = types.KeyStatesAll
+			}
+			now := time.Now()
+			report := keyaudit.Report{Keys: []keyaudit.Result{}}
+			for key, err := range common.Client().ListAllServiceKeys(common.GetOkmsId(), nil, &stateFilter).Iter(cmd.Context()) {
+				exit.OnErr(err)
+				keyAttr := getCommonKeyAttributes(key)
+				if keyAttr.State == types.KeyStatesDestroyed || keyAttr.State == types.KeyStatesDestroyedCompromised {
+					continue
+				}
+				report.Add(policy.Audit(key, keyaudit.Attributes{
+					State:       keyAttr.State,
+					CreatedAt:   keyAttr.CreatedAt,
+					Extractable: keyAttr.Extractable,
+				}, now))
+			}
+
+			if junitFile != "" {
+				exit.OnErr(os.WriteFile(junitFile, exit.OnErr2(report.JUnit("okms keys audit", now)), 0o644))
+			}
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(report)
+			} else {
+				printAuditReport(report)
+			}
+			if report.Failed > 0 {
+				exit.OnErr(fmt.Errorf("%d of %d keys failed the audit", report.Failed, len(report.Keys)))
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&policyFile, "policy", "", "Path of the YAML compliance policy")
+	cmd.Flags().StringVar(&junitFile, "junit", "", "Path of the JUnit XML report to write")
+	cmd.Flags().BoolVarP(&listAll, "all", "A", false, "Audit all keys, including the deactivated and compromised ones")
+	_ = cmd.MarkFlagRequired("policy")
+	return cmd
+}
+
+func printAuditReport(report keyaudit.Report) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"ID", "Name", "Type", "State", "Result", "Violations"})
+	for _, res := range report.Keys {
+		result := "PASS"
+		if !res.Passed {
+			result = "FAIL"
+		}
+		var violations []string
+		for _, v := range res.Violations {
+			violations = append(violations, v.Rule+": "+v.Message)
+		}
+		for _, v := range res.Unchecked {
+			violations = append(violations, v.Rule+" not checked: "+v.Message)
+		}
+		exit.OnErr(table.Append([]string{res.Id.String(), res.Name, string(res.Type), string(res.State), result, strings.Join(violations, "\n")}))
+	}
+	exit.OnErr(table.Render())
+	fmt.Printf("%d keys passed, %d failed\n", report.Passed, report.Failed)
+}
@@ -0,0 +1,156 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/olekukonko/tablewriter"
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/keyfilter"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/spf13/cobra"
+)
+
+const bulkHelp = `The keys are given as KEY-ID arguments, or selected with --filter, or both. A filter is made of
+space-separated FIELD=VALUE terms which must all match. The fields are type, state, name, usage, protection-level,
+created-after and created-before, with the same values as the "keys list" flags. The destroyed keys are never selected,
+nor are the keys with an unknown creation date when filtering on it.
+
+A failure on a key does not abort the others, and a result is printed for each key. With --dry-run, the selected keys
+are only printed.`
+
+// bulkParams are the flags selecting the keys of a bulk operation.
+type bulkParams struct {
+	filter      string
+	dryRun      bool
+	concurrency int
+}
+
+func setBulkFlags(cmd *cobra.Command) *bulkParams {
+	params := new(bulkParams)
+	cmd.Flags().StringVar(&params.filter, "filter", "", "Select the keys matching a filter expression, like 'name=tmp-* state=active created-before=2025-01-01'")
+	cmd.Flags().BoolVar(&params.dryRun, "dry-run", false, "Only print the selected keys, without changing them")
+	cmd.Flags().IntVar(&params.concurrency, "concurrency", 1, "Number of keys processed at the same time")
+	return params
+}
+
+type bulkResult struct {
+	Id     *uuid.UUID `json:"id,omitempty"`
+	Name   string     `json:"name,omitempty"`
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// Statuses of a bulk operation result.
+const (
+	bulkStatusDone   = "done"
+	bulkStatusFailed = "failed"
+	bulkStatusDryRun = "dry-run"
+)
+
+// run applies op to the keys given as arguments and to the keys matching the filter, and prints the results.
+// The verb describes the operation in the error reported when a key failed.
+func (params *bulkParams) run(cmd *cobra.Command, args []string, verb string, op func(ctx context.Context, keyId uuid.UUID) error) {
+	if len(args) == 0 && params.filter == "" {
+		exit.OnErr(errors.New("Missing KEY-ID parameter, or --filter flag"))
+	}
+	if params.concurrency < 1 {
+		exit.OnErr(errors.New("Concurrency must be at least 1"))
+	}
+	results := params.selectKeys(cmd.Context(), args)
+
+	if !params.dryRun {
+		sem := make(chan struct{}, params.concurrency)
+		var wg sync.WaitGroup
+		for i := range results {
+			res := &results[i]
+			if res.Status == bulkStatusFailed {
+				continue
+			}
+			sem <- struct{}{}
+			wg.Go(func() {
+				defer func() { <-sem }()
+				if err := op(cmd.Context(), *res.Id); err != nil {
+					res.Status, res.Error = bulkStatusFailed, err.Error()
+					return
+				}
+				res.Status = bulkStatusDone
+			})
+		}
+		wg.Wait()
+	}
+
+	if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+		output.JsonPrint(results)
+	} else {
+		printBulkResults(results)
+	}
+	failed := 0
+	for _, res := range results {
+		if res.Status == bulkStatusFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		exit.OnErr(fmt.Errorf("Failed to %s %d of %d keys", verb, failed, len(results)))
+	}
+}
+
+// selectKeys resolves the key arguments, and lists the keys matching the filter. The keys which cannot be resolved
+// are returned as failed.
+func (params *bulkParams) selectKeys(ctx context.Context, args []string) []bulkResult {
+	results := []bulkResult{}
+	selected := map[uuid.UUID]bool{}
+	for _, ref := range args {
+		keyId, err := common.ResolveKeyId(ctx, ref)
+		if err != nil {
+			results = append(results, bulkResult{Name: ref, Status: bulkStatusFailed, Error: err.Error()})
+			continue
+		}
+		if !selected[keyId] {
+			selected[keyId] = true
+			results = append(results, bulkResult{Id: &keyId, Status: bulkStatusDryRun})
+		}
+	}
+	if params.filter == "" {
+		return results
+	}
+
+	filter := exit.OnErr2(keyfilter.Parse(params.filter))
+	state := filter.ListState(types.KeyStatesAll)
+	for key, err := range common.Client().ListAllServiceKeys(common.GetOkmsId(), nil, &state).Iter(ctx) {
+		exit.OnErr(err)
+		keyAttr := getCommonKeyAttributes(key)
+		if keyAttr.State == types.KeyStatesDestroyed || keyAttr.State == types.KeyStatesDestroyedCompromised || selected[key.Id] {
+			continue
+		}
+		if filter.Match(&keyfilter.Key{GetServiceKeyResponse: key, State: keyAttr.State, CreatedAt: keyAttr.CreatedAt}) {
+			selected[key.Id] = true
+			results = append(results, bulkResult{Id: &key.Id, Name: key.Name, Status: bulkStatusDryRun})
+		}
+	}
+	return results
+}
+
+func printBulkResults(results []bulkResult) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"ID", "Name", "Status", "Error"})
+	counts := map[string]int{}
+	for _, res := range results {
+		var id string
+		if res.Id != nil {
+			id = res.Id.String()
+		}
+		counts[res.Status]++
+		exit.OnErr(table.Append([]string{id, res.Name, res.Status, res.Error}))
+	}
+	exit.OnErr(table.Render())
+	fmt.Printf("%d keys selected, %d done, %d failed\n", len(results), counts[bulkStatusDone], counts[bulkStatusFailed])
+}
@@ -0,0 +1,271 @@
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+)
+
+// COSIGN_BUNDLE_MEDIA_TYPE is the media type of the sigstore bundles produced by sign-blob.
+const COSIGN_BUNDLE_MEDIA_TYPE = "application/vnd.dev.sigstore.bundle.v0.3+json"
+
+// cosignBundle is the JSON representation of a sigstore bundle carrying a message signature,
+// as produced by "cosign sign-blob --new-bundle-format".
+type cosignBundle struct {
+	MediaType            string                     `json:"mediaType"`
+	VerificationMaterial cosignVerificationMaterial `json:"verificationMaterial"`
+	MessageSignature     cosignMessageSignature     `json:"messageSignature"`
+}
+
+type cosignVerificationMaterial struct {
+	PublicKey   *cosignPublicKey   `json:"publicKey,omitempty"`
+	Certificate *cosignCertificate `json:"certificate,omitempty"`
+}
+
+type cosignPublicKey struct {
+	Hint string `json:"hint"`
+}
+
+type cosignCertificate struct {
+	RawBytes []byte `json:"rawBytes"`
+}
+
+type cosignMessageSignature struct {
+	MessageDigest cosignMessageDigest `json:"messageDigest"`
+	Signature     []byte              `json:"signature"`
+}
+
+type cosignMessageDigest struct {
+	Algorithm string `json:"algorithm"`
+	Digest    []byte `json:"digest"`
+}
+
+func newCosignCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cosign",
+		Short: "Sign and verify blobs using sigstore/cosign compatible bundles",
+	}
+	cmd.AddCommand(
+		newCosignSignBlobCmd(),
+		newCosignVerifyBlobCmd(),
+	)
+	return cmd
+}
+
+func newCosignSignBlobCmd() *cobra.Command {
+	var (
+		certFile   string
+		bundleFile string
+		noProgress bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign-blob KEY-ID BLOB",
+		Short: "Sign a blob and output a sigstore/cosign bundle",
+		Long: `Sign a blob with the key identified by KEY-ID and output a sigstore/cosign bundle.
+
+BLOB can be either plain text, a '-' to read from stdin, or a filename prefixed with @.
+
+The bundle contains the message digest, the signature and either a hint of the signing
+public key or, when --signing-cert is given, the X.509 certificate of the signing key
+(for example a certificate issued with "okms x509 sign"). No transparency log entry is
+recorded, so cosign must be run with --insecure-ignore-tlog to verify the bundle.`,
+		Example: `  # Sign an artifact and verify it with cosign
+  okms keys cosign sign-blob <key-id> @artifact.tar.gz --bundle artifact.bundle
+  okms keys export <key-id> > key.pub
+  cosign verify-blob --key key.pub --bundle artifact.bundle --insecure-ignore-tlog artifact.tar.gz`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
+			signer := exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId))
+
+			hash := exit.OnErr2(x509utils.HashForPublicKey(signer.Public()))
+			digest := hashInput(hash, args[1], "Signing", noProgress)
+			signature := exit.OnErr2(signer.Sign(rand.Reader, digest, hash))
+
+			bundle := cosignBundle{
+				MediaType: COSIGN_BUNDLE_MEDIA_TYPE,
+				MessageSignature: cosignMessageSignature{
+					MessageDigest: cosignMessageDigest{
+						Algorithm: exit.OnErr2(cosignDigestAlgorithm(hash)),
+						Digest:    digest,
+					},
+					Signature: signature,
+				},
+			}
+
+			if certFile != "" {
+				cert := exit.OnErr2(x509utils.LoadCertificates(certFile))[0]
+				if !x509utils.PublicKeysEqual(cert.PublicKey, signer.Public()) {
+					exit.OnErr(errors.New("The certificate's public key does not match the signing key"))
+				}
+				bundle.VerificationMaterial.Certificate = &cosignCertificate{RawBytes: cert.Raw}
+			} else {
+				der := exit.OnErr2(x509.MarshalPKIXPublicKey(signer.Public()))
+				hint := sha256.Sum256(der)
+				bundle.VerificationMaterial.PublicKey = &cosignPublicKey{Hint: base64.StdEncoding.EncodeToString(hint[:])}
+			}
+
+			writer := flagsmgmt.WriterFromArg(bundleFile)
+			defer writer.Close()
+			enc := json.NewEncoder(writer)
+			enc.SetIndent("", "    ")
+			exit.OnErr(enc.Encode(bundle))
+		},
+	}
+
+	cmd.Flags().StringVar(&certFile, "signing-cert", "", "Path to a PEM encoded certificate of the signing key to embed in the bundle")
+	cmd.Flags().StringVar(&bundleFile, "bundle", "-", "Path to the bundle file to write, or '-' for stdout")
+	cmd.Flags().BoolVar(&noProgress, "no-progress", false, "Do not display progress bar or spinner")
+
+	return cmd
+}
+
+func newCosignVerifyBlobCmd() *cobra.Command {
+	var (
+		bundleFile    string
+		publicKeyFile string
+		caRootsFile   string
+		noProgress    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify-blob BLOB [KEY-ID]",
+		Short: "Verify a blob against a sigstore/cosign bundle",
+		Long: `Verify a blob against a sigstore/cosign bundle.
+
+BLOB can be either plain text, a '-' to read from stdin, or a filename prefixed with @.
+
+The verification key is taken, by order of precedence, from the KMS key identified by KEY-ID,
+from the PEM public key given with --public-key, or from the certificate embedded in the bundle.
+When both KEY-ID (or --public-key) and an embedded certificate are present, they must match.
+If --ca-roots is given, the embedded certificate must chain to one of these roots, for code signing.
+The embedded certificate alone is not trusted: KEY-ID, --public-key or --ca-roots is required.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			var bundle cosignBundle
+			exit.OnErr(json.Unmarshal(exit.OnErr2(os.ReadFile(bundleFile)), &bundle))
+			if bundle.MediaType != COSIGN_BUNDLE_MEDIA_TYPE {
+				exit.OnErr(fmt.Errorf("Unsupported bundle media type %q", bundle.MediaType))
+			}
+
+			var pubKey crypto.PublicKey
+			switch {
+			case len(args) > 1:
+				keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[1]))
+				pubKey = exit.OnErr2(common.Client().ExportPublicKey(cmd.Context(), common.GetOkmsId(), keyId))
+			case publicKeyFile != "":
+				block := exit.OnErr2(x509utils.PemDecode(exit.OnErr2(os.ReadFile(publicKeyFile))))
+				pubKey = exit.OnErr2(x509.ParsePKIXPublicKey(block.Bytes))
+			}
+
+			if certMaterial := bundle.VerificationMaterial.Certificate; certMaterial != nil {
+				cert := exit.OnErr2(x509.ParseCertificate(certMaterial.RawBytes))
+				if caRootsFile != "" {
+					roots := x509.NewCertPool()
+					if !roots.AppendCertsFromPEM(exit.OnErr2(os.ReadFile(caRootsFile))) {
+						exit.OnErr(fmt.Errorf("No certificate found in %q", caRootsFile))
+					}
+					exit.OnErr2(cert.Verify(x509.VerifyOptions{
+						Roots:       roots,
+						CurrentTime: time.Now(),
+						KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+					}))
+				}
+				if pubKey != nil && !x509utils.PublicKeysEqual(pubKey, cert.PublicKey) {
+					exit.OnErr(errors.New("The bundle's certificate does not match the verification key"))
+				}
+				if pubKey == nil && caRootsFile == "" {
+					exit.OnErr(errors.New("The bundle's certificate is not trusted: provide a KEY-ID, --public-key or --ca-roots"))
+				}
+				pubKey = cert.PublicKey
+			} else if caRootsFile != "" {
+				exit.OnErr(errors.New("The bundle does not contain a certificate to verify against --ca-roots"))
+			}
+			if pubKey == nil {
+				exit.OnErr(errors.New("No verification key: provide a KEY-ID or --public-key"))
+			}
+
+			hash := exit.OnErr2(cosignHashFromAlgorithm(bundle.MessageSignature.MessageDigest.Algorithm))
+			digest := hashInput(hash, args[0], "Verifying signature", noProgress)
+			if !bytes.Equal(digest, bundle.MessageSignature.MessageDigest.Digest) {
+				exit.OnErr(errors.New("The blob digest does not match the bundle"))
+			}
+			exit.OnErr(verifyDigestSignature(pubKey, hash, digest, bundle.MessageSignature.Signature))
+
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(true)
+			} else {
+				fmt.Println("Verified OK")
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&bundleFile, "bundle", "", "Path to the bundle file to verify")
+	cmd.Flags().StringVar(&publicKeyFile, "public-key", "", "Path to a PEM encoded PKIX public key to verify with")
+	cmd.Flags().StringVar(&caRootsFile, "ca-roots", "", "Path to a PEM bundle of CA certificates the embedded certificate must chain to")
+	cmd.Flags().BoolVar(&noProgress, "no-progress", false, "Do not display progress bar or spinner")
+	_ = cmd.MarkFlagRequired("bundle")
+
+	return cmd
+}
+
+func cosignDigestAlgorithm(hash crypto.Hash) (string, error) {
+	switch hash {
+	case crypto.SHA256:
+		return "SHA2_256", nil
+	case crypto.SHA384:
+		return "SHA2_384", nil
+	case crypto.SHA512:
+		return "SHA2_512", nil
+	default:
+		return "", fmt.Errorf("Unsupported hash algorithm %s", hash)
+	}
+}
+
+func cosignHashFromAlgorithm(alg string) (crypto.Hash, error) {
+	switch alg {
+	case "SHA2_256":
+		return crypto.SHA256, nil
+	case "SHA2_384":
+		return crypto.SHA384, nil
+	case "SHA2_512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("Unsupported digest algorithm %q", alg)
+	}
+}
+
+// verifyDigestSignature verifies an ASN.1 ECDSA or PKCS#1 v1.5 RSA signature of the given digest.
+func verifyDigestSignature(pub crypto.PublicKey, hash crypto.Hash, digest, signature []byte) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return fmt.Errorf("Invalid signature: %w", err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, signature) {
+			return errors.New("Invalid signature")
+		}
+	default:
+		return fmt.Errorf("Unsupported key type %T", pub)
+	}
+	return nil
+}
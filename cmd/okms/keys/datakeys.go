@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/google/uuid"
 	"github.com/olekukonko/tablewriter"
 	"github.com/ovh/okms-cli/cmd/okms/common"
 	"github.com/ovh/okms-cli/common/flagsmgmt"
@@ -38,7 +37,7 @@ func newGenerateDataKeyFromServiceKeyCmd() *cobra.Command {
 		Short: "Generate data key wrapped by domain key",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
 			plaintext, encrypted := exit.OnErr3(common.Client().GenerateDataKey(cmd.Context(), common.GetOkmsId(), keyId, name, keySize))
 			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
 				output.JsonPrint(map[string]any{
@@ -65,7 +64,7 @@ func newDecryptDataKeyCmd() *cobra.Command {
 DATA-KEY can be either plain text, a '-' to read from stdin, or a filename prefixed with @`,
 		Args: cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
 			plaintext := exit.OnErr2(common.Client().DecryptDataKey(cmd.Context(), common.GetOkmsId(), keyId, flagsmgmt.StringFromArg(args[1], 8192)))
 			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
 				output.JsonPrint(plaintext)
@@ -33,7 +33,7 @@ OUTPUT can be either a filepath, or a "-" for stdout. If not set, output is stdo
 `,
 		Args: cobra.RangeArgs(2, 3),
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
 			out := "-"
 			if len(args) > 2 && args[2] != "" {
 				out = args[2]
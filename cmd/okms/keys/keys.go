@@ -2,6 +2,7 @@ package keys
 
 import (
 	"bytes"
+	"context"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
@@ -23,7 +24,11 @@ import (
 	"github.com/ovh/okms-cli/common/output"
 	"github.com/ovh/okms-cli/common/utils"
 	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/keyfilter"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	fsutils "github.com/ovh/okms-cli/internal/utils"
 	"github.com/ovh/okms-sdk-go/types"
+	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 )
 
@@ -31,31 +36,94 @@ func newListServiceKeysCmd() *cobra.Command {
 	var (
 		pageSize uint32
 		listAll  bool
+		sortBy   string
+		limit    int
 	)
 
 	cmd := &cobra.Command{
 		Use:     "list",
 		Aliases: []string{"ls"},
 		Short:   "List domain keys",
-		Args:    cobra.NoArgs,
+		Long: `List domain keys.
+
+Only the active keys are listed, unless --all or --state is set. The filters are applied client side while the keys
+are fetched. --name accepts a glob pattern, or a regular expression between slashes. The keys are sorted with
+--sort FIELD[:asc|desc], where FIELD is one of name, created, type, state or id.
+
+With --output ndjson, each key is printed as a line of JSON as soon as it is fetched, so that large domains are
+listed with constant memory use. Sorting needs all the keys, which are then printed once fetched. --limit stops the
+listing after the given number of keys, once sorted if --sort is set.`,
+		Example: `  okms keys list --type rsa,ec --usage sign
+  okms keys list --state deactivated,compromised --name 'tmp-*' --created-before 2025-01-01
+  okms keys list --name '/^app-[0-9]+$/' --sort created:desc
+  okms keys list --all --output ndjson --limit 1000 | jq -r .id`,
+		// The keys can be streamed as newline delimited JSON
+		Annotations: map[string]string{flagsmgmt.NDJSON_ANNOTATION: "true"},
+		Args:        cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			keys := types.ListServiceKeysResponse{
-				ObjectsList: []types.GetServiceKeyResponse{},
+			var filter keyfilter.Filter
+			for _, field := range keyfilter.Fields {
+				if cmd.Flags().Changed(field) {
+					exit.OnErr(filter.Set(field, cmd.Flag(field).Value.String()))
+				}
 			}
-			// Let's list all the keys by putting them all in memory. The memory is not an issue, unless a domain has hundreds of thousands of keys
+			var sortOrder *keyfilter.Sort
+			if sortBy != "" {
+				sortOrder = utils.PtrTo(exit.OnErr2(keyfilter.ParseSort(sortBy)))
+			}
+
+			format := flagsmgmt.OutputFormat(cmd.Flag("output").Value.String())
+			// With ndjson output, the keys are printed as soon as they are fetched, unless they must be sorted.
+			// Otherwise the matching keys are kept in memory to be sorted or printed as a whole.
+			stream := format == flagsmgmt.NDJSON_OUTPUT_FORMAT && sortOrder == nil
 			// Filter keys by activation state
 			stateFilter := types.KeyStatesActive
 			if listAll {
 				stateFilter = types.KeyStatesAll
 			}
+			stateFilter = filter.ListState(stateFilter)
+			var matches []keyfilter.Key
+			count := 0
 			for key, err := range common.Client().ListAllServiceKeys(common.GetOkmsId(), &pageSize, &stateFilter).Iter(cmd.Context()) {
 				exit.OnErr(err)
-				keys.ObjectsList = append(keys.ObjectsList, *key)
+				keyAttr := getCommonKeyAttributes(key)
+				k := keyfilter.Key{GetServiceKeyResponse: key, State: keyAttr.State, CreatedAt: keyAttr.CreatedAt}
+				if !filter.Match(&k) {
+					continue
+				}
+				if stream {
+					output.NdjsonPrint(key)
+				} else {
+					matches = append(matches, k)
+				}
+				if count++; limit > 0 && count >= limit && sortOrder == nil {
+					break
+				}
+			}
+			if stream {
+				return
+			}
+			if sortOrder != nil {
+				sortOrder.Apply(matches)
+				if limit > 0 && len(matches) > limit {
+					matches = matches[:limit]
+				}
+			}
+			keys := types.ListServiceKeysResponse{
+				ObjectsList: make([]types.GetServiceKeyResponse, 0, len(matches)),
+			}
+			for _, k := range matches {
+				keys.ObjectsList = append(keys.ObjectsList, *k.GetServiceKeyResponse)
 			}
 
-			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+			switch format {
+			case flagsmgmt.NDJSON_OUTPUT_FORMAT:
+				for _, key := range keys.ObjectsList {
+					output.NdjsonPrint(key)
+				}
+			case flagsmgmt.JSON_OUTPUT_FORMAT:
 				output.JsonPrint(keys)
-			} else {
+			default:
 				table := tablewriter.NewWriter(os.Stdout)
 				table.Header([]string{"ID", "Name", "Type", "Class", "State", "Created At"})
 				for _, key := range keys.ObjectsList {
@@ -79,6 +147,15 @@ func newListServiceKeysCmd() *cobra.Command {
 
 	cmd.Flags().Uint32Var(&pageSize, "page-size", 100, "Number of keys to fetch per page (between 10 and 500)")
 	cmd.Flags().BoolVarP(&listAll, "all", "A", false, "List all keys (including deactivated and deleted ones)")
+	cmd.Flags().String("type", "", "Only list the keys of the given comma-separated types (oct, rsa, ec)")
+	cmd.Flags().String("state", "", "Only list the keys in the given comma-separated states (pre_active, active, deactivated, compromised, destroyed, destroyed_compromised)")
+	cmd.Flags().String("name", "", "Only list the keys whose name matches a glob pattern, or a regular expression between slashes")
+	cmd.Flags().String("usage", "", "Only list the keys allowing all the given comma-separated usages")
+	cmd.Flags().String("protection-level", "", "Only list the keys with the given comma-separated protection levels (soft, hsm)")
+	cmd.Flags().String("created-after", "", "Only list the keys created after a date (2006-01-02 or RFC3339)")
+	cmd.Flags().String("created-before", "", "Only list the keys created before a date (2006-01-02 or RFC3339)")
+	cmd.Flags().StringVar(&sortBy, "sort", "", "Sort the keys by a field (name, created, type, state, id), with an optional :asc or :desc direction")
+	cmd.Flags().IntVar(&limit, "limit", 0, "Maximum number of keys to list. 0 for no limit")
 	return cmd
 }
 
@@ -158,18 +235,34 @@ func newGetServiceKeyCmd() *cobra.Command {
 		wrappingKeyID     string
 		wrappingAlgorithm string
 		wrappedKeyFormat  string
+		unwrapWith        string
+		outFile           string
 	)
 
 	cmd := &cobra.Command{
 		Use:   "get KEY-ID",
 		Short: "Retrieve domain key metadata, or export the key material in wrapped form",
-		Args:  cobra.ExactArgs(1),
+		Long: `Retrieve domain key metadata, or export the key material in wrapped form.
+
+With --wrapping-key-id, the material of an extractable key is exported encrypted (wrapped) with the transport
+key, and printed as a JWE token. With --unwrap-with and --out, the JWE is decrypted locally with the transport
+private key, and the plaintext key material is written to the --out file, only readable by its owner:
+  - PKCS8: PEM encoded PKCS#8 private key
+  - PKCS1: PEM encoded PKCS#1 RSA private key
+  - RAW:   raw bytes of a symmetric key
+  - JWK:   JSON Web Key`,
+		Example: `  okms keys get <key-id> --wrapping-key-id <transport-id> --wrapped-key-format PKCS8 \
+      --unwrap-with @transport.pem --out key.pem`,
+		Args: cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
+			if unwrapWith != "" && wrappingKeyID == "" {
+				exit.OnErr(errors.New("The --unwrap-with flag requires --wrapping-key-id"))
+			}
 
 			// When a wrapping key is provided, export the key material in wrapped (encrypted) form.
 			if wrappingKeyID != "" {
-				wrapKeyId := exit.OnErr2(uuid.Parse(wrappingKeyID))
+				wrapKeyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), wrappingKeyID))
 
 				algo := types.WrappingAlgorithms(wrappingAlgorithm)
 				if !algo.Valid() {
@@ -180,7 +273,22 @@ func newGetServiceKeyCmd() *cobra.Command {
 					exit.OnErr(fmt.Errorf("Invalid wrapped key format %q, expected one of [JWK|RAW|PKCS1|PKCS8]", wrappedKeyFormat))
 				}
 
+				var transportKey *rsa.PrivateKey
+				if unwrapWith != "" {
+					var ok bool
+					transportKey, ok = exit.OnErr2(x509utils.ParsePrivateKey(flagsmgmt.BytesFromArg(unwrapWith, 32*1024))).(*rsa.PrivateKey)
+					if !ok {
+						exit.OnErr(errors.New("The transport private key must be an RSA key"))
+					}
+				}
+
 				wrappedKeys := exit.OnErr2(common.Client().GetWrappedServiceKey(cmd.Context(), common.GetOkmsId(), keyId, wrapKeyId, format, algo))
+				if transportKey != nil {
+					material := exit.OnErr2(unwrapKeyMaterial(wrappedKeys, transportKey, format))
+					exit.OnErr(fsutils.WritePrivateFile(outFile, material))
+					pterm.Warning.WithWriter(os.Stderr).Printfln("The plaintext key material has been written to %s. Keep it safe, and delete it when no longer needed.", outFile)
+					return
+				}
 				if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
 					output.JsonPrint(wrappedKeys)
 				} else {
@@ -203,6 +311,9 @@ func newGetServiceKeyCmd() *cobra.Command {
 	cmd.Flags().StringVar(&wrappingKeyID, "wrapping-key-id", "", "ID of the transport (wrapping) key used to encrypt the exported key material. When set, the key is exported in wrapped form instead of returning metadata")
 	cmd.Flags().StringVar(&wrappingAlgorithm, "wrapping-algorithm", string(types.RSAOAEP256), "Key wrapping algorithm [RSA-OAEP|RSA-OAEP-256]")
 	cmd.Flags().StringVar(&wrappedKeyFormat, "wrapped-key-format", string(types.JWK), "Format of the plaintext key material before wrapping [JWK|RAW|PKCS1|PKCS8]")
+	cmd.Flags().StringVar(&unwrapWith, "unwrap-with", "", "PEM encoded private part of the transport key used to unwrap the exported key locally, as a value, @path or - for stdin")
+	cmd.Flags().StringVar(&outFile, "out", "", "Path of the file to write the unwrapped plaintext key material to")
+	cmd.MarkFlagsRequiredTogether("unwrap-with", "out")
 
 	return cmd
 }
@@ -214,7 +325,7 @@ func newExportPublicKeyCmd() *cobra.Command {
 		Short: "Export public key material",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
 			resp := exit.OnErr2(common.Client().GetServiceKey(cmd.Context(), common.GetOkmsId(), keyId, utils.PtrTo(types.Jwk)))
 			if resp.Keys == nil || len(*resp.Keys) == 0 {
 				exit.OnErr(errors.New("Server returned no key"))
@@ -269,13 +380,15 @@ func newExportPublicKeyCmd() *cobra.Command {
 
 func newImportServiceKeyCmd() *cobra.Command {
 	var (
-		keyUsage         restflags.KeyUsageList
-		symmetric        bool
-		keyContext       string
-		keyID            string
-		wrappingKeyID    string
-		wrappedKeyFormat string
-		extractable      bool
+		keyUsage          restflags.KeyUsageList
+		symmetric         bool
+		keyContext        string
+		keyID             string
+		wrappingKeyID     string
+		wrappedKeyFormat  string
+		wrapLocally       bool
+		wrappingAlgorithm string
+		extractable       bool
 	)
 	cmd := &cobra.Command{
 		Use:   "import NAME KEY",
@@ -301,6 +414,12 @@ Wrapped import (--wrapping-key-id):
   key that was wrapped [JWK|RAW|PKCS1|PKCS8]. The KMS unwraps the material and
   infers the key type, size and curve from it.
 
+  With --wrap-locally, KEY is the plaintext key: a PEM encoded PKCS8, PKCS1 or
+  SEC1 private key, or a base64 encoded symmetric key with --symmetric. The CLI
+  wraps it itself: it fetches the public part of the transport key, and
+  builds the JWE with --wrapping-algorithm and A256GCM content encryption, in the
+  --wrapped-key-format layout. The plaintext key is never sent to the KMS.
+
 In both modes KEY may be given inline, as @path to read from a file, or - to
 read from stdin.`,
 		Example: `  # Plain import of a PEM encoded RSA private key
@@ -314,7 +433,11 @@ read from stdin.`,
 
   # Wrapped import of PKCS8 key material wrapped with transport key <transport-id>
   okms keys import --usage encrypt,decrypt --wrapping-key-id <transport-id> \
-      --wrapped-key-format PKCS8 my-imported @wrapped.jwe`,
+      --wrapped-key-format PKCS8 my-imported @wrapped.jwe
+
+  # Wrapped import of a PEM encoded private key, wrapped locally
+  okms keys import --usage sign,verify --wrapping-key-id <transport-id> \
+      --wrapped-key-format PKCS8 my-imported --wrap-locally @private.pem`,
 		Args: cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			if keyContext == "" {
@@ -329,16 +452,33 @@ read from stdin.`,
 			}
 			opts = append(opts, okms.WithExtractable(extractable))
 
+			if wrapLocally && wrappingKeyID == "" {
+				exit.OnErr(errors.New("The --wrap-locally flag requires --wrapping-key-id"))
+			}
+			if symmetric && wrappingKeyID != "" && !wrapLocally {
+				exit.OnErr(errors.New("The --symmetric flag cannot be used to import wrapped key material, unless wrapped with --wrap-locally"))
+			}
+
 			var resp *types.GetServiceKeyResponse
 			switch {
 			case wrappingKeyID != "":
 				// KEY is wrapped (encrypted) key material as a JWE Compact Serialization string.
-				wrapKeyId := exit.OnErr2(uuid.Parse(wrappingKeyID))
+				wrapKeyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), wrappingKeyID))
 				format := types.KeyFormatTypes(wrappedKeyFormat)
 				if !format.Valid() {
 					exit.OnErr(fmt.Errorf("Invalid wrapped key format %q, expected one of [JWK|RAW|PKCS1|PKCS8]", wrappedKeyFormat))
 				}
 				ciphertext := strings.TrimSpace(string(key))
+				if wrapLocally {
+					algo := types.WrappingAlgorithms(wrappingAlgorithm)
+					if !algo.Valid() {
+						exit.OnErr(fmt.Errorf("Invalid wrapping algorithm %q, expected one of [RSA-OAEP|RSA-OAEP-256]", wrappingAlgorithm))
+					}
+					if symmetric {
+						key = exit.OnErr2(base64.StdEncoding.DecodeString(ciphertext))
+					}
+					ciphertext = exit.OnErr2(wrapKeyLocally(cmd.Context(), wrapKeyId, key, symmetric, format, algo))
+				}
 				resp = exit.OnErr2(common.Client().ImportWrappedServiceKey(cmd.Context(), common.GetOkmsId(), wrapKeyId, ciphertext, format, args[0], keyContext, keyUsage.ToCryptographicUsage(), opts...))
 			case !symmetric:
 				resp = exit.OnErr2(common.Client().ImportKeyPairPEM(cmd.Context(), common.GetOkmsId(), key, args[0], keyContext, keyUsage.ToCryptographicUsage(), opts...))
@@ -360,8 +500,9 @@ read from stdin.`,
 	cmd.Flags().BoolVarP(&symmetric, "symmetric", "S", false, "Import a base64 encoded symmetric key")
 	cmd.Flags().StringVar(&wrappingKeyID, "wrapping-key-id", "", "ID of the transport (wrapping) key that was used to wrap KEY. When set, KEY is imported as wrapped (JWE) key material and its type is inferred by the KMS")
 	cmd.Flags().StringVar(&wrappedKeyFormat, "wrapped-key-format", string(types.JWK), "Format of the plaintext key material that was wrapped [JWK|RAW|PKCS1|PKCS8]")
+	cmd.Flags().BoolVar(&wrapLocally, "wrap-locally", false, "KEY is plaintext key material, wrapped locally with the transport key --wrapping-key-id before being imported")
+	cmd.Flags().StringVar(&wrappingAlgorithm, "wrapping-algorithm", string(types.RSAOAEP256), "Key wrapping algorithm used with --wrap-locally [RSA-OAEP|RSA-OAEP-256]")
 	cmd.Flags().BoolVar(&extractable, "extractable", false, "Whether the imported key and its material can be extracted (exported plain or wrapped). Defaults to false.")
-	cmd.MarkFlagsMutuallyExclusive("symmetric", "wrapping-key-id")
 	return cmd
 }
 
@@ -414,34 +555,33 @@ func printServiceKey(resp *types.GetServiceKeyResponse) {
 }
 
 func newDeleteKeyCmd() *cobra.Command {
-	var force bool
+	var (
+		force bool
+		bulk  *bulkParams
+	)
 	cmd := &cobra.Command{
-		Use:     "delete KEY-ID [KEY-ID...]",
+		Use:     "delete [KEY-ID...]",
 		Aliases: []string{"del"},
-		Args:    cobra.MinimumNArgs(1),
+		Args:    cobra.ArbitraryArgs,
 		Short:   "Delete one or more deactivated service keys. This action is irreversible",
+		Long: `Delete one or more deactivated service keys. This action is irreversible.
+
+` + bulkHelp,
+		Example: `  okms keys delete --filter 'name=tmp-* state=deactivated' --dry-run
+  okms keys delete --filter 'name=tmp-* created-before=2025-01-01' --force --concurrency 8`,
 		Run: func(cmd *cobra.Command, args []string) {
-			var errs []error
-			for _, id := range args {
-				keyId, err := uuid.Parse(id)
-				if err != nil {
-					errs = append(errs, fmt.Errorf("Invalid Key ID %q: %w", id, err))
-					continue
-				}
+			bulk.run(cmd, args, "delete", func(ctx context.Context, keyId uuid.UUID) error {
 				if force {
-					if err := common.Client().DeactivateServiceKey(cmd.Context(), common.GetOkmsId(), keyId, types.Unspecified); err != nil {
-						errs = append(errs, fmt.Errorf("Failed to deactivate key %q: %w", id, err))
-						continue
+					if err := common.Client().DeactivateServiceKey(ctx, common.GetOkmsId(), keyId, types.Unspecified); err != nil {
+						return fmt.Errorf("Failed to deactivate key: %w", err)
 					}
 				}
-				if err := common.Client().DeleteServiceKey(cmd.Context(), common.GetOkmsId(), keyId); err != nil {
-					errs = append(errs, fmt.Errorf("Failed to delete key %q: %w", id, err))
-				}
-			}
-			exit.OnErr(errors.Join(errs...))
+				return common.Client().DeleteServiceKey(ctx, common.GetOkmsId(), keyId)
+			})
 		},
 	}
 	cmd.Flags().BoolVar(&force, "force", false, "Force delete on active keys by deactivating them first with an unspecified reason")
+	bulk = setBulkFlags(cmd)
 
 	return cmd
 }
@@ -449,49 +589,44 @@ func newDeleteKeyCmd() *cobra.Command {
 func newDeactivateKeyCmd() *cobra.Command {
 	//lint:ignore ST1023 for readability
 	var revocationReason = restflags.Unspecified
+	var bulk *bulkParams
 	cmd := &cobra.Command{
-		Use:   "deactivate KEY-ID [KEY-ID...]",
-		Args:  cobra.MinimumNArgs(1),
+		Use:   "deactivate [KEY-ID...]",
+		Args:  cobra.ArbitraryArgs,
 		Short: "Deactivate one or more service keys",
+		Long: `Deactivate one or more service keys.
+
+` + bulkHelp,
+		Example: `  okms keys deactivate --filter 'name=tmp-* state=active' --reason superseded --dry-run`,
 		Run: func(cmd *cobra.Command, args []string) {
-			var errs []error
-			for _, id := range args {
-				keyId, err := uuid.Parse(id)
-				if err != nil {
-					errs = append(errs, fmt.Errorf("Invalid Key ID %q: %w", id, err))
-					continue
-				}
-				if err := common.Client().DeactivateServiceKey(cmd.Context(), common.GetOkmsId(), keyId, revocationReason.RestModel()); err != nil {
-					errs = append(errs, fmt.Errorf("Failed to deactivate key %q: %w", id, err))
-				}
-			}
-			exit.OnErr(errors.Join(errs...))
+			bulk.run(cmd, args, "deactivate", func(ctx context.Context, keyId uuid.UUID) error {
+				return common.Client().DeactivateServiceKey(ctx, common.GetOkmsId(), keyId, revocationReason.RestModel())
+			})
 		},
 	}
 	cmd.Flags().Var(&revocationReason, "reason", "The reason of revocation")
+	bulk = setBulkFlags(cmd)
 	return cmd
 }
 
 func newActivateKeyCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "activate KEY-ID [KEY-ID...]",
-		Args:  cobra.MinimumNArgs(1),
+	var bulk *bulkParams
+	cmd := &cobra.Command{
+		Use:   "activate [KEY-ID...]",
+		Args:  cobra.ArbitraryArgs,
 		Short: "Activate one or more service keys",
+		Long: `Activate one or more service keys.
+
+` + bulkHelp,
+		Example: `  okms keys activate --filter 'name=app-* state=deactivated'`,
 		Run: func(cmd *cobra.Command, args []string) {
-			var errs []error
-			for _, id := range args {
-				keyId, err := uuid.Parse(id)
-				if err != nil {
-					errs = append(errs, fmt.Errorf("Invalid Key ID %q: %w", id, err))
-					continue
-				}
-				if err := common.Client().ActivateServiceKey(cmd.Context(), common.GetOkmsId(), keyId); err != nil {
-					errs = append(errs, fmt.Errorf("Failed to activate key %q: %w", id, err))
-				}
-			}
-			exit.OnErr(errors.Join(errs...))
+			bulk.run(cmd, args, "activate", func(ctx context.Context, keyId uuid.UUID) error {
+				return common.Client().ActivateServiceKey(ctx, common.GetOkmsId(), keyId)
+			})
 		},
 	}
+	bulk = setBulkFlags(cmd)
+	return cmd
 }
 
 func newUpdateKeyCmd() *cobra.Command {
@@ -505,7 +640,7 @@ func newUpdateKeyCmd() *cobra.Command {
 		Args:  cobra.ExactArgs(1),
 		Short: "Update a service key",
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
 			body := types.PatchServiceKeyRequest{}
 			if cmd.Flags().Changed("name") {
 				body.Name = utils.PtrTo(name)
@@ -532,6 +667,7 @@ type KeyAttr struct {
 	ActivatedAt   *time.Time
 	CompromisedAt *time.Time
 	DeactivatedAt *time.Time
+	Extractable   *bool
 }
 
 func getCommonKeyAttributes(key *types.GetServiceKeyResponse) KeyAttr {
@@ -561,6 +697,9 @@ func getCommonKeyAttributes(key *types.GetServiceKeyResponse) KeyAttr {
 		if state, ok := (*key.Attributes)["state"].(string); ok {
 			keyAttr.State = types.KeyStates(state)
 		}
+		if extractable, ok := (*key.Attributes)["extractable"].(bool); ok {
+			keyAttr.Extractable = &extractable
+		}
 	}
 	return keyAttr
 }
@@ -0,0 +1,412 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/olekukonko/tablewriter"
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/config"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/ovh/okms-sdk-go"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/spf13/cobra"
+)
+
+const defaultMigrationTransportName = "okms-migration-transport"
+
+// migrationProbeContext is the encryption context of the message used to verify the migrated symmetric keys.
+const migrationProbeContext = "okms-migration-verification"
+
+// Key migration statuses
+const (
+	migrationMigrated = "migrated"
+	migrationExisting = "exists"
+	migrationFailed   = "failed"
+)
+
+func newMigrateCmd() *cobra.Command {
+	var (
+		fromProfile      string
+		toProfile        string
+		nameFilter       string
+		transportKeyId   string
+		transportKeyName string
+		keyContext       string
+		extractable      bool
+		keepId           bool
+		reportFile       string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "migrate [KEY-ID...]",
+		Short: "Migrate extractable keys from one domain to another",
+		Long: `Migrate extractable keys from the domain of a configuration profile to the domain of another one.
+
+The keys are selected by ID, or by name with --name-filter, a glob pattern like "app-*". Each key is exported from
+the source domain wrapped with a transport key of the target domain, and imported in the target domain, so that
+its plaintext material never leaves the KMS. The source keys must have been created as extractable.
+
+The transport key is the RSA key --transport-key-id of the target domain, or the active RSA key named
+--transport-key-name, which is created if it does not exist. Its public part is temporarily imported in the source
+domain to export the keys.
+
+The migrated keys keep their name, usage and, unless --keep-id=false, their ID. A key whose ID already exists in the
+target domain has been migrated before: it is only verified, so that an interrupted migration can be run again.
+The key context is not part of the key metadata: it defaults to the key's name, like on key creation, or is set
+with --context.
+
+Each migrated key is verified against the source key, and the results are written as a JSON report with --report.
+The key pairs are verified by comparing their public keys, and the symmetric keys by decrypting with the migrated
+key a message encrypted with the source key: symmetric keys without the encrypt and decrypt usages are reported as
+not verified.
+The environment variables apply to both profiles.`,
+		Example: `  okms keys migrate --from-profile old --to-profile new --name-filter 'app-*' --report migration.json`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 && nameFilter == "" {
+				exit.OnErr(errors.New("Specify the keys to migrate by ID, or with --name-filter"))
+			}
+			if _, err := path.Match(nameFilter, ""); err != nil {
+				exit.OnErr(fmt.Errorf("Invalid name filter %q: %w", nameFilter, err))
+			}
+
+			src := migrationDomain{Profile: fromProfile, client: common.Client(), OkmsId: common.GetOkmsId()}
+			if fromProfile != "" {
+				src.client, src.OkmsId = exit.OnErr3(common.ProfileClient(cmd, fromProfile))
+			} else {
+				src.Profile = config.SelectedProfile(cmd)
+			}
+			dst := migrationDomain{Profile: toProfile}
+			dst.client, dst.OkmsId = exit.OnErr3(common.ProfileClient(cmd, toProfile))
+
+			m := &migration{src: src, dst: dst, keyContext: keyContext, extractable: extractable, keepId: keepId}
+			keys := exit.OnErr2(m.selectKeys(cmd.Context(), args, nameFilter))
+			exit.OnErr(m.setupTransport(cmd.Context(), transportKeyId, transportKeyName))
+
+			report := migrationReport{From: src, To: dst, TransportKeyId: m.dstWrapKeyId, Keys: []migrationResult{}}
+			for _, key := range keys {
+				report.Keys = append(report.Keys, m.migrate(cmd.Context(), key))
+			}
+			m.cleanupTransport(cmd.Context())
+
+			if reportFile != "" {
+				exit.OnErr(os.WriteFile(reportFile, exit.OnErr2(json.MarshalIndent(report, "", "  ")), 0o644))
+			}
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(report)
+			} else {
+				printMigrationReport(report)
+			}
+
+			failed := 0
+			for _, res := range report.Keys {
+				if !res.Verified {
+					failed++
+				}
+			}
+			if failed > 0 {
+				exit.OnErr(fmt.Errorf("%d of %d keys failed to migrate or to be verified", failed, len(report.Keys)))
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&fromProfile, "from-profile", "", "Profile of the source domain. Defaults to the current profile")
+	cmd.Flags().StringVar(&toProfile, "to-profile", "", "Profile of the target domain")
+	cmd.Flags().StringVar(&nameFilter, "name-filter", "", "Migrate the active keys whose name matches this glob pattern")
+	cmd.Flags().StringVar(&transportKeyId, "transport-key-id", "", "ID of the RSA transport key of the target domain")
+	cmd.Flags().StringVar(&transportKeyName, "transport-key-name", defaultMigrationTransportName, "Name of the RSA transport key of the target domain, created if it does not exist")
+	cmd.Flags().StringVar(&keyContext, "context", "", "Context of the migrated keys. Defaults to each key's name")
+	cmd.Flags().BoolVar(&extractable, "extractable", false, "Whether the migrated keys can be extracted (exported plain or wrapped). Defaults to false.")
+	cmd.Flags().BoolVar(&keepId, "keep-id", true, "Keep the key IDs in the target domain, when not already taken")
+	cmd.Flags().StringVar(&reportFile, "report", "", "Path of the JSON verification report to write")
+	_ = cmd.MarkFlagRequired("to-profile")
+	cmd.MarkFlagsMutuallyExclusive("transport-key-id", "transport-key-name")
+	return cmd
+}
+
+type migrationDomain struct {
+	Profile string    `json:"profile"`
+	OkmsId  uuid.UUID `json:"okmsId"`
+	client  *okms.Client
+}
+
+type migrationReport struct {
+	From           migrationDomain   `json:"from"`
+	To             migrationDomain   `json:"to"`
+	TransportKeyId uuid.UUID         `json:"transportKeyId"`
+	Keys           []migrationResult `json:"keys"`
+}
+
+type migrationResult struct {
+	SourceId uuid.UUID  `json:"sourceId"`
+	Name     string     `json:"name"`
+	TargetId *uuid.UUID `json:"targetId,omitempty"`
+	Status   string     `json:"status"`
+	Verified bool       `json:"verified"`
+	Error    string     `json:"error,omitempty"`
+}
+
+type migration struct {
+	src, dst    migrationDomain
+	keyContext  string
+	extractable bool
+	keepId      bool
+
+	dstWrapKeyId uuid.UUID
+	srcWrapKeyId uuid.UUID
+	// srcWrapKeyImported is set when the public part of the transport key was imported in the source domain,
+	// and must be deleted after the migration.
+	srcWrapKeyImported bool
+}
+
+// selectKeys returns the source keys given by ID, followed by the active source keys whose name matches filter.
+func (m *migration) selectKeys(ctx context.Context, ids []string, filter string) ([]*types.GetServiceKeyResponse, error) {
+	var keys []*types.GetServiceKeyResponse
+	resolver := common.NewKeyResolver(m.src.client, m.src.OkmsId, m.src.Profile)
+	for _, id := range ids {
+		keyId, err := resolver.Resolve(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		key, err := m.src.client.GetServiceKey(ctx, m.src.OkmsId, keyId, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get key %q: %w", id, err)
+		}
+		keys = append(keys, key)
+	}
+	if filter == "" {
+		return keys, nil
+	}
+	for key, err := range m.src.client.ListAllServiceKeys(m.src.OkmsId, nil, utils.PtrTo(types.KeyStatesActive)).Iter(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := path.Match(filter, key.Name); ok && !slices.ContainsFunc(keys, func(k *types.GetServiceKeyResponse) bool { return k.Id == key.Id }) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// setupTransport finds or creates the transport key of the target domain, and makes its public part
+// available to the source domain.
+func (m *migration) setupTransport(ctx context.Context, id, name string) error {
+	if id != "" {
+		var err error
+		if m.dstWrapKeyId, err = common.NewKeyResolver(m.dst.client, m.dst.OkmsId, m.dst.Profile).Resolve(ctx, id); err != nil {
+			return fmt.Errorf("Invalid transport key: %w", err)
+		}
+	} else {
+		for key, err := range m.dst.client.ListAllServiceKeys(m.dst.OkmsId, nil, utils.PtrTo(types.KeyStatesActive)).Iter(ctx) {
+			if err != nil {
+				return err
+			}
+			if key.Name == name && key.Type == types.RSA && key.Class != nil && *key.Class == types.KEYPAIR {
+				m.dstWrapKeyId = key.Id
+				break
+			}
+		}
+		if m.dstWrapKeyId == uuid.Nil {
+			key, err := m.dst.client.CreateImportServiceKey(ctx, m.dst.OkmsId, nil, types.CreateImportServiceKeyRequest{
+				Context:    &name,
+				Name:       name,
+				Type:       utils.PtrTo(types.RSA),
+				Size:       utils.PtrTo(types.N4096),
+				Operations: &[]types.CryptographicUsages{types.WrapKey, types.UnwrapKey},
+			})
+			if err != nil {
+				return fmt.Errorf("Failed to create the transport key: %w", err)
+			}
+			m.dstWrapKeyId = key.Id
+		}
+	}
+
+	if m.src.OkmsId == m.dst.OkmsId {
+		m.srcWrapKeyId = m.dstWrapKeyId
+		return nil
+	}
+	pub, err := m.dst.client.ExportPublicKey(ctx, m.dst.OkmsId, m.dstWrapKeyId)
+	if err != nil {
+		return fmt.Errorf("Failed to export the transport key: %w", err)
+	}
+	key, err := m.src.client.ImportKey(ctx, m.src.OkmsId, pub, name, name, []types.CryptographicUsages{types.WrapKey})
+	if err != nil {
+		return fmt.Errorf("Failed to import the transport key in the source domain: %w", err)
+	}
+	m.srcWrapKeyId = key.Id
+	m.srcWrapKeyImported = true
+	return nil
+}
+
+// cleanupTransport deletes the public part of the transport key from the source domain, if it was imported.
+func (m *migration) cleanupTransport(ctx context.Context) {
+	if !m.srcWrapKeyImported {
+		return
+	}
+	err := m.src.client.DeactivateServiceKey(ctx, m.src.OkmsId, m.srcWrapKeyId, types.Unspecified)
+	if err == nil {
+		err = m.src.client.DeleteServiceKey(ctx, m.src.OkmsId, m.srcWrapKeyId)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to delete the transport key %s from the source domain: %s\n", m.srcWrapKeyId, err)
+	}
+}
+
+// migrate exports the key from the source domain, imports it in the target domain, and verifies the result.
+func (m *migration) migrate(ctx context.Context, key *types.GetServiceKeyResponse) migrationResult {
+	res := migrationResult{SourceId: key.Id, Name: key.Name, Status: migrationFailed}
+	if key.Id == m.srcWrapKeyId || key.Id == m.dstWrapKeyId && m.src.OkmsId == m.dst.OkmsId {
+		res.Error = "The transport key cannot be migrated"
+		return res
+	}
+
+	var (
+		opts     []okms.ServiceKeyOption
+		imported *types.GetServiceKeyResponse
+		err      error
+	)
+	status := migrationMigrated
+	// Within a domain, the key IDs are always taken
+	if m.keepId && m.src.OkmsId != m.dst.OkmsId {
+		// A key with the same ID in the target domain comes from a previous migration, and is only verified
+		imported, err = m.dst.client.GetServiceKey(ctx, m.dst.OkmsId, key.Id, nil)
+		if err == nil {
+			status = migrationExisting
+		} else if okms.ErrStatusCode(err) != http.StatusNotFound {
+			res.Error = fmt.Sprintf("Failed to look for the key in the target domain: %s", err)
+			return res
+		}
+		opts = append(opts, okms.WithKeyID(key.Id))
+	}
+	if status == migrationMigrated {
+		if imported, err = m.importKey(ctx, key, opts...); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+	}
+	res.TargetId = &imported.Id
+	res.Status = status
+
+	if err := m.verify(ctx, key, imported); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Verified = true
+	return res
+}
+
+// importKey copies the source key to the target domain, wrapped with the transport key unless it is a public key.
+func (m *migration) importKey(ctx context.Context, key *types.GetServiceKeyResponse, opts ...okms.ServiceKeyOption) (*types.GetServiceKeyResponse, error) {
+	keyContext := m.keyContext
+	if keyContext == "" {
+		keyContext = key.Name
+	}
+	var ops []types.CryptographicUsages
+	if key.Operations != nil {
+		ops = *key.Operations
+	}
+	opts = append(opts, okms.WithExtractable(m.extractable))
+
+	if key.Class != nil && *key.Class == types.PUBLICKEY {
+		pub, err := m.src.client.ExportPublicKey(ctx, m.src.OkmsId, key.Id)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to export the key: %w", err)
+		}
+		return m.dst.client.ImportKey(ctx, m.dst.OkmsId, pub, key.Name, keyContext, ops, opts...)
+	}
+
+	format := types.PKCS8
+	if key.Type == types.Oct {
+		format = types.RAW
+	}
+	wrapped, err := m.src.client.GetWrappedServiceKey(ctx, m.src.OkmsId, key.Id, m.srcWrapKeyId, format, types.RSAOAEP256)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to export the key: %w", err)
+	}
+	imported, err := m.dst.client.ImportWrappedServiceKey(ctx, m.dst.OkmsId, m.dstWrapKeyId, wrapped[0].Ciphertext, format, key.Name, keyContext, ops, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to import the key: %w", err)
+	}
+	return imported, nil
+}
+
+// verify checks that the imported key has the same type, size, curve and usage as the source key, and the same
+// public key for key pairs. The material of symmetric keys is compared by encrypting a random message with the
+// source key and decrypting it with the imported one, which requires both the encrypt and decrypt usages.
+func (m *migration) verify(ctx context.Context, src, dst *types.GetServiceKeyResponse) error {
+	switch {
+	case src.Type != dst.Type:
+		return fmt.Errorf("Key type mismatch: %s != %s", src.Type, dst.Type)
+	case src.Size != nil && dst.Size != nil && *src.Size != *dst.Size:
+		return fmt.Errorf("Key size mismatch: %d != %d", *src.Size, *dst.Size)
+	case src.Curve != nil && dst.Curve != nil && *src.Curve != *dst.Curve:
+		return fmt.Errorf("Key curve mismatch: %s != %s", *src.Curve, *dst.Curve)
+	case src.Operations != nil && dst.Operations != nil && !sameUsages(*src.Operations, *dst.Operations):
+		return errors.New("Key usage mismatch")
+	}
+	if src.Type == types.Oct {
+		return m.verifySymmetric(ctx, src, dst)
+	}
+	srcPub, err := m.src.client.ExportPublicKey(ctx, m.src.OkmsId, src.Id)
+	if err != nil {
+		return err
+	}
+	dstPub, err := m.dst.client.ExportPublicKey(ctx, m.dst.OkmsId, dst.Id)
+	if err != nil {
+		return err
+	}
+	if !x509utils.PublicKeysEqual(srcPub, dstPub) {
+		return errors.New("Public key mismatch")
+	}
+	return nil
+}
+
+func (m *migration) verifySymmetric(ctx context.Context, src, dst *types.GetServiceKeyResponse) error {
+	if src.Operations == nil || !slices.Contains(*src.Operations, types.Encrypt) || !slices.Contains(*src.Operations, types.Decrypt) {
+		return errors.New("The material of symmetric keys without the encrypt and decrypt usages cannot be verified")
+	}
+	probe := make([]byte, 32)
+	_, _ = rand.Read(probe)
+	ciphertext, err := m.src.client.Encrypt(ctx, m.src.OkmsId, src.Id, migrationProbeContext, probe)
+	if err != nil {
+		return fmt.Errorf("Failed to encrypt with the source key: %w", err)
+	}
+	plaintext, err := m.dst.client.Decrypt(ctx, m.dst.OkmsId, dst.Id, migrationProbeContext, ciphertext)
+	if err != nil || !bytes.Equal(plaintext, probe) {
+		return errors.Join(errors.New("Key material mismatch"), err)
+	}
+	return nil
+}
+
+func sameUsages(a, b []types.CryptographicUsages) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+func printMigrationReport(report migrationReport) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"Source ID", "Name", "Target ID", "Status", "Verified", "Error"})
+	for _, res := range report.Keys {
+		var targetId string
+		if res.TargetId != nil {
+			targetId = res.TargetId.String()
+		}
+		exit.OnErr(table.Append([]string{res.SourceId.String(), res.Name, targetId, res.Status, fmt.Sprint(res.Verified), res.Error}))
+	}
+	exit.OnErr(table.Render())
+}
@@ -0,0 +1,362 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/olekukonko/tablewriter"
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/config"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/keyrotation"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/spf13/cobra"
+)
+
+func newPolicyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Manage the rotation policies of the keys",
+		Long: `Manage the rotation policies of the keys.
+
+The KMS has no rotation concept, so the rotation policies are stored per profile in the configuration file, and are
+enforced by "okms keys policy check". A key is due for rotation once its rotation period has elapsed since its
+creation. The durations are given in days like 90d, in weeks like 2w, or like 36h.`,
+	}
+	cmd.AddCommand(
+		newPolicySetCmd(),
+		newPolicyRemoveCmd(),
+		newPolicyCheckCmd(),
+	)
+	return cmd
+}
+
+func newPolicySetCmd() *cobra.Command {
+	var (
+		rotateEvery string
+		warnBefore  string
+		keyContext  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "set KEY-ID --rotate-every PERIOD",
+		Short: "Define or replace the rotation policy of a key",
+		Long: `Define or replace the rotation policy of a key. The key reference is resolved once, and the policy is
+stored for its key ID.
+
+` + common.KeyRefHelp,
+		Example: `  okms keys policy set name:app-signing --rotate-every 90d --warn-before 14d`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			exit.OnErr2(keyrotation.ParsePolicy(rotateEvery, warnBefore))
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
+			key := exit.OnErr2(common.Client().GetServiceKey(cmd.Context(), common.GetOkmsId(), keyId, nil))
+			if key.Class != nil && *key.Class == types.PUBLICKEY {
+				exit.OnErr(errors.New("Public keys cannot be rotated"))
+			}
+			profile := config.SelectedProfile(cmd)
+			file := loadConfigFile(cmd)
+			exit.OnErr(config.SetKeyRotationPolicy(profile, keyId.String(), config.KeyRotationPolicy{
+				RotateEvery: rotateEvery,
+				WarnBefore:  warnBefore,
+				Context:     keyContext,
+			}))
+			exit.OnErr(config.WriteToFile(file))
+			fmt.Printf("Key %s is rotated every %s\n", keyId, rotateEvery)
+		},
+	}
+
+	cmd.Flags().StringVar(&rotateEvery, "rotate-every", "", "Rotation period of the key, like 90d")
+	cmd.Flags().StringVar(&warnBefore, "warn-before", "", "How long before its rotation the key is reported as due soon, like 14d")
+	cmd.Flags().StringVar(&keyContext, "context", "", "Context of the successor keys. Defaults to the key's name")
+	_ = cmd.MarkFlagRequired("rotate-every")
+	return cmd
+}
+
+func newPolicyRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "remove KEY-ID [KEY-ID...]",
+		Aliases: []string{"rm"},
+		Short:   "Remove the rotation policies of keys",
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			profile := config.SelectedProfile(cmd)
+			file := loadConfigFile(cmd)
+			for _, ref := range args {
+				keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), ref))
+				exit.OnErr(config.RemoveKeyRotationPolicy(profile, keyId.String()))
+			}
+			exit.OnErr(config.WriteToFile(file))
+		},
+	}
+}
+
+// Rotation check statuses, on top of the keyrotation ones.
+const (
+	rotationRotated     = "rotated"
+	rotationDeactivated = "deactivated"
+	rotationInactive    = "inactive"
+	rotationFailed      = "failed"
+)
+
+type rotationResult struct {
+	Id          *uuid.UUID `json:"id,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	RotateEvery string     `json:"rotateEvery,omitempty"`
+	DueAt       *time.Time `json:"dueAt,omitempty"`
+	Status      string     `json:"status"`
+	SuccessorId *uuid.UUID `json:"successorId,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func newPolicyCheckCmd() *cobra.Command {
+	var (
+		rotate      bool
+		gracePeriod string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check the keys against their rotation policy, and rotate the overdue ones",
+		Long: `Check the keys having a rotation policy in the profile, and print their status: ok, due-soon or overdue.
+The command fails if a key is overdue, unless --rotate is set.
+
+With --rotate, each overdue key is rotated:
+  - a successor key is generated with the same name, type, size or curve, usage and protection level, and the context
+    of the policy, which defaults to the key name,
+  - the policy and the key aliases of the profile are moved to the successor,
+  - the key is recorded as retired in the retiredKeys of the profile, and renamed with a -retired-YYYY-MM-DD suffix.
+The retired keys of the profile are then deactivated once the --grace-period has elapsed since their retirement, so
+that the data they protect can be re-encrypted with the successor in the meantime, and removed from the profile.
+Run the command periodically, like from a cron job, to enforce the policies.`,
+		Example: `  okms keys policy check
+  okms keys policy check --rotate --grace-period 30d`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			grace := exit.OnErr2(keyrotation.ParseDuration(gracePeriod))
+			if grace < 0 {
+				exit.OnErr(errors.New("Grace period must be positive"))
+			}
+			profile := config.SelectedProfile(cmd)
+			var file string
+			if rotate {
+				file = loadConfigFile(cmd)
+			}
+			policies := config.KeyRotationPolicies(profile)
+
+			now := time.Now()
+			results := []rotationResult{}
+			for _, keyId := range slices.Sorted(maps.Keys(policies)) {
+				results = append(results, checkRotation(cmd.Context(), profile, keyId, policies[keyId], rotate, now))
+			}
+			if rotate {
+				results = append(results, deactivateRetiredKeys(cmd.Context(), profile, grace, now)...)
+				exit.OnErr(config.WriteToFile(file))
+			}
+
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(results)
+			} else {
+				printRotationResults(results)
+			}
+			counts := map[string]int{}
+			for _, res := range results {
+				counts[res.Status]++
+			}
+			if counts[rotationFailed] > 0 {
+				exit.OnErr(fmt.Errorf("Failed to check or rotate %d keys", counts[rotationFailed]))
+			}
+			if counts[string(keyrotation.StatusOverdue)] > 0 {
+				exit.OnErr(fmt.Errorf("%d keys are overdue for rotation", counts[string(keyrotation.StatusOverdue)]))
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&rotate, "rotate", false, "Rotate the overdue keys, and deactivate the retired keys past their grace period")
+	cmd.Flags().StringVar(&gracePeriod, "grace-period", "7d", "How long the retired keys stay active after their rotation")
+	return cmd
+}
+
+// checkRotation checks a key against its rotation policy, and rotates it if it is overdue and rotate is set.
+func checkRotation(ctx context.Context, profile, id string, stored config.KeyRotationPolicy, rotate bool, now time.Time) rotationResult {
+	res := rotationResult{RotateEvery: stored.RotateEvery, Status: rotationFailed}
+	keyId, err := uuid.Parse(id)
+	if err != nil {
+		res.Error = fmt.Sprintf("Invalid key ID %q", id)
+		return res
+	}
+	res.Id = &keyId
+	policy, err := keyrotation.ParsePolicy(stored.RotateEvery, stored.WarnBefore)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	key, err := common.Client().GetServiceKey(ctx, common.GetOkmsId(), keyId, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Name = key.Name
+	keyAttr := getCommonKeyAttributes(key)
+	if keyAttr.State != types.KeyStatesActive {
+		res.Status = rotationInactive
+		return res
+	}
+	if keyAttr.CreatedAt.IsZero() {
+		res.Error = "The key creation date is unknown"
+		return res
+	}
+	status, dueAt := policy.Check(keyAttr.CreatedAt, now)
+	res.DueAt = &dueAt
+	res.Status = string(status)
+	if status != keyrotation.StatusOverdue || !rotate {
+		return res
+	}
+
+	successorId, err := rotateKey(ctx, profile, key, keyAttr, stored, now)
+	if successorId != uuid.Nil {
+		res.SuccessorId = &successorId
+	}
+	if err != nil {
+		res.Status, res.Error = rotationFailed, err.Error()
+		return res
+	}
+	res.Status = rotationRotated
+	return res
+}
+
+// rotateKey generates the successor of a key, moves the key policy and aliases of the profile to the successor,
+// and records the key as retired in the profile before renaming it.
+func rotateKey(ctx context.Context, profile string, key *types.GetServiceKeyResponse, keyAttr KeyAttr, policy config.KeyRotationPolicy, now time.Time) (uuid.UUID, error) {
+	if key.Class != nil && *key.Class == types.PUBLICKEY {
+		return uuid.Nil, errors.New("Public keys cannot be rotated")
+	}
+	keyContext := policy.Context
+	if keyContext == "" {
+		keyContext = key.Name
+	}
+	body := types.CreateImportServiceKeyRequest{
+		Name:        key.Name,
+		Context:     &keyContext,
+		Type:        &key.Type,
+		Operations:  key.Operations,
+		Extractable: keyAttr.Extractable,
+	}
+	if key.Curve != nil {
+		body.Curve = key.Curve
+	} else {
+		body.Size = key.Size
+	}
+	if key.ProtectionLevel != "" {
+		body.ProtectionLevel = &key.ProtectionLevel
+	}
+	successor, err := common.Client().CreateImportServiceKey(ctx, common.GetOkmsId(), nil, body)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("Failed to generate the successor key: %w", err)
+	}
+
+	// Move the policy first, so that the key is not rotated again by the next check if the renaming fails
+	if err := config.SetKeyRotationPolicy(profile, successor.Id.String(), policy); err != nil {
+		return successor.Id, err
+	}
+	if err := config.RemoveKeyRotationPolicy(profile, key.Id.String()); err != nil {
+		return successor.Id, err
+	}
+	for alias, id := range config.KeyAliases(profile) {
+		if id == key.Id.String() {
+			if err := config.SetKeyAlias(profile, alias, successor.Id.String()); err != nil {
+				return successor.Id, err
+			}
+		}
+	}
+	if err := config.SetRetiredKey(profile, key.Id.String(), now.UTC().Format(time.RFC3339)); err != nil {
+		return successor.Id, err
+	}
+
+	name := keyrotation.RetiredName(key.Name, now)
+	if _, err := common.Client().UpdateServiceKey(ctx, common.GetOkmsId(), key.Id, types.PatchServiceKeyRequest{Name: utils.PtrTo(name)}); err != nil {
+		return successor.Id, fmt.Errorf("Failed to rename the key to %q: %w", name, err)
+	}
+	return successor.Id, nil
+}
+
+// deactivateRetiredKeys deactivates the retired keys of the profile whose grace period is over, and removes them
+// from the profile once deactivated.
+func deactivateRetiredKeys(ctx context.Context, profile string, grace time.Duration, now time.Time) []rotationResult {
+	results := []rotationResult{}
+	retired := config.RetiredKeys(profile)
+	for _, id := range slices.Sorted(maps.Keys(retired)) {
+		res := rotationResult{Status: rotationFailed}
+		keyId, err := uuid.Parse(id)
+		if err != nil {
+			res.Error = fmt.Sprintf("Invalid retired key ID %q", id)
+			results = append(results, res)
+			continue
+		}
+		res.Id = &keyId
+		retiredAt, err := time.Parse(time.RFC3339, retired[id])
+		if err != nil {
+			res.Error = fmt.Sprintf("Invalid retirement date %q", retired[id])
+			results = append(results, res)
+			continue
+		}
+		if !keyrotation.Deactivable(retiredAt, grace, now) {
+			continue
+		}
+		key, err := common.Client().GetServiceKey(ctx, common.GetOkmsId(), keyId, nil)
+		if err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		res.Name = key.Name
+		// A retired key deactivated or deleted by other means is only removed from the profile
+		if getCommonKeyAttributes(key).State == types.KeyStatesActive {
+			if err := common.Client().DeactivateServiceKey(ctx, common.GetOkmsId(), keyId, types.Superseded); err != nil {
+				res.Error = fmt.Sprintf("Failed to deactivate the retired key: %s", err)
+				results = append(results, res)
+				continue
+			}
+			res.Status = rotationDeactivated
+			results = append(results, res)
+		}
+		config.RemoveRetiredKey(profile, id)
+	}
+	return results
+}
+
+func printRotationResults(results []rotationResult) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"ID", "Name", "Rotate Every", "Due At", "Status", "Details"})
+	counts := map[string]int{}
+	for _, res := range results {
+		var id, dueAt, details string
+		if res.Id != nil {
+			id = res.Id.String()
+		}
+		if res.DueAt != nil {
+			dueAt = res.DueAt.Format(time.RFC3339)
+		}
+		if res.SuccessorId != nil {
+			details = "successor " + res.SuccessorId.String()
+		}
+		if res.Error != "" {
+			details = res.Error
+		}
+		counts[res.Status]++
+		exit.OnErr(table.Append([]string{id, res.Name, res.RotateEvery, dueAt, res.Status, details}))
+	}
+	exit.OnErr(table.Render())
+	fmt.Printf("%d ok, %d due soon, %d overdue, %d rotated, %d retired keys deactivated, %d failed\n",
+		counts[string(keyrotation.StatusOk)], counts[string(keyrotation.StatusDueSoon)], counts[string(keyrotation.StatusOverdue)],
+		counts[rotationRotated], counts[rotationDeactivated], counts[rotationFailed])
+}
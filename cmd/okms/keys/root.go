@@ -10,6 +10,7 @@ func CreateCommand(cust common.CustomizeFunc) *cobra.Command {
 		Use:     "keys",
 		Aliases: []string{"key"},
 		Short:   "Manage domain keys",
+		Long:    "Manage domain keys.\n\n" + common.KeyRefHelp,
 	}
 
 	common.SetupRestApiFlags(keysCmd, cust)
@@ -29,6 +30,12 @@ func CreateCommand(cust common.CustomizeFunc) *cobra.Command {
 		newDeactivateKeyCmd(),
 		newDeleteKeyCmd(),
 		newActivateKeyCmd(),
+		newCosignCmd(),
+		newMigrateCmd(),
+		newApplyCmd(),
+		newAuditCmd(),
+		newAliasCmd(),
+		newPolicyCmd(),
 	)
 
 	return keysCmd
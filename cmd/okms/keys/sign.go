@@ -1,6 +1,7 @@
 package keys
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rsa"
 
@@ -10,7 +11,6 @@ import (
 	"io"
 	"math/big"
 
-	"github.com/google/uuid"
 	"github.com/ovh/okms-cli/cmd/okms/common"
 	"github.com/ovh/okms-cli/common/flagsmgmt"
 	"github.com/ovh/okms-cli/common/flagsmgmt/restflags"
@@ -40,7 +40,7 @@ In both cases, DATA can be either plain text, a '-' to read from stdin, or a fil
 
 	signCmd.Run = func(cmd *cobra.Command, args []string) {
 		data := readDigest(params.signatureAlgorithm, args[1], "Signing", noProgress)
-		keyId := exit.OnErr2(uuid.Parse(args[0]))
+		keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
 		signature := exit.OnErr2(common.Client().Sign(cmd.Context(), common.GetOkmsId(), keyId, nil, params.signatureAlgorithm.Alg(), true, data))
 		if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
 			output.JsonPrint(signature)
@@ -79,7 +79,7 @@ SIGNATURE can also be passed from a file or stdin using '-' or '@'. Stdin can ho
 	verifyCmd.Run = func(cmd *cobra.Command, args []string) {
 		data := readDigest(params.signatureAlgorithm, args[1], "Verifying signature", noProgress)
 		signature := flagsmgmt.StringFromArg(args[2], 8192)
-		keyId := exit.OnErr2(uuid.Parse(args[0]))
+		keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[0]))
 		if !local {
 			valid := exit.OnErr2(common.Client().Verify(cmd.Context(), common.GetOkmsId(), keyId, params.signatureAlgorithm.Alg(), true, data, signature))
 			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
@@ -156,7 +156,13 @@ func setSignVerifyCommonFlags(cmd *cobra.Command) *signVerifyParams {
 }
 
 func readDigest(digestAlgorithm restflags.SignatureAlgorithm, input, msg string, noProgress bool) []byte {
-	d := digestAlgorithm.NewHasher()
+	return hashInput(digestAlgorithm.HashAlgorithm(), input, msg, noProgress)
+}
+
+// hashInput hashes the data given as a CLI argument (plain text, '-' for stdin or @file)
+// with the given hash function, optionally displaying a progress bar.
+func hashInput(hash crypto.Hash, input, msg string, noProgress bool) []byte {
+	d := hash.New()
 	reader, size := flagsmgmt.ReaderFromArgWithSize(input)
 	defer reader.Close()
 	var writer io.Writer = d
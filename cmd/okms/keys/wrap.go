@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/utils"
+	"github.com/ovh/okms-cli/common/utils/jwe"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/ovh/okms-sdk-go/types"
+)
+
+// wrapKeyLocally wraps the plaintext key material for the transport key wrapKeyId, and returns the JWE ciphertext
+// to import. The material is a PEM encoded private key, or the raw bytes of a symmetric key.
+func wrapKeyLocally(ctx context.Context, wrapKeyId uuid.UUID, material []byte, symmetric bool, format types.KeyFormatTypes, algo types.WrappingAlgorithms) (string, error) {
+	var key any = material
+	if !symmetric {
+		var err error
+		if key, err = x509utils.ParsePrivateKey(material); err != nil {
+			return "", err
+		}
+	}
+	plaintext, err := encodeKeyMaterial(key, format)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := common.Client().GetServiceKey(ctx, common.GetOkmsId(), wrapKeyId, utils.PtrTo(types.Jwk))
+	if err != nil {
+		return "", err
+	}
+	if resp.Keys == nil || len(*resp.Keys) == 0 {
+		return "", errors.New("Server returned no transport key")
+	}
+	pub, err := (*resp.Keys)[0].PublicKey()
+	if err != nil {
+		return "", err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return "", errors.New("The transport key must be an RSA key")
+	}
+	return jwe.Encrypt(plaintext, rsaPub, string(algo), wrapKeyId.String())
+}
+
+// encodeKeyMaterial encodes a private key or the bytes of a symmetric key in the given wrapped key format.
+func encodeKeyMaterial(key any, format types.KeyFormatTypes) ([]byte, error) {
+	switch format {
+	case types.JWK:
+		jwk, err := privateJwk(key)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(jwk)
+	case types.PKCS8:
+		if _, ok := key.([]byte); ok {
+			return nil, errors.New("The PKCS8 format is only for asymmetric keys")
+		}
+		return x509.MarshalPKCS8PrivateKey(key)
+	case types.PKCS1:
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("The PKCS1 format is only for RSA keys")
+		}
+		return x509.MarshalPKCS1PrivateKey(rsaKey), nil
+	case types.RAW:
+		raw, ok := key.([]byte)
+		if !ok {
+			return nil, errors.New("The RAW format is only for symmetric keys")
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("Invalid wrapped key format %q, expected one of [JWK|RAW|PKCS1|PKCS8]", format)
+}
+
+// privateJwk returns the JWK of a private or symmetric key. Unlike [types.NewJsonWebKey], the EC coordinates and
+// private scalar, and the symmetric key bytes, keep their leading zeros as required by RFC 7518.
+func privateJwk(key any) (*types.JsonWebKeyResponse, error) {
+	b64 := func(b []byte) *string { return utils.PtrTo(base64.RawURLEncoding.EncodeToString(b)) }
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		jwk, err := types.NewJsonWebKey(k, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		jwk.KeyOps = nil
+		return &jwk, nil
+	case *ecdsa.PrivateKey:
+		d, err := k.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		point, err := k.PublicKey.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		// The uncompressed point is 0x04 || X || Y
+		size := len(d)
+		curve := types.Curves(k.Curve.Params().Name)
+		return &types.JsonWebKeyResponse{
+			Kty: types.EC,
+			Crv: &curve,
+			D:   b64(d),
+			X:   b64(point[1 : 1+size]),
+			Y:   b64(point[1+size:]),
+		}, nil
+	case []byte:
+		return &types.JsonWebKeyResponse{Kty: types.Oct, K: b64(k)}, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %T", key)
+}
+
+// unwrapKeyMaterial decrypts the wrapped key entry in the given format with the transport private key, and returns
+// the key material ready to be written to a file: PEM encoded for the PKCS8 and PKCS1 formats, as is for the JWK
+// and RAW formats.
+func unwrapKeyMaterial(wrappedKeys []types.WrappedKeyEntry, transportKey *rsa.PrivateKey, format types.KeyFormatTypes) ([]byte, error) {
+	return common.UnwrapKey(wrappedKeys, transportKey, func(entry types.WrappedKeyEntry, material []byte) ([]byte, error) {
+		if entry.KeyFormatType != "" && entry.KeyFormatType != format {
+			return nil, fmt.Errorf("Unexpected wrapped key format %s", entry.KeyFormatType)
+		}
+		switch format {
+		case types.PKCS8:
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: material}), nil
+		case types.PKCS1:
+			return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: material}), nil
+		}
+		return material, nil
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ovh/kmip-go"
@@ -35,29 +36,50 @@ func SetupKmipFlags(command *cobra.Command, cust CustomizeFunc) {
 	}
 
 	config.SetupEndpointFlags(command, "kmip", func(command *cobra.Command, cfg config.EndpointConfig) {
-		middlewares := []kmipclient.Middleware{}
-		if !*noCcv {
-			middlewares = append(middlewares, kmipclient.CorrelationValueMiddleware(uuid.NewString))
-		}
-		if *debug {
-			middlewares = append(middlewares, kmipclient.DebugMiddleware(os.Stderr, ttlv.MarshalXML))
-		}
-		if *timeout > 0 {
-			middlewares = append(middlewares, kmipclient.TimeoutMiddleware(*timeout))
-		}
-		opts := []kmipclient.Option{
-			kmipclient.WithTlsConfig(cfg.TlsConfig("")),
-			kmipclient.WithMiddlewares(middlewares...),
-			kmipclient.WithTlsCipherSuiteNames(*tls12Ciphers...),
-		}
-		f(&opts)
-		kmipClient = exit.OnErr2(kmipclient.Dial(
-			cfg.Endpoint,
-			opts...,
-		))
+		kmipClient = dial(cfg, *debug, *timeout, *noCcv, *tls12Ciphers, f)
 	})
 }
 
+// AddDialFlags adds the flags of the KMIP connection opened by [Dial] to a command outside of the kmip command group.
+func AddDialFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("kmip-timeout", 0, "Timeout duration for KMIP requests")
+	cmd.Flags().StringArray("kmip-tls12-ciphers", nil, "List of TLS 1.2 ciphers to use for the KMIP connection")
+}
+
+// Dial connects to the KMIP endpoint of the selected configuration profile, for commands outside of the kmip
+// command group. The endpoint flags of cmd configure its own service, so they are ignored.
+func Dial(cmd *cobra.Command) *kmipclient.Client {
+	configFile, _ := cmd.Flags().GetString("config")
+	cfg := exit.OnErr2(config.LoadServiceEndpointConfig(cmd, "kmip", configFile))
+	debug, _ := cmd.Flags().GetBool("debug")
+	timeout, _ := cmd.Flags().GetDuration("kmip-timeout")
+	tls12Ciphers, _ := cmd.Flags().GetStringArray("kmip-tls12-ciphers")
+	return dial(cfg, debug, timeout, false, tls12Ciphers, func(*[]kmipclient.Option) {})
+}
+
+func dial(cfg config.EndpointConfig, debug bool, timeout time.Duration, noCcv bool, tls12Ciphers []string, customize func(*[]kmipclient.Option)) *kmipclient.Client {
+	middlewares := []kmipclient.Middleware{}
+	if !noCcv {
+		middlewares = append(middlewares, kmipclient.CorrelationValueMiddleware(uuid.NewString))
+	}
+	if debug {
+		middlewares = append(middlewares, kmipclient.DebugMiddleware(os.Stderr, ttlv.MarshalXML))
+	}
+	if timeout > 0 {
+		middlewares = append(middlewares, kmipclient.TimeoutMiddleware(timeout))
+	}
+	opts := []kmipclient.Option{
+		kmipclient.WithTlsConfig(cfg.TlsConfig("")),
+		kmipclient.WithMiddlewares(middlewares...),
+		kmipclient.WithTlsCipherSuiteNames(tls12Ciphers...),
+	}
+	customize(&opts)
+	return exit.OnErr2(kmipclient.Dial(
+		cfg.Endpoint,
+		opts...,
+	))
+}
+
 func NewCommand(cust CustomizeFunc) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "kmip",
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/ovh/okms-cli/cmd/okms/configure"
 	"github.com/ovh/okms-cli/cmd/okms/keys"
 	"github.com/ovh/okms-cli/cmd/okms/kmip"
 	"github.com/ovh/okms-cli/cmd/okms/secrets"
 	secretsv2 "github.com/ovh/okms-cli/cmd/okms/secretsV2"
+	"github.com/ovh/okms-cli/cmd/okms/tlsproxy"
 
 	"github.com/ovh/okms-cli/cmd/okms/x509"
 	"github.com/ovh/okms-cli/common/commands"
@@ -42,6 +46,7 @@ func createRootCommand() *cobra.Command {
 		secretsv2.CreateCommand(nil),
 		x509.CreateX509Command(nil),
 		kmip.NewCommand(nil),
+		tlsproxy.CreateCommand(nil),
 		configure.CreateCommand(),
 		commands.NewMarkdownCmd(command),
 		commands.NewVersionCmd(&version, &commit, &date),
@@ -51,7 +56,11 @@ func createRootCommand() *cobra.Command {
 }
 
 func main() {
-	if err := createRootCommand().Execute(); err != nil {
+	// The servers shut down gracefully on SIGINT or SIGTERM. A second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	defer stop()
+	if err := createRootCommand().ExecuteContext(ctx); err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
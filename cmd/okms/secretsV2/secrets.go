@@ -18,28 +18,49 @@ import (
 func secretListCmd() *cobra.Command {
 	var (
 		pageSize uint32
+		limit    int
 	)
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all secrets",
-		Args:  cobra.MinimumNArgs(0),
+		Long: `List all secrets.
+
+With --output ndjson, each secret is printed as a line of JSON as soon as it is fetched, so that large domains are
+listed with constant memory use.`,
+		Example: `  okms secrets list --output ndjson --limit 1000 | jq -r .path`,
+		// The secrets can be streamed as newline delimited JSON
+		Annotations: map[string]string{flagsmgmt.NDJSON_ANNOTATION: "true"},
+		Args:        cobra.MinimumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
+			format := flagsmgmt.OutputFormat(cmd.Flag("output").Value.String())
 			secrets := types.ListSecretV2Response{}
 
+			count := 0
 			for sec, err := range common.Client().ListAllSecrets(common.GetOkmsId(), &pageSize).Iter(cmd.Context()) {
 				exit.OnErr(err)
-				secrets = append(secrets, *sec)
+				if format == flagsmgmt.NDJSON_OUTPUT_FORMAT {
+					output.NdjsonPrint(sec)
+				} else {
+					secrets = append(secrets, *sec)
+				}
+				if count++; limit > 0 && count >= limit {
+					break
+				}
 			}
 
-			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+			switch format {
+			case flagsmgmt.NDJSON_OUTPUT_FORMAT:
+				// Already printed while fetched
+			case flagsmgmt.JSON_OUTPUT_FORMAT:
 				output.JsonPrint(secrets)
-			} else {
+			default:
 				renderList(&secrets)
 			}
 		},
 	}
 
 	cmd.Flags().Uint32Var(&pageSize, "page-size", 100, "Number of secrets to fetch per page (between 10 and 500)")
+	cmd.Flags().IntVar(&limit, "limit", 0, "Maximum number of secrets to list. 0 for no limit")
 	return cmd
 }
 
@@ -0,0 +1,229 @@
+package tlsproxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+)
+
+const (
+	modeTCP  = "tcp"
+	modeHTTP = "http"
+
+	// dialTimeout bounds the TLS handshake with clients, and the connection to the upstream.
+	dialTimeout = 10 * time.Second
+)
+
+func CreateCommand(cust common.CustomizeFunc) *cobra.Command {
+	var (
+		listen         string
+		certFile       string
+		keyId          string
+		upstream       string
+		mode           string
+		maxConcurrency int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "tls-proxy",
+		Short: "TLS terminating reverse proxy whose private key is stored in the KMS",
+		Long: `TLS terminating reverse proxy whose private key is stored in the KMS.
+
+Client connections are accepted on --listen with the certificate chain given with --server-cert, whose private key
+never leaves the KMS: TLS handshake signatures are computed by the KMS key --key-id. The decrypted traffic is
+forwarded to --upstream.
+
+In tcp mode (the default), the connections are forwarded as is. In http mode, the requests are forwarded with
+X-Forwarded-* headers to the upstream, given as host:port or as an http:// or https:// URL.
+
+To bound the calls to the KMS, at most --max-concurrency signatures are requested at the same time. Clients
+resuming a TLS session with a session ticket issued by the proxy do not require a signature.
+
+On SIGINT or SIGTERM, the proxy stops accepting connections, and waits for the pending ones for 10 seconds.
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if mode != modeTCP && mode != modeHTTP {
+				exit.OnErr(fmt.Errorf("Invalid mode %q, expected %s or %s", mode, modeTCP, modeHTTP))
+			}
+			certs := exit.OnErr2(x509utils.LoadCertificates(certFile))
+			id := exit.OnErr2(common.ResolveKeyId(cmd.Context(), keyId))
+			kmsSigner := exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), id))
+			if !x509utils.PublicKeysEqual(certs[0].PublicKey, kmsSigner.Public()) {
+				exit.OnErr(errors.New("The certificate's public key does not match the KMS key"))
+			}
+
+			tlsCert := tls.Certificate{
+				PrivateKey: kmsSigner,
+				Leaf:       certs[0],
+			}
+			if maxConcurrency > 0 {
+				tlsCert.PrivateKey = &limitedSigner{Signer: kmsSigner, sem: make(chan struct{}, maxConcurrency)}
+			}
+			for _, c := range certs {
+				tlsCert.Certificate = append(tlsCert.Certificate, c.Raw)
+			}
+			tlsConfig := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{tlsCert},
+			}
+
+			if mode == modeHTTP {
+				serveHttp(cmd.Context(), listen, tlsConfig, exit.OnErr2(upstreamUrl(upstream)))
+			} else {
+				serveTcp(cmd.Context(), listen, tlsConfig, upstream)
+			}
+		},
+	}
+
+	common.SetupRestApiFlags(cmd, cust)
+
+	cmd.Flags().StringVar(&listen, "listen", ":443", "Address to listen on")
+	cmd.Flags().StringVar(&certFile, "server-cert", "", "Path to the PEM encoded server certificate, followed by its chain")
+	cmd.Flags().StringVar(&keyId, "key-id", "", "ID of the KMS key-pair of the server certificate")
+	cmd.Flags().StringVar(&upstream, "upstream", "", "Upstream address (host:port)")
+	cmd.Flags().StringVar(&mode, "mode", modeTCP, "Proxy mode: tcp or http")
+	cmd.Flags().IntVar(&maxConcurrency, "max-concurrency", 8, "Maximum number of concurrent signatures requested to the KMS. 0 for unlimited")
+	_ = cmd.MarkFlagRequired("server-cert")
+	_ = cmd.MarkFlagRequired("key-id")
+	_ = cmd.MarkFlagRequired("upstream")
+
+	return cmd
+}
+
+// upstreamUrl returns the URL of the HTTP upstream, given as host:port or as a URL.
+func upstreamUrl(upstream string) (*url.URL, error) {
+	if !strings.Contains(upstream, "://") {
+		upstream = "http://" + upstream
+	}
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported upstream scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
+// limitedSigner limits the number of concurrent signing calls to the KMS.
+type limitedSigner struct {
+	crypto.Signer
+	sem chan struct{}
+}
+
+func (s *limitedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func serveHttp(ctx context.Context, listen string, tlsConfig *tls.Config, target *url.URL) {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+	}
+	// No read and write timeouts, so that long requests and streamed responses are not cut
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           proxy,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	fmt.Fprintf(os.Stderr, "TLS proxy listening on %s, forwarding to %s\n", listen, target)
+	exit.OnErr(common.Serve(ctx, server, func() error { return server.ListenAndServeTLS("", "") }))
+}
+
+// serveTcp forwards the connections until ctx is done, then waits for the pending connections for
+// [common.ShutdownTimeout] before closing them.
+func serveTcp(ctx context.Context, listen string, tlsConfig *tls.Config, upstream string) {
+	listener := exit.OnErr2(tls.Listen("tcp", listen, tlsConfig))
+	stop := context.AfterFunc(ctx, func() { _ = listener.Close() })
+	defer stop()
+	// connCtx closes the pending connections once the shutdown timeout is reached
+	connCtx, closeConns := context.WithCancel(context.WithoutCancel(ctx))
+	defer closeConns()
+
+	var wg sync.WaitGroup
+	fmt.Fprintf(os.Stderr, "TLS proxy listening on %s, forwarding to %s\n", listen, upstream)
+	for ctx.Err() == nil {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			exit.OnErr(err)
+		}
+		wg.Go(func() { forward(connCtx, conn.(*tls.Conn), upstream) })
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(common.ShutdownTimeout):
+		closeConns()
+		<-done
+	}
+}
+
+// forward completes the handshake with the client, then copies the traffic between the client and the upstream.
+// The connections are closed when ctx is done.
+func forward(ctx context.Context, conn *tls.Conn, upstream string) {
+	defer conn.Close()
+	handshakeCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(handshakeCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "TLS handshake with %s failed: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	dialer := net.Dialer{Timeout: dialTimeout}
+	up, err := dialer.DialContext(ctx, "tcp", upstream)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to upstream: %s\n", err)
+		return
+	}
+	defer up.Close()
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+		_ = up.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		_, _ = io.Copy(up, conn)
+		if tcp, ok := up.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+	})
+	_, _ = io.Copy(conn, up)
+	_ = conn.CloseWrite()
+	wg.Wait()
+}
@@ -9,8 +9,6 @@ import (
 	"os"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/ovh/okms-cli/cmd/okms/common"
 	"github.com/ovh/okms-cli/common/utils/exit"
 	"github.com/spf13/cobra"
 )
@@ -19,6 +17,8 @@ func createGenerateCaCommand() *cobra.Command {
 	var (
 		subject  *pkixNameParams
 		validity time.Duration
+		profile  *profileParams
+		key      *signerParams
 
 		dnsNames []string
 		emails   []string
@@ -26,12 +26,16 @@ func createGenerateCaCommand() *cobra.Command {
 	)
 
 	cmd := &cobra.Command{
-		Use:   "ca KEY-ID",
+		Use:   "ca [KEY-ID]",
 		Short: "Generate a self-signed CA, signed with the key identified by KEY-ID",
-		Args:  cobra.ExactArgs(1),
+		Long: `Generate a self-signed CA, signed with the key identified by KEY-ID, or with the KMIP private key given with --kmip.
+
+The Subject Key Id is the key UUID, so that the CA can sign without giving its key. With a KMIP key whose unique
+identifier is not a UUID, it is derived from the public key instead.
+` + profileHelp,
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
-			signer := exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId))
+			signer, keyId := key.signer(cmd, args, 0, nil)
 
 			// CA Certificate template
 			cert := &x509.Certificate{
@@ -39,7 +43,7 @@ func createGenerateCaCommand() *cobra.Command {
 				SerialNumber:          newSerialNumber(),
 				IsCA:                  true,
 				Subject:               subject.pkixName(),
-				SubjectKeyId:          keyId[:],
+				SubjectKeyId:          keyId,
 				NotBefore:             time.Now(),
 				NotAfter:              time.Now().Add(validity),
 				KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
@@ -50,29 +54,11 @@ func createGenerateCaCommand() *cobra.Command {
 				IPAddresses:    ips,
 				URIs:           []*url.URL{}, //TODO: Make it a configurable option ?
 
-				// Other fields that can be configured
-
-				// AuthorityKeyId
-				// CRLDistributionPoints
-				// ExcludedDNSDomains
-				// ExcludedEmailAddresses
-				// ExcludedIPRanges
-				// ExcludedURIDomains
-				// ExtKeyUsage
-				// ExtraExtensions
-				// IssuingCertificateURL
-				// MaxPathLen
-				// MaxPathLenZero
-				// OCSPServer
-				// PermittedDNSDomains
-				// PermittedDNSDomainsCritical
-				// PermittedEmailAddresses
-				// PermittedIPRanges
-				// PermittedURIDomains
-				// PolicyIdentifiers
-				// UnknownExtKeyUsage
+				// Other fields can be configured with a profile
 			}
 
+			profile.apply(cmd, cert)
+
 			caCert := exit.OnErr2(x509.CreateCertificate(rand.Reader, cert, cert, signer.Public(), signer))
 			pemBlock := pem.Block{
 				Type:  "CERTIFICATE",
@@ -83,6 +69,8 @@ func createGenerateCaCommand() *cobra.Command {
 	}
 
 	subject = setPkixNameFlags(cmd)
+	profile = setProfileFlags(cmd)
+	key = setSignerFlags(cmd)
 	cmd.Flags().StringSliceVar(&dnsNames, "dns-names", nil, "Comma separated list of dns names")
 	cmd.Flags().StringSliceVar(&emails, "emails", nil, "Comma separated list of email addresses")
 	cmd.Flags().IPSliceVar(&ips, "ip-addrs", nil, "Comma separated list of IP addresses")
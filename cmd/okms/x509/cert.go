@@ -9,8 +9,6 @@ import (
 	"os"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/ovh/okms-cli/cmd/okms/common"
 	"github.com/ovh/okms-cli/common/utils/exit"
 	"github.com/spf13/cobra"
 )
@@ -19,6 +17,8 @@ func createGenerateCertCommand() *cobra.Command {
 	var (
 		subject  *pkixNameParams
 		validity time.Duration
+		profile  *profileParams
+		key      *signerParams
 		dnsNames []string
 		emails   []string
 		ips      []net.IP
@@ -28,20 +28,20 @@ func createGenerateCertCommand() *cobra.Command {
 	)
 
 	cmd := &cobra.Command{
-		Use:   "cert KEY-ID",
+		Use:   "cert [KEY-ID]",
 		Short: "Generate a self-signed certificate, signed with the key identified by KEY-ID",
-		Args:  cobra.ExactArgs(1),
+		Long:  "Generate a self-signed certificate, signed with the key identified by KEY-ID, or with the KMIP private key given with --kmip.\n" + profileHelp,
+		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
-			signer := exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId))
+			signer, keyId := key.signer(cmd, args, 0, nil)
+			db := openCaDatabase(cmd)
 
 			// Certificate template
 			certTemplate := &x509.Certificate{
 				// SignatureAlgorithm:
-				SerialNumber:          newSerialNumber(),
 				IsCA:                  false,
 				Subject:               subject.pkixName(),
-				SubjectKeyId:          keyId[:],
+				SubjectKeyId:          keyId,
 				NotBefore:             time.Now(),
 				NotAfter:              time.Now().Add(validity),
 				KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
@@ -52,27 +52,7 @@ func createGenerateCertCommand() *cobra.Command {
 				IPAddresses:    ips,
 				URIs:           []*url.URL{}, //TODO: Make it a configurable option ?
 
-				// Other fields that can be configured
-
-				// AuthorityKeyId
-				// CRLDistributionPoints
-				// ExcludedDNSDomains
-				// ExcludedEmailAddresses
-				// ExcludedIPRanges
-				// ExcludedURIDomains
-				// ExtKeyUsage
-				// ExtraExtensions
-				// IssuingCertificateURL
-				// MaxPathLen
-				// MaxPathLenZero
-				// OCSPServer
-				// PermittedDNSDomains
-				// PermittedDNSDomainsCritical
-				// PermittedEmailAddresses
-				// PermittedIPRanges
-				// PermittedURIDomains
-				// PolicyIdentifiers
-				// UnknownExtKeyUsage
+				// Other fields can be configured with a profile
 			}
 
 			if usageClientAuth {
@@ -82,16 +62,26 @@ func createGenerateCertCommand() *cobra.Command {
 				certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
 			}
 
-			cert := exit.OnErr2(x509.CreateCertificate(rand.Reader, certTemplate, certTemplate, signer.Public(), signer))
+			profile.apply(cmd, certTemplate)
+
+			certTemplate.SerialNumber = exit.OnErr2(newUniqueSerialNumber(db))
+			cert, err := x509.CreateCertificate(rand.Reader, certTemplate, certTemplate, signer.Public(), signer)
+			if err != nil {
+				releaseSerialNumber(db, certTemplate.SerialNumber)
+				exit.OnErr(err)
+			}
 			pemBlock := pem.Block{
 				Type:  "CERTIFICATE",
 				Bytes: cert,
 			}
 			exit.OnErr(pem.Encode(os.Stdout, &pemBlock))
+			exit.OnErr(recordCertificate(db, cert))
 		},
 	}
 
 	subject = setPkixNameFlags(cmd)
+	profile = setProfileFlags(cmd)
+	key = setSignerFlags(cmd)
 	cmd.Flags().StringSliceVar(&dnsNames, "dns-names", nil, "Comma separated list of dns names")
 	cmd.Flags().StringSliceVar(&emails, "emails", nil, "Comma separated list of email addresses")
 	cmd.Flags().IPSliceVar(&ips, "ip-addrs", nil, "Comma separated list of IP addresses")
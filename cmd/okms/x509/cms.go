@@ -0,0 +1,187 @@
+package x509
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils/cms"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+)
+
+func newCmsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cms",
+		Short: "Create and verify CMS (PKCS#7) signatures with a KMS key",
+	}
+	cmd.AddCommand(
+		newCmsSignCommand(),
+		newCmsVerifyCommand(),
+	)
+	return cmd
+}
+
+func newCmsSignCommand() *cobra.Command {
+	var (
+		certFile  string
+		chainFile string
+		detached  bool
+		format    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign FILE [KEY-ID]",
+		Short: "Create a CMS SignedData over FILE, signed with a key stored in the KMS",
+		Long: `Create a CMS SignedData (PKCS#7) over FILE, signed with a key stored in the KMS.
+
+The certificate given with --signing-cert must be the signing key's certificate. It can be followed
+in the same file by its issuers chain. All these certificates, and the ones from --chain, are embedded in the output.
+
+The KEY-ID parameter can be left empty if the certificate's Subject Key Id matches the key id UUID.`,
+		Example: `  # Detached signature, verifiable with openssl
+  okms x509 cms sign data.bin --signing-cert cert.pem > data.bin.p7s
+  openssl cms -verify -binary -inform PEM -in data.bin.p7s -content data.bin -CAfile ca.pem`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			content := exit.OnErr2(os.ReadFile(args[0]))
+			certs := exit.OnErr2(x509utils.LoadCertificates(certFile))
+			if chainFile != "" {
+				certs = append(certs, exit.OnErr2(x509utils.LoadCertificates(chainFile))...)
+			}
+			keyId := keyIdFromArgOrCert(cmd.Context(), args, 1, certs[0])
+			signer := exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId))
+
+			der := exit.OnErr2(cms.Sign(content, certs[0], signer, cms.SignOptions{
+				Detached:     detached,
+				SigningTime:  time.Now(),
+				Certificates: certs[1:],
+			}))
+			if strings.EqualFold(format, "der") {
+				exit.OnErr2(os.Stdout.Write(der))
+				return
+			}
+			exit.OnErr(pem.Encode(os.Stdout, &pem.Block{Type: "CMS", Bytes: der}))
+		},
+	}
+
+	cmd.Flags().StringVar(&certFile, "signing-cert", "", "Path to the PEM encoded signing certificate, optionally followed by its chain")
+	cmd.Flags().StringVar(&chainFile, "chain", "", "Path to additional PEM encoded certificates to embed")
+	cmd.Flags().BoolVar(&detached, "detached", true, "Do not embed the signed content in the output")
+	cmd.Flags().StringVarP(&format, "format", "f", "pem", "Output format [pem|der]")
+	_ = cmd.MarkFlagRequired("signing-cert")
+
+	return cmd
+}
+
+type cmsSignerResult struct {
+	Subject      string     `json:"subject"`
+	Issuer       string     `json:"issuer"`
+	SerialNumber string     `json:"serialNumber"`
+	SigningTime  *time.Time `json:"signingTime,omitempty"`
+}
+
+func newCmsVerifyCommand() *cobra.Command {
+	var (
+		caRootsFile   string
+		noChainVerify bool
+		contentOut    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify SIGNATURE [FILE]",
+		Short: "Verify a CMS SignedData",
+		Long: `Verify a PEM or DER encoded CMS SignedData (PKCS#7).
+
+FILE is the signed content, and is required if the signature is detached.
+The signers certificates are validated against the CA certificates given with --ca-roots,
+or against the system trust store if not set. Certificates embedded in the SignedData are used
+as intermediates.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			data := exit.OnErr2(os.ReadFile(args[0]))
+			if block, _ := pem.Decode(data); block != nil {
+				data = block.Bytes
+			}
+			sd := exit.OnErr2(cms.Parse(data))
+
+			var detachedContent []byte
+			if len(args) > 1 {
+				detachedContent = exit.OnErr2(os.ReadFile(args[1]))
+			}
+			if sd.Content != nil && detachedContent != nil {
+				exit.OnErr(errors.New("The signed content is attached, FILE must not be given"))
+			}
+			exit.OnErr(sd.Verify(detachedContent))
+
+			if !noChainVerify {
+				roots := exit.OnErr2(x509utils.LoadCertPool(""))
+				if caRootsFile != "" {
+					roots = x509.NewCertPool()
+					for _, c := range exit.OnErr2(x509utils.LoadCertificates(caRootsFile)) {
+						roots.AddCert(c)
+					}
+				}
+				intermediates := x509.NewCertPool()
+				for _, c := range sd.Certificates {
+					intermediates.AddCert(c)
+				}
+				for _, signer := range sd.Signers {
+					opts := x509.VerifyOptions{
+						Roots:         roots,
+						Intermediates: intermediates,
+						KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+					}
+					if signer.SigningTime != nil {
+						opts.CurrentTime = *signer.SigningTime
+					}
+					if _, err := signer.Certificate.Verify(opts); err != nil {
+						exit.OnErr(fmt.Errorf("Invalid signer certificate %q: %w", signer.Certificate.Subject, err))
+					}
+				}
+			}
+
+			if contentOut != "" && sd.Content != nil {
+				w := flagsmgmt.WriterFromArg(contentOut)
+				defer w.Close()
+				exit.OnErr2(w.Write(sd.Content))
+			}
+
+			signers := make([]cmsSignerResult, 0, len(sd.Signers))
+			for _, signer := range sd.Signers {
+				signers = append(signers, cmsSignerResult{
+					Subject:      signer.Certificate.Subject.String(),
+					Issuer:       signer.Certificate.Issuer.String(),
+					SerialNumber: signer.Certificate.SerialNumber.String(),
+					SigningTime:  signer.SigningTime,
+				})
+			}
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(signers)
+				return
+			}
+			for _, s := range signers {
+				fmt.Fprintf(os.Stderr, "Signed by %q (serial %s)", s.Subject, s.SerialNumber)
+				if s.SigningTime != nil {
+					fmt.Fprintf(os.Stderr, " at %s", s.SigningTime.Format(time.RFC3339))
+				}
+				fmt.Fprintln(os.Stderr)
+			}
+			fmt.Fprintln(os.Stderr, "Verification successful")
+		},
+	}
+
+	cmd.Flags().StringVar(&caRootsFile, "ca-roots", "", "Path to a PEM bundle of trusted CA certificates. Defaults to the system trust store")
+	cmd.Flags().BoolVar(&noChainVerify, "no-chain-verify", false, "Only verify the signatures, and do not validate the signers certificates")
+	cmd.Flags().StringVar(&contentOut, "content-out", "", "Write the attached signed content to the given file, or '-' for stdout")
+
+	return cmd
+}
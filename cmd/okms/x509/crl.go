@@ -1,17 +1,25 @@
 package x509
 
 import (
+	"cmp"
+	"context"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"math/big"
 	"os"
+	"slices"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/kmip-go"
+	"github.com/ovh/kmip-go/kmipclient"
+	kmipcmd "github.com/ovh/okms-cli/cmd/okms/kmip"
+	"github.com/ovh/okms-cli/common/utils"
 	"github.com/ovh/okms-cli/common/utils/exit"
 	"github.com/ovh/okms-cli/common/utils/x509utils"
 	"github.com/spf13/cobra"
@@ -23,34 +31,232 @@ type revocationEntry struct {
 	ReasonCode     *int             `json:"reasonCode,omitempty"`
 }
 
+// loadRevocationEntries reads a JSON revoke list file.
+func loadRevocationEntries(file string) ([]revocationEntry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []revocationEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Invalid revoke list %q: %w", file, err)
+	}
+	return entries, nil
+}
+
+// kmipRevocationReasons maps the KMIP revocation reason codes to the RFC 5280 CRL reason codes.
+var kmipRevocationReasons = map[kmip.RevocationReasonCode]int{
+	kmip.RevocationReasonCodeUnspecified:          0,
+	kmip.RevocationReasonCodeKeyCompromise:        1,
+	kmip.RevocationReasonCodeCACompromise:         2,
+	kmip.RevocationReasonCodeAffiliationChanged:   3,
+	kmip.RevocationReasonCodeSuperseded:           4,
+	kmip.RevocationReasonCodeCessationOfOperation: 5,
+	kmip.RevocationReasonCodePrivilegeWithdrawn:   9,
+}
+
+// kmipLocatePageSize is the number of objects located per KMIP Locate request.
+const kmipLocatePageSize = 100
+
+// locateKmipCertificates returns the IDs of the KMIP Certificate objects in the given state, paging through the
+// Locate results so that servers capping them do not truncate the list.
+func locateKmipCertificates(ctx context.Context, client *kmipclient.Client, state kmip.State) ([]string, error) {
+	var ids []string
+	for {
+		located, err := client.Locate().
+			WithObjectType(kmip.ObjectTypeCertificate).
+			WithAttribute(kmip.AttributeNameState, state).
+			WithMaxItems(kmipLocatePageSize).
+			WithOffset(utils.ToInt32(len(ids))).
+			ExecContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, located.UniqueIdentifier...)
+		if len(located.UniqueIdentifier) < kmipLocatePageSize {
+			return ids, nil
+		}
+	}
+}
+
+// kmipRevocationEntries returns the revocation entries of the KMIP Certificate objects issued by ca
+// which are in the Compromised or Deactivated state. The certificates without a revocation date are skipped
+// with a warning, as the CRL must not change from one generation to another.
+func kmipRevocationEntries(ctx context.Context, client *kmipclient.Client, ca *x509.Certificate) ([]revocationEntry, error) {
+	var entries []revocationEntry
+	for _, state := range []kmip.State{kmip.StateCompromised, kmip.StateDeactivated} {
+		ids, err := locateKmipCertificates(ctx, client, state)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			resp, err := client.Get(id).ExecContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			obj, ok := resp.Object.(*kmip.Certificate)
+			if !ok {
+				continue
+			}
+			cert, err := x509.ParseCertificate(obj.CertificateValue)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid certificate in KMIP object %s: %w", id, err)
+			}
+			if cert.CheckSignatureFrom(ca) != nil {
+				continue
+			}
+			attrs, err := client.GetAttributes(id,
+				kmip.AttributeNameRevocationReason,
+				kmip.AttributeNameCompromiseOccurrenceDate,
+				kmip.AttributeNameCompromiseDate,
+				kmip.AttributeNameDeactivationDate,
+			).ExecContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			entry, ok := kmipRevocationEntry(cert, state, attrs.Attribute)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Warning: KMIP certificate %s has no compromise or deactivation date, and is not added to the CRL\n", id)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// kmipRevocationEntry builds the revocation entry of a compromised or deactivated certificate from its
+// KMIP attributes. The revocation date is the compromise occurrence date of compromised certificates,
+// and the deactivation date otherwise. Without a revocation reason, compromised certificates are
+// revoked for key compromise. It returns false if the certificate has no revocation date.
+func kmipRevocationEntry(cert *x509.Certificate, state kmip.State, attributes []kmip.Attribute) (revocationEntry, bool) {
+	var (
+		reason                                         *kmip.RevocationReason
+		compromiseOccurrence, compromise, deactivation time.Time
+	)
+	for _, attr := range attributes {
+		switch v := attr.AttributeValue.(type) {
+		case kmip.RevocationReason:
+			reason = &v
+		case time.Time:
+			switch attr.AttributeName {
+			case kmip.AttributeNameCompromiseOccurrenceDate:
+				compromiseOccurrence = v
+			case kmip.AttributeNameCompromiseDate:
+				compromise = v
+			case kmip.AttributeNameDeactivationDate:
+				deactivation = v
+			}
+		}
+	}
+
+	entry := revocationEntry{SerialNumber: x509utils.BigInt{Int: cert.SerialNumber}}
+	code := 0
+	if state == kmip.StateCompromised {
+		code = 1
+		entry.RevocationDate = cmp.Or(compromiseOccurrence, compromise, deactivation)
+	} else {
+		entry.RevocationDate = deactivation
+	}
+	if entry.RevocationDate.IsZero() {
+		return entry, false
+	}
+	if reason != nil {
+		if c, ok := kmipRevocationReasons[reason.RevocationReasonCode]; ok {
+			code = c
+		}
+	}
+	entry.ReasonCode = &code
+	return entry, true
+}
+
+// loadBaseCrl reads the base CRL of a delta CRL, which must be a full CRL issued by ca.
+func loadBaseCrl(file string, ca *x509.Certificate) (*x509.RevocationList, error) {
+	crls, err := x509utils.LoadRevocationLists(file)
+	if err != nil {
+		return nil, err
+	}
+	base := crls[0]
+	if err := base.CheckSignatureFrom(ca); err != nil {
+		return nil, fmt.Errorf("The base CRL is not issued by the CA: %w", err)
+	}
+	if slices.ContainsFunc(base.Extensions, func(ext pkix.Extension) bool { return ext.Id.Equal(OID_CE_DELTA_CRL_INDICATOR) }) {
+		return nil, errors.New("The base CRL must be a full CRL, not a delta CRL")
+	}
+	if base.Number == nil {
+		return nil, errors.New("The base CRL has no CRL number")
+	}
+	return base, nil
+}
+
+// freshestCrlExtension returns the Freshest CRL extension pointing to the delta CRLs at the given URLs.
+func freshestCrlExtension(urls []string) (pkix.Extension, error) {
+	// Same syntax as the CRL distribution points extension
+	type distributionPointName struct {
+		FullName []asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	type distributionPoint struct {
+		DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	}
+	var points []distributionPoint
+	for _, u := range urls {
+		points = append(points, distributionPoint{
+			DistributionPoint: distributionPointName{
+				FullName: []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(u)}},
+			},
+		})
+	}
+	value, err := asn1.Marshal(points)
+	return pkix.Extension{Id: OID_CE_FRESHEST_CRL, Value: value}, err
+}
+
 func CreateGenerateCrlCommand() *cobra.Command {
 	var (
-		nextUpdate time.Duration
-		crlNumber  int64
+		nextUpdate  time.Duration
+		crlNumber   int64
+		baseCrlFile string
+		deltaUrls   []string
+		fromKmip    bool
+		key         *signerParams
 	)
 
 	cmd := &cobra.Command{
-		Use:   "crl CA REVOKE_LIST [KEY-ID]",
+		Use:   "crl CA [REVOKE_LIST] [KEY-ID]",
 		Short: "Generate a CRL with a CA whose key is stored in the KMS",
-		Long:  "Generate a Certificate Revocation List with a Certificate Authority whose key is stored in the KMS.\nThe REVOKE_LIST file is a JSON array of entries containing serialNumber (prefer a decimal string; hex must be 0x-prefixed if used), revocationDate and optionally reasonCode. See RFC3339.",
-		Args:  cobra.RangeArgs(2, 3),
+		Long: `Generate a Certificate Revocation List with a Certificate Authority whose key is stored in the KMS.
+The REVOKE_LIST file is a JSON array of entries containing serialNumber (prefer a decimal string; hex must be 0x-prefixed if used), revocationDate and optionally reasonCode. See RFC3339.
+
+If a CA state directory is set, the certificates revoked there with "okms x509 revoke" are added to the CRL, and
+REVOKE_LIST becomes optional. The CRL number is then taken from the state directory, and incremented on each new CRL,
+unless --crlNumber is given, in which case it must be greater than the last one.
+
+A CA key created with KMIP is given with --kmip instead of KEY-ID.
+
+With --from-kmip, the KMIP Certificate objects issued by the CA in the Compromised or Deactivated state are added
+to the CRL, with their revocation reason. Their revocation date is their compromise occurrence date, or their
+deactivation date: those having neither are skipped with a warning. The KMIP endpoint is read from the kmip section of the configuration profile, or from the
+KMS_KMIP_* environment variables. REVOKE_LIST is then optional.
+
+A delta CRL is generated with --base-crl, containing only the revoked certificates missing from the given full
+CRL, and a delta CRL indicator referencing its CRL number. The delta CRL number must be greater than the base one.
+Full CRLs can point to their delta CRLs with --delta-crl-url.`,
+		Args: cobra.RangeArgs(1, 3),
 		Run: func(cmd *cobra.Command, args []string) {
 			caData := exit.OnErr2(os.ReadFile(args[0]))
 			caDer := exit.OnErr2(x509utils.PemDecode(caData))
 			ca := exit.OnErr2(x509.ParseCertificate(caDer.Bytes))
+			db := openCaDatabase(cmd)
 
+			// With a CA state directory, REVOKE_LIST is optional, so a single argument after CA
+			// is the KEY-ID if it is a key reference, unless signing with a KMIP key.
 			var revocationEntries []revocationEntry
-			revoke := exit.OnErr2(os.ReadFile(args[1]))
-			exit.OnErr(json.Unmarshal(revoke, &revocationEntries))
-
-			var keyId uuid.UUID
-			if len(args) >= 3 {
-				keyId = exit.OnErr2(uuid.Parse(args[2]))
-			} else {
-				if len(ca.SubjectKeyId) == 0 || len(ca.SubjectKeyId) != 16 {
-					exit.OnErr(errors.New("Cannot use CA's subject key id, please provide the KEY-ID"))
-				}
-				keyId = uuid.UUID(ca.SubjectKeyId)
+			keyArgs := args[1:]
+			optionalList := db != nil || fromKmip
+			if len(args) == 3 || (len(args) == 2 && (!optionalList || key.kmipId != "" || !utils.IsKeyRef(args[1]))) {
+				revocationEntries = exit.OnErr2(loadRevocationEntries(args[1]))
+				keyArgs = args[2:]
+			} else if !optionalList {
+				exit.OnErr(errors.New("Missing REVOKE_LIST parameter, required when no CA state directory is set"))
 			}
 
 			crl := &x509.RevocationList{
@@ -61,7 +267,12 @@ func CreateGenerateCrlCommand() *cobra.Command {
 				NextUpdate:         time.Now().Add(nextUpdate),
 			}
 
-			for _, entry := range revocationEntries {
+			addEntry := func(entry revocationEntry) {
+				if slices.ContainsFunc(crl.RevokedCertificateEntries, func(e x509.RevocationListEntry) bool {
+					return e.SerialNumber.Cmp(entry.SerialNumber.Int) == 0
+				}) {
+					return
+				}
 				e := x509.RevocationListEntry{
 					SerialNumber:   entry.SerialNumber.Int,
 					RevocationTime: entry.RevocationDate,
@@ -74,7 +285,54 @@ func CreateGenerateCrlCommand() *cobra.Command {
 				crl.RevokedCertificateEntries = append(crl.RevokedCertificateEntries, e)
 			}
 
-			signer := exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId))
+			for _, entry := range revocationEntries {
+				addEntry(entry)
+			}
+
+			if db != nil {
+				for _, entry := range exit.OnErr2(db.Load()).IssuedBy(ca) {
+					if entry.RevocationDate == nil {
+						continue
+					}
+					addEntry(revocationEntry{SerialNumber: entry.SerialNumber, RevocationDate: *entry.RevocationDate, ReasonCode: entry.ReasonCode})
+				}
+			}
+
+			if fromKmip {
+				for _, entry := range exit.OnErr2(kmipRevocationEntries(cmd.Context(), kmipcmd.Dial(cmd), ca)) {
+					addEntry(entry)
+				}
+			}
+
+			var base *x509.RevocationList
+			if baseCrlFile != "" {
+				base = exit.OnErr2(loadBaseCrl(baseCrlFile, ca))
+				crl.RevokedCertificateEntries = slices.DeleteFunc(crl.RevokedCertificateEntries, func(e x509.RevocationListEntry) bool {
+					return slices.ContainsFunc(base.RevokedCertificateEntries, func(b x509.RevocationListEntry) bool {
+						return b.SerialNumber.Cmp(e.SerialNumber) == 0
+					})
+				})
+				baseNumber := exit.OnErr2(asn1.Marshal(base.Number))
+				crl.ExtraExtensions = append(crl.ExtraExtensions, pkix.Extension{Id: OID_CE_DELTA_CRL_INDICATOR, Critical: true, Value: baseNumber})
+				if db == nil && !cmd.Flags().Changed("crlNumber") {
+					crl.Number = new(big.Int).Add(base.Number, big.NewInt(1))
+				}
+			}
+			if len(deltaUrls) > 0 {
+				crl.ExtraExtensions = append(crl.ExtraExtensions, exit.OnErr2(freshestCrlExtension(deltaUrls)))
+			}
+
+			signer, _ := key.signer(cmd, keyArgs, 0, ca)
+			if db != nil {
+				var number *big.Int
+				if cmd.Flags().Changed("crlNumber") {
+					number = crl.Number
+				}
+				crl.Number = exit.OnErr2(db.NextCrlNumber(ca, number))
+			}
+			if base != nil && crl.Number.Cmp(base.Number) <= 0 {
+				exit.OnErr(fmt.Errorf("The delta CRL number must be greater than the base CRL one (%s)", base.Number))
+			}
 			certBytes := exit.OnErr2(x509.CreateRevocationList(rand.Reader, crl, ca, signer))
 
 			pemBlock := pem.Block{
@@ -87,6 +345,11 @@ func CreateGenerateCrlCommand() *cobra.Command {
 
 	cmd.Flags().DurationVar(&nextUpdate, "nextUpdate", 30*24*time.Hour, "Duration before next update of the CRL, see RFC3339")
 	cmd.Flags().Int64Var(&crlNumber, "crlNumber", 1, "CRL Number i.e version, see RFC3339")
+	cmd.Flags().StringVar(&baseCrlFile, "base-crl", "", "Path to the full CRL a delta CRL is generated for")
+	cmd.Flags().StringSliceVar(&deltaUrls, "delta-crl-url", nil, "Comma separated URLs of the delta CRLs, added to a full CRL as freshest CRL extension")
+	cmd.Flags().BoolVar(&fromKmip, "from-kmip", false, "Add the compromised and deactivated KMIP certificates issued by the CA")
+	cmd.MarkFlagsMutuallyExclusive("base-crl", "delta-crl-url")
+	key = setSignerFlags(cmd)
 
 	return cmd
 }
@@ -10,8 +10,6 @@ import (
 	"net/url"
 	"os"
 
-	"github.com/google/uuid"
-	"github.com/ovh/okms-cli/cmd/okms/common"
 	"github.com/ovh/okms-cli/common/utils/exit"
 	"github.com/spf13/cobra"
 )
@@ -22,15 +20,19 @@ func createGenerateCsrCommand() *cobra.Command {
 		dnsNames []string
 		emails   []string
 		ips      []net.IP
+		key      *signerParams
 	)
 
 	cmd := &cobra.Command{
-		Use:   "csr KEY-ID",
+		Use:   "csr [KEY-ID]",
 		Short: "Generate a CSR signed with the given private key",
-		Args:  cobra.ExactArgs(1),
+		Long: `Generate a CSR signed with the private key identified by KEY-ID, or with the KMIP private key given with --kmip.
+
+The key UUID is embedded in the request as Subject Key Id. It is omitted for a KMIP key whose unique identifier
+is not a UUID.`,
+		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			keyId := exit.OnErr2(uuid.Parse(args[0]))
-			signer := exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId))
+			signer, keyId := key.signer(cmd, args, 0, nil)
 			template := &x509.CertificateRequest{
 				// Do not specify signature algorithm here, and let go chose one based on the key type.
 				// SignatureAlgorithm: x509.ECDSAWithSHA384,
@@ -39,10 +41,12 @@ func createGenerateCsrCommand() *cobra.Command {
 				EmailAddresses: emails,
 				IPAddresses:    ips,
 				URIs:           []*url.URL{}, //TODO: Make it a configurable option ?
-				ExtraExtensions: []pkix.Extension{
-					// Embbed subject key identifier in the csr extensions
-					{Id: OID_CE_SUBJECT_KEY_IDENTIFIER, Critical: false, Value: exit.OnErr2(asn1.Marshal(keyId[:]))},
-				},
+			}
+			if keyId != nil {
+				// Embbed subject key identifier in the csr extensions
+				template.ExtraExtensions = []pkix.Extension{
+					{Id: OID_CE_SUBJECT_KEY_IDENTIFIER, Critical: false, Value: exit.OnErr2(asn1.Marshal(keyId))},
+				}
 			}
 			csrBytes := exit.OnErr2(x509.CreateCertificateRequest(rand.Reader, template, signer))
 			pemBlock := pem.Block{
@@ -57,5 +61,6 @@ func createGenerateCsrCommand() *cobra.Command {
 	cmd.Flags().StringSliceVar(&dnsNames, "dns-names", nil, "Comma separated list of dns names")
 	cmd.Flags().StringSliceVar(&emails, "emails", nil, "Comma separated list of email addresses")
 	cmd.Flags().IPSliceVar(&ips, "ip-addrs", nil, "Comma separated list of IP addresses")
+	key = setSignerFlags(cmd)
 	return cmd
 }
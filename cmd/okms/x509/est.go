@@ -0,0 +1,405 @@
+package x509
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/utils/cms"
+	"github.com/ovh/okms-cli/common/utils/csrpolicy"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// estPathPrefix is the path prefix of the EST operations (RFC 7030 section 3.2.2).
+	estPathPrefix = "/.well-known/est/"
+	// estMaxRequestSize is the maximum accepted size of an enrollment request body.
+	estMaxRequestSize = 64 * 1024
+
+	estCsrContentType    = "application/pkcs10"
+	estCertsContentType  = "application/pkcs7-mime"
+	estEnrollContentType = "application/pkcs7-mime; smime-type=certs-only"
+)
+
+func newEstCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "est",
+		Short: "RFC 7030 Enrollment over Secure Transport server backed by a KMS CA",
+	}
+	cmd.AddCommand(
+		newEstServeCommand(),
+	)
+	return cmd
+}
+
+func newEstServeCommand() *cobra.Command {
+	var (
+		listen       string
+		tlsCertFile  string
+		tlsKeyFile   string
+		clientCaFile string
+		htpasswdFile string
+
+		validity        time.Duration
+		usageServerAuth bool
+		usageClientAuth bool
+		profile         *profileParams
+		policyFile      string
+		key             *signerParams
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve CA [KEY-ID]",
+		Short: "Serve the EST cacerts, simpleenroll and simplereenroll operations over HTTPS",
+		Long: `Serve the EST (RFC 7030) cacerts, simpleenroll and simplereenroll operations over HTTPS, issuing
+certificates with a CA whose key is stored in the KMS.
+
+The certificate requests are signed the same way as with "okms x509 sign", using the same CSR policy and
+certificate profile options. Only leaf certificates are issued.
+
+The CA file may be a bundle, starting with the issuing CA certificate and followed by its chain, which is
+returned by the cacerts operation. The KEY-ID parameter can be left empty if the CA's Subject Key Id matches
+the key id UUID. A CA key created with KMIP is given with --kmip instead.
+
+The server certificate and key are given with --tls-cert and --tls-key. Clients are authenticated either:
+  - with a TLS client certificate issued by the issuing CA, which is the first certificate of the CA file, or
+    by one of the CAs given with --client-ca. The rest of the CA chain is not trusted
+  - with HTTP basic authentication, using the bcrypt hashed passwords of an htpasswd file given with --htpasswd
+    (see "htpasswd -B")
+
+The cacerts operation does not require authentication. The simplereenroll operation requires a client
+certificate issued by the issuing CA, and the request must have the same subject and subject alternative
+names, other names included, as this certificate.
+If a CA state directory is set, issued certificates are recorded there, and revoked client certificates are
+rejected.
+` + csrPolicyHelp + profileHelp,
+		Example: `  okms x509 est serve ca.pem --tls-cert server.pem --tls-key server.key --htpasswd est.htpasswd --client-auth
+  curl --cacert server-ca.pem --user bob:secret -H "Content-Type: application/pkcs10" --data-binary @csr.b64 \
+    https://localhost:8443/.well-known/est/simpleenroll | base64 -d | openssl pkcs7 -inform DER -print_certs`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if clientCaFile == "" && htpasswdFile == "" {
+				fmt.Fprintln(os.Stderr, "Warning: only clients with a certificate issued by the CA can enroll. Use --htpasswd to enable basic authentication")
+			}
+			caCerts := exit.OnErr2(x509utils.LoadCertificates(args[0]))
+			ca := caCerts[0]
+
+			issuer := &certIssuer{
+				ca:          ca,
+				profile:     exit.OnErr2(profile.load()),
+				validity:    validity,
+				validitySet: cmd.Flags().Changed("validity"),
+				serverAuth:  usageServerAuth,
+				clientAuth:  usageClientAuth,
+				warn:        func(format string, args ...any) { fmt.Fprintf(os.Stderr, format, args...) },
+			}
+			if policyFile != "" {
+				issuer.policy = exit.OnErr2(csrpolicy.Load(policyFile))
+			}
+			issuer.signer, issuer.authorityKeyId = key.signer(cmd, args, 1, ca)
+			issuer.db = openCaDatabase(cmd)
+
+			est := &estServer{
+				issuer: issuer,
+				chain:  x509utils.OrderChain(ca, caCerts),
+			}
+			// Only the issuing CA is trusted: a certificate issued by its parent is not an enrollment credential
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(ca)
+			if clientCaFile != "" {
+				for _, c := range exit.OnErr2(x509utils.LoadCertificates(clientCaFile)) {
+					clientCAs.AddCert(c)
+				}
+			}
+			if htpasswdFile != "" {
+				est.users = exit.OnErr2(loadHtpasswd(htpasswdFile))
+				est.dummyHash = exit.OnErr2(bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost))
+			}
+			serverCert := exit.OnErr2(tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile))
+
+			server := &http.Server{
+				Addr:    listen,
+				Handler: est,
+				TLSConfig: &tls.Config{
+					MinVersion:   tls.VersionTLS12,
+					Certificates: []tls.Certificate{serverCert},
+					ClientAuth:   tls.VerifyClientCertIfGiven,
+					ClientCAs:    clientCAs,
+				},
+				ReadHeaderTimeout: 10 * time.Second,
+				ReadTimeout:       30 * time.Second,
+				WriteTimeout:      30 * time.Second,
+			}
+			fmt.Fprintf(os.Stderr, "EST server listening on %s\n", listen)
+			exit.OnErr(common.Serve(cmd.Context(), server, func() error { return server.ListenAndServeTLS("", "") }))
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", ":8443", "Address to listen on")
+	cmd.Flags().StringVar(&tlsCertFile, "tls-cert", "", "Path to the server certificate, optionally followed by its chain")
+	cmd.Flags().StringVar(&tlsKeyFile, "tls-key", "", "Path to the server private key")
+	cmd.Flags().StringVar(&clientCaFile, "client-ca", "", "Path to a bundle of additional CAs trusted to authenticate clients")
+	cmd.Flags().StringVar(&htpasswdFile, "htpasswd", "", "Path to an htpasswd file with bcrypt hashed passwords, enabling basic authentication")
+	exit.OnErr(cmd.MarkFlagRequired("tls-cert"))
+	exit.OnErr(cmd.MarkFlagRequired("tls-key"))
+
+	cmd.Flags().DurationVar(&validity, "validity", 365*24*time.Hour, "Validity duration")
+	cmd.Flags().BoolVar(&usageServerAuth, "server-auth", false, "Enable server auth extended key usage")
+	cmd.Flags().BoolVar(&usageClientAuth, "client-auth", false, "Enable client auth extended key usage")
+	profile = setProfileFlags(cmd)
+	key = setSignerFlags(cmd)
+	cmd.Flags().StringVar(&policyFile, "csr-policy", "", "Path to a YAML policy enforced on the certificate requests")
+
+	return cmd
+}
+
+// estServer serves the EST operations.
+type estServer struct {
+	issuer *certIssuer
+	// chain is the CA chain returned by the cacerts operation.
+	chain []*x509.Certificate
+	// users are the bcrypt password hashes of the basic authentication users, by name.
+	users map[string][]byte
+	// dummyHash is compared to the passwords of unknown users, so that they cannot be told apart
+	// from wrong passwords by timing.
+	dummyHash []byte
+}
+
+func (s *estServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation, found := strings.CutPrefix(r.URL.Path, estPathPrefix)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	switch operation {
+	case "cacerts":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.writeCertificates(w, estCertsContentType, s.chain)
+	case "simpleenroll":
+		s.enroll(w, r, false)
+	case "simplereenroll":
+		s.enroll(w, r, true)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *estServer) enroll(w http.ResponseWriter, r *http.Request, reenroll bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientCert, err := s.authenticate(r, reenroll)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Rejected enrollment from %s: %s\n", r.RemoteAddr, err)
+		w.Header().Set("WWW-Authenticate", `Basic realm="EST"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != estCsrContentType {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, estMaxRequestSize))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	csr, err := parseEstRequest(body)
+	if err == nil && reenroll {
+		err = checkReenrollRequest(csr, clientCert, s.issuer.ca)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid certificate request from %s: %s\n", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cert, err := s.issuer.issue(csr)
+	switch {
+	case errors.Is(err, errNonCompliantRequest):
+		fmt.Fprintf(os.Stderr, "Rejected certificate request from %s: %s\n", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case cert == nil:
+		fmt.Fprintf(os.Stderr, "Failed to issue certificate for %s: %s\n", r.RemoteAddr, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	case err != nil:
+		// The certificate is issued, so it is returned anyway
+		fmt.Fprintf(os.Stderr, "Warning: certificate %s for %s: %s\n", cert.SerialNumber, r.RemoteAddr, err)
+	}
+	fmt.Fprintf(os.Stderr, "Issued certificate %s for %q to %s\n", cert.SerialNumber, cert.Subject, r.RemoteAddr)
+	s.writeCertificates(w, estEnrollContentType, []*x509.Certificate{cert})
+}
+
+// authenticate returns the verified client certificate if any, or checks the basic authentication
+// credentials. A client certificate is required when reenroll is true.
+func (s *estServer) authenticate(r *http.Request, reenroll bool) (*x509.Certificate, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		if err := s.checkNotRevoked(cert); err != nil {
+			return nil, err
+		}
+		return cert, nil
+	}
+	if reenroll {
+		return nil, errors.New("Re-enrollment requires a client certificate")
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, errors.New("No client certificate nor credentials")
+	}
+	hash, found := s.users[user]
+	if !found {
+		hash = s.dummyHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !found {
+		return nil, fmt.Errorf("Invalid credentials for user %q", user)
+	}
+	return nil, nil
+}
+
+// checkNotRevoked fails if cert is recorded as revoked in the CA database, if any.
+func (s *estServer) checkNotRevoked(cert *x509.Certificate) error {
+	if s.issuer.db == nil {
+		return nil
+	}
+	idx, err := s.issuer.db.Load()
+	if err != nil {
+		return err
+	}
+	for _, e := range idx.Find(cert.SerialNumber) {
+		if e.Issuer == cert.Issuer.String() && e.RevocationDate != nil {
+			return fmt.Errorf("Client certificate %s is revoked", cert.SerialNumber)
+		}
+	}
+	return nil
+}
+
+func (s *estServer) writeCertificates(w http.ResponseWriter, contentType string, certs []*x509.Certificate) {
+	der, err := cms.CertificatesOnly(certs)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	resp := base64.StdEncoding.EncodeToString(der)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	_, _ = io.WriteString(w, resp)
+}
+
+// parseEstRequest parses a base64 encoded PKCS#10 certificate request, which may contain line breaks.
+func parseEstRequest(body []byte) (*x509.CertificateRequest, error) {
+	body = bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, body)
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base64 certificate request: %w", err)
+	}
+	return x509.ParseCertificateRequest(der)
+}
+
+// checkReenrollRequest checks that the certificate being renewed was issued by ca, and that csr has the same subject
+// and subject alternative names as this certificate, as required by RFC 7030 section 4.2.2.
+func checkReenrollRequest(csr *x509.CertificateRequest, cert, ca *x509.Certificate) error {
+	if !bytes.Equal(cert.RawIssuer, ca.RawSubject) || cert.CheckSignatureFrom(ca) != nil {
+		return errors.New("The client certificate was not issued by the CA")
+	}
+	// The issued certificate subject may be encoded with different string types than the request one
+	if csr.Subject.String() != cert.Subject.String() {
+		return fmt.Errorf("The request subject %q does not match the certificate one %q", csr.Subject, cert.Subject)
+	}
+	csrNames, err := generalNames(csr.Extensions)
+	if err != nil {
+		return err
+	}
+	certNames, err := generalNames(cert.Extensions)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(csrNames, certNames) {
+		return errors.New("The request subject alternative names do not match the certificate ones")
+	}
+	return nil
+}
+
+// generalNames returns the sorted DER encodings of the names in the subject alternative names extension, so that
+// the names x509 does not parse, like otherName, are compared as well.
+func generalNames(extensions []pkix.Extension) ([]string, error) {
+	for _, ext := range extensions {
+		if !ext.Id.Equal(OID_CE_SUBJECT_ALT_NAME) {
+			continue
+		}
+		var seq asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &seq); err != nil {
+			return nil, fmt.Errorf("Invalid subject alternative names: %w", err)
+		}
+		var names []string
+		for rest := seq.Bytes; len(rest) > 0; {
+			var name asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				return nil, fmt.Errorf("Invalid subject alternative names: %w", err)
+			}
+			names = append(names, string(name.FullBytes))
+		}
+		slices.Sort(names)
+		return names, nil
+	}
+	return nil, nil
+}
+
+// loadHtpasswd reads an htpasswd file, and returns the bcrypt password hashes by user name.
+func loadHtpasswd(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("Invalid htpasswd entry at line %d", line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("Unsupported password hash for user %q at line %d, only bcrypt is supported", user, line)
+		}
+		users[user] = []byte(hash)
+	}
+	return users, scanner.Err()
+}
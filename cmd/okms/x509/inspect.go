@@ -0,0 +1,463 @@
+package x509
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // Only used for fingerprints
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils/certprofile"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+)
+
+// Inspected object types.
+const (
+	inspectTypeCertificate = "certificate"
+	inspectTypeCsr         = "certificate request"
+	inspectTypeCrl         = "crl"
+)
+
+// extensionNames are the names of the common certificate and CRL extensions.
+var extensionNames = map[string]string{
+	"2.5.29.14":               "subjectKeyIdentifier",
+	"2.5.29.15":               "keyUsage",
+	"2.5.29.17":               "subjectAltName",
+	"2.5.29.18":               "issuerAltName",
+	"2.5.29.19":               "basicConstraints",
+	"2.5.29.20":               "cRLNumber",
+	"2.5.29.21":               "reasonCode",
+	"2.5.29.27":               "deltaCRLIndicator",
+	"2.5.29.28":               "issuingDistributionPoint",
+	"2.5.29.30":               "nameConstraints",
+	"2.5.29.31":               "cRLDistributionPoints",
+	"2.5.29.32":               "certificatePolicies",
+	"2.5.29.35":               "authorityKeyIdentifier",
+	"2.5.29.37":               "extKeyUsage",
+	"2.5.29.46":               "freshestCRL",
+	"1.3.6.1.5.5.7.1.1":       "authorityInfoAccess",
+	"1.3.6.1.5.5.7.48.1.5":    "ocspNoCheck",
+	"1.3.6.1.4.1.11129.2.4.2": "signedCertificateTimestampList",
+}
+
+type inspectedExtension struct {
+	Id       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+type inspectedPublicKey struct {
+	Type  string `json:"type"`
+	Size  int    `json:"size"`
+	Curve string `json:"curve,omitempty"`
+}
+
+type fingerprints struct {
+	Sha1   string `json:"sha1"`
+	Sha256 string `json:"sha256"`
+}
+
+type inspectedCertificate struct {
+	Type                   string               `json:"type"`
+	Version                int                  `json:"version"`
+	SerialNumber           x509utils.BigInt     `json:"serialNumber"`
+	Subject                string               `json:"subject"`
+	Issuer                 string               `json:"issuer"`
+	NotBefore              time.Time            `json:"notBefore"`
+	NotAfter               time.Time            `json:"notAfter"`
+	SignatureAlgorithm     string               `json:"signatureAlgorithm"`
+	PublicKey              inspectedPublicKey   `json:"publicKey"`
+	IsCA                   bool                 `json:"isCA"`
+	MaxPathLen             *int                 `json:"maxPathLen,omitempty"`
+	KeyUsage               []string             `json:"keyUsage,omitempty"`
+	ExtKeyUsage            []string             `json:"extKeyUsage,omitempty"`
+	SubjectAltNames        []string             `json:"subjectAltNames,omitempty"`
+	OkmsDomainId           string               `json:"okmsDomainId,omitempty"`
+	SubjectKeyId           string               `json:"subjectKeyId,omitempty"`
+	AuthorityKeyId         string               `json:"authorityKeyId,omitempty"`
+	OCSPServers            []string             `json:"ocspServers,omitempty"`
+	IssuingCertificateURLs []string             `json:"issuingCertificateURLs,omitempty"`
+	CRLDistributionPoints  []string             `json:"crlDistributionPoints,omitempty"`
+	Policies               []string             `json:"policies,omitempty"`
+	Extensions             []inspectedExtension `json:"extensions"`
+	Fingerprints           fingerprints         `json:"fingerprints"`
+}
+
+type inspectedCsr struct {
+	Type               string               `json:"type"`
+	Subject            string               `json:"subject"`
+	SignatureAlgorithm string               `json:"signatureAlgorithm"`
+	SignatureValid     bool                 `json:"signatureValid"`
+	PublicKey          inspectedPublicKey   `json:"publicKey"`
+	SubjectAltNames    []string             `json:"subjectAltNames,omitempty"`
+	OkmsDomainId       string               `json:"okmsDomainId,omitempty"`
+	Extensions         []inspectedExtension `json:"extensions"`
+	Fingerprints       fingerprints         `json:"fingerprints"`
+}
+
+type inspectedRevokedCertificate struct {
+	SerialNumber   x509utils.BigInt `json:"serialNumber"`
+	RevocationTime time.Time        `json:"revocationTime"`
+	Reason         string           `json:"reason,omitempty"`
+}
+
+type inspectedCrl struct {
+	Type                string                        `json:"type"`
+	Issuer              string                        `json:"issuer"`
+	Number              x509utils.BigInt              `json:"number"`
+	ThisUpdate          time.Time                     `json:"thisUpdate"`
+	NextUpdate          time.Time                     `json:"nextUpdate"`
+	SignatureAlgorithm  string                        `json:"signatureAlgorithm"`
+	AuthorityKeyId      string                        `json:"authorityKeyId,omitempty"`
+	RevokedCertificates []inspectedRevokedCertificate `json:"revokedCertificates"`
+	Extensions          []inspectedExtension          `json:"extensions"`
+	Fingerprints        fingerprints                  `json:"fingerprints"`
+}
+
+func newInspectCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect FILE",
+		Short: "Decode and display certificates, certificate requests and CRLs",
+		Long: `Decode and display certificates, certificate requests and CRLs.
+
+The file type is detected automatically. It can be PEM encoded, with several blocks like a certificate chain,
+or DER encoded. The OKMS domain id is read from the "okms.domain:" otherName subject alternative name.`,
+		Args: cobra.ExactArgs(1),
+		// Inspecting local files does not need any KMS configuration
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			exit.OnErr(flagsmgmt.CheckOutputFormat(cmd))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			objects := exit.OnErr2(inspectFile(args[0]))
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(objects)
+				return
+			}
+			for i, obj := range objects {
+				if i > 0 {
+					fmt.Println()
+				}
+				printInspectedObject(obj)
+			}
+		},
+	}
+	return cmd
+}
+
+// inspectFile decodes all the certificates, certificate requests and CRLs found in file.
+func inspectFile(file string) ([]any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := x509utils.PemDecodeAll(data)
+	if err != nil {
+		// Not PEM encoded, try DER
+		return inspectDer(data)
+	}
+	objects := make([]any, 0, len(blocks))
+	for _, block := range blocks {
+		var (
+			obj any
+			err error
+		)
+		switch block.Type {
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				obj = inspectCertificate(cert)
+			}
+		case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+			var csr *x509.CertificateRequest
+			if csr, err = x509.ParseCertificateRequest(block.Bytes); err == nil {
+				obj = inspectCsr(csr)
+			}
+		case "X509 CRL":
+			var crl *x509.RevocationList
+			if crl, err = x509.ParseRevocationList(block.Bytes); err == nil {
+				obj = inspectCrl(crl)
+			}
+		default:
+			err = fmt.Errorf("Unsupported PEM block type %q", block.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+func inspectDer(data []byte) ([]any, error) {
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		objects := make([]any, 0, len(certs))
+		for _, cert := range certs {
+			objects = append(objects, inspectCertificate(cert))
+		}
+		return objects, nil
+	}
+	if csr, err := x509.ParseCertificateRequest(data); err == nil {
+		return []any{inspectCsr(csr)}, nil
+	}
+	if crl, err := x509.ParseRevocationList(data); err == nil {
+		return []any{inspectCrl(crl)}, nil
+	}
+	return nil, errors.New("No certificate, certificate request or CRL found")
+}
+
+func inspectCertificate(cert *x509.Certificate) inspectedCertificate {
+	ic := inspectedCertificate{
+		Type:                   inspectTypeCertificate,
+		Version:                cert.Version,
+		SerialNumber:           x509utils.BigInt{Int: cert.SerialNumber},
+		Subject:                cert.Subject.String(),
+		Issuer:                 cert.Issuer.String(),
+		NotBefore:              cert.NotBefore,
+		NotAfter:               cert.NotAfter,
+		SignatureAlgorithm:     cert.SignatureAlgorithm.String(),
+		PublicKey:              inspectPublicKey(cert.PublicKey),
+		IsCA:                   cert.IsCA,
+		KeyUsage:               certprofile.KeyUsageNames(cert.KeyUsage),
+		ExtKeyUsage:            certprofile.ExtKeyUsageNames(cert.ExtKeyUsage, cert.UnknownExtKeyUsage),
+		SubjectAltNames:        subjectAltNames(cert.DNSNames, cert.EmailAddresses, cert.IPAddresses, cert.URIs),
+		SubjectKeyId:           hex.EncodeToString(cert.SubjectKeyId),
+		AuthorityKeyId:         hex.EncodeToString(cert.AuthorityKeyId),
+		OCSPServers:            cert.OCSPServer,
+		IssuingCertificateURLs: cert.IssuingCertificateURL,
+		CRLDistributionPoints:  cert.CRLDistributionPoints,
+		Extensions:             inspectExtensions(cert.Extensions),
+		Fingerprints:           computeFingerprints(cert.Raw),
+	}
+	ic.OkmsDomainId, _ = x509utils.OkmsDomainId(cert.Extensions)
+	if cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		ic.MaxPathLen = &cert.MaxPathLen
+	}
+	for _, policy := range cert.Policies {
+		ic.Policies = append(ic.Policies, policy.String())
+	}
+	return ic
+}
+
+func inspectCsr(csr *x509.CertificateRequest) inspectedCsr {
+	ic := inspectedCsr{
+		Type:               inspectTypeCsr,
+		Subject:            csr.Subject.String(),
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+		SignatureValid:     csr.CheckSignature() == nil,
+		PublicKey:          inspectPublicKey(csr.PublicKey),
+		SubjectAltNames:    subjectAltNames(csr.DNSNames, csr.EmailAddresses, csr.IPAddresses, csr.URIs),
+		Extensions:         inspectExtensions(csr.Extensions),
+		Fingerprints:       computeFingerprints(csr.Raw),
+	}
+	ic.OkmsDomainId, _ = x509utils.OkmsDomainId(csr.Extensions)
+	return ic
+}
+
+func inspectCrl(crl *x509.RevocationList) inspectedCrl {
+	ic := inspectedCrl{
+		Type:                inspectTypeCrl,
+		Issuer:              crl.Issuer.String(),
+		Number:              x509utils.BigInt{Int: crl.Number},
+		ThisUpdate:          crl.ThisUpdate,
+		NextUpdate:          crl.NextUpdate,
+		SignatureAlgorithm:  crl.SignatureAlgorithm.String(),
+		AuthorityKeyId:      hex.EncodeToString(crl.AuthorityKeyId),
+		RevokedCertificates: []inspectedRevokedCertificate{},
+		Extensions:          inspectExtensions(crl.Extensions),
+		Fingerprints:        computeFingerprints(crl.Raw),
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked := inspectedRevokedCertificate{
+			SerialNumber:   x509utils.BigInt{Int: entry.SerialNumber},
+			RevocationTime: entry.RevocationTime,
+		}
+		if entry.ReasonCode != 0 {
+			revoked.Reason = x509utils.RevocationReasonString(entry.ReasonCode)
+		}
+		ic.RevokedCertificates = append(ic.RevokedCertificates, revoked)
+	}
+	return ic
+}
+
+func inspectPublicKey(pub any) inspectedPublicKey {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return inspectedPublicKey{Type: "RSA", Size: pub.N.BitLen()}
+	case *ecdsa.PublicKey:
+		return inspectedPublicKey{Type: "ECDSA", Size: pub.Curve.Params().BitSize, Curve: pub.Curve.Params().Name}
+	case ed25519.PublicKey:
+		return inspectedPublicKey{Type: "Ed25519", Size: 256}
+	default:
+		return inspectedPublicKey{Type: fmt.Sprintf("%T", pub)}
+	}
+}
+
+func inspectExtensions(exts []pkix.Extension) []inspectedExtension {
+	inspected := make([]inspectedExtension, 0, len(exts))
+	for _, ext := range exts {
+		inspected = append(inspected, inspectedExtension{
+			Id:       ext.Id.String(),
+			Name:     extensionNames[ext.Id.String()],
+			Critical: ext.Critical,
+		})
+	}
+	return inspected
+}
+
+func computeFingerprints(der []byte) fingerprints {
+	sha1Sum := sha1.Sum(der) //nolint:gosec // Only used for fingerprints
+	sha256Sum := sha256.Sum256(der)
+	return fingerprints{
+		Sha1:   formatFingerprint(sha1Sum[:]),
+		Sha256: formatFingerprint(sha256Sum[:]),
+	}
+}
+
+// formatFingerprint formats a digest like OpenSSL does, as colon separated upper case hex bytes.
+func formatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func printInspectedObject(obj any) {
+	var rows [][]string
+	switch obj := obj.(type) {
+	case inspectedCertificate:
+		pathLen := ""
+		if obj.MaxPathLen != nil {
+			pathLen = strconv.Itoa(*obj.MaxPathLen)
+		}
+		rows = [][]string{
+			{"Type", obj.Type},
+			{"Version", strconv.Itoa(obj.Version)},
+			{"Serial Number", obj.SerialNumber.String()},
+			{"Subject", obj.Subject},
+			{"Issuer", obj.Issuer},
+			{"Not Before", obj.NotBefore.Format(time.DateTime)},
+			{"Not After", obj.NotAfter.Format(time.DateTime)},
+			{"Signature Algorithm", obj.SignatureAlgorithm},
+			{"Public Key", obj.PublicKey.String()},
+			{"CA", strconv.FormatBool(obj.IsCA)},
+			{"Max Path Length", pathLen},
+			{"Key Usage", strings.Join(obj.KeyUsage, ", ")},
+			{"Extended Key Usage", strings.Join(obj.ExtKeyUsage, ", ")},
+			{"Subject Alt Names", strings.Join(obj.SubjectAltNames, "\n")},
+			{"OKMS Domain Id", obj.OkmsDomainId},
+			{"Subject Key Id", obj.SubjectKeyId},
+			{"Authority Key Id", obj.AuthorityKeyId},
+			{"OCSP Servers", strings.Join(obj.OCSPServers, "\n")},
+			{"Issuing Certificate URLs", strings.Join(obj.IssuingCertificateURLs, "\n")},
+			{"CRL Distribution Points", strings.Join(obj.CRLDistributionPoints, "\n")},
+			{"Policies", strings.Join(obj.Policies, ", ")},
+			{"Extensions", formatExtensions(obj.Extensions)},
+			{"SHA-1 Fingerprint", obj.Fingerprints.Sha1},
+			{"SHA-256 Fingerprint", obj.Fingerprints.Sha256},
+		}
+	case inspectedCsr:
+		rows = [][]string{
+			{"Type", obj.Type},
+			{"Subject", obj.Subject},
+			{"Signature Algorithm", obj.SignatureAlgorithm},
+			{"Signature Valid", strconv.FormatBool(obj.SignatureValid)},
+			{"Public Key", obj.PublicKey.String()},
+			{"Subject Alt Names", strings.Join(obj.SubjectAltNames, "\n")},
+			{"OKMS Domain Id", obj.OkmsDomainId},
+			{"Extensions", formatExtensions(obj.Extensions)},
+			{"SHA-1 Fingerprint", obj.Fingerprints.Sha1},
+			{"SHA-256 Fingerprint", obj.Fingerprints.Sha256},
+		}
+	case inspectedCrl:
+		revoked := make([]string, 0, len(obj.RevokedCertificates))
+		for _, r := range obj.RevokedCertificates {
+			line := fmt.Sprintf("%s (%s)", r.SerialNumber, r.RevocationTime.Format(time.DateTime))
+			if r.Reason != "" {
+				line += " " + r.Reason
+			}
+			revoked = append(revoked, line)
+		}
+		rows = [][]string{
+			{"Type", obj.Type},
+			{"Issuer", obj.Issuer},
+			{"Number", obj.Number.String()},
+			{"This Update", obj.ThisUpdate.Format(time.DateTime)},
+			{"Next Update", obj.NextUpdate.Format(time.DateTime)},
+			{"Signature Algorithm", obj.SignatureAlgorithm},
+			{"Authority Key Id", obj.AuthorityKeyId},
+			{"Revoked Certificates", strings.Join(revoked, "\n")},
+			{"Extensions", formatExtensions(obj.Extensions)},
+			{"SHA-1 Fingerprint", obj.Fingerprints.Sha1},
+			{"SHA-256 Fingerprint", obj.Fingerprints.Sha256},
+		}
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	for _, row := range rows {
+		if row[1] == "" {
+			continue
+		}
+		exit.OnErr(table.Append(row))
+	}
+	exit.OnErr(table.Render())
+}
+
+func (k inspectedPublicKey) String() string {
+	if k.Curve != "" {
+		return fmt.Sprintf("%s %s", k.Type, k.Curve)
+	}
+	if k.Size == 0 {
+		return k.Type
+	}
+	return fmt.Sprintf("%s %d bits", k.Type, k.Size)
+}
+
+func formatExtensions(exts []inspectedExtension) string {
+	lines := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		line := ext.Id
+		if ext.Name != "" {
+			line = fmt.Sprintf("%s (%s)", ext.Name, ext.Id)
+		}
+		if ext.Critical {
+			line += " critical"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// subjectAltNames formats subject alternative names in the OpenSSL format.
+func subjectAltNames(dnsNames, emails []string, ips []net.IP, uris []*url.URL) []string {
+	var names []string
+	for _, name := range dnsNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, email := range emails {
+		names = append(names, "email:"+email)
+	}
+	for _, ip := range ips {
+		names = append(names, "IP:"+ip.String())
+	}
+	for _, uri := range uris {
+		names = append(names, "URI:"+uri.String())
+	}
+	return names
+}
@@ -0,0 +1,150 @@
+package x509
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils/cadb"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+)
+
+// issuedCertificate is an issued certificate record, with its current status.
+type issuedCertificate struct {
+	*cadb.Entry
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func newIssuedCertificate(e *cadb.Entry) issuedCertificate {
+	cert := issuedCertificate{Entry: e, Status: e.Status(time.Now())}
+	if e.ReasonCode != nil {
+		cert.Reason = x509utils.RevocationReasonString(*e.ReasonCode)
+	}
+	return cert
+}
+
+// requireCaDatabase opens the local CA state directory, or exits if none is set.
+func requireCaDatabase(cmd *cobra.Command) *cadb.DB {
+	db := openCaDatabase(cmd)
+	if db == nil {
+		exit.OnErr(errors.New("No CA state directory set, use --state-dir or KMS_X509_STATE_DIR"))
+	}
+	return db
+}
+
+// loadIssuerFlag loads the CA certificate given in the --issuer flag, or returns nil if not set.
+func loadIssuerFlag(issuerFile string) *x509.Certificate {
+	if issuerFile == "" {
+		return nil
+	}
+	return exit.OnErr2(x509utils.LoadCertificates(issuerFile))[0]
+}
+
+func newRevokeCommand() *cobra.Command {
+	var (
+		reason     string
+		date       string
+		issuerFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "revoke SERIAL",
+		Short: "Revoke a certificate recorded in the CA state directory",
+		Long: `Revoke a certificate recorded in the CA state directory, identified by its serial number
+(decimal, or 0x-prefixed hex).
+
+The revocation is included in the CRLs generated afterward with "okms x509 create crl".
+If several CAs issued a certificate with the same serial number, the issuing CA must be given with --issuer.
+
+Valid revocation reasons are unspecified, keyCompromise, cACompromise, affiliationChanged, superseded,
+cessationOfOperation, certificateHold, privilegeWithdrawn and aACompromise.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			db := requireCaDatabase(cmd)
+			serial := exit.OnErr2(x509utils.ParseBigInt(args[0]))
+			reasonCode := exit.OnErr2(x509utils.ParseRevocationReason(reason))
+			revocationDate := time.Now()
+			if date != "" {
+				revocationDate = exit.OnErr2(time.Parse(time.RFC3339, date))
+			}
+
+			entry := exit.OnErr2(db.Revoke(serial, loadIssuerFlag(issuerFile), reasonCode, revocationDate))
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(newIssuedCertificate(entry))
+				return
+			}
+			fmt.Printf("Certificate %s (%s) revoked\n", entry.SerialNumber, entry.Subject)
+		},
+	}
+
+	cmd.Flags().StringVar(&reason, "reason", "unspecified", "Revocation reason")
+	cmd.Flags().StringVar(&date, "date", "", "Revocation date, in RFC3339 format. Defaults to now")
+	cmd.Flags().StringVar(&issuerFile, "issuer", "", "Path to the PEM encoded certificate of the issuing CA")
+
+	return cmd
+}
+
+func newListIssuedCommand() *cobra.Command {
+	var (
+		status     string
+		issuerFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list-issued",
+		Short: "List the certificates recorded in the CA state directory",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			idx := exit.OnErr2(requireCaDatabase(cmd).Load())
+			entries := idx.Certificates
+			if issuer := loadIssuerFlag(issuerFile); issuer != nil {
+				entries = idx.IssuedBy(issuer)
+			}
+
+			certs := []issuedCertificate{}
+			for _, e := range entries {
+				cert := newIssuedCertificate(e)
+				if status != "" && cert.Status != status {
+					continue
+				}
+				certs = append(certs, cert)
+			}
+
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(certs)
+				return
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			table.Header([]string{"Serial Number", "Subject", "Issuer", "Not After", "Status", "Revoked At", "Reason"})
+			for _, c := range certs {
+				revokedAt := ""
+				if c.RevocationDate != nil {
+					revokedAt = c.RevocationDate.Format(time.DateTime)
+				}
+				exit.OnErr(table.Append([]string{
+					c.SerialNumber.String(),
+					c.Subject,
+					c.Issuer,
+					c.NotAfter.Format(time.DateTime),
+					c.Status,
+					revokedAt,
+					c.Reason,
+				}))
+			}
+			exit.OnErr(table.Render())
+		},
+	}
+
+	cmd.Flags().StringVar(&status, "status", "", "Only list certificates with the given status [valid|revoked|expired]")
+	cmd.Flags().StringVar(&issuerFile, "issuer", "", "Only list certificates issued by the CA whose PEM encoded certificate is given")
+
+	return cmd
+}
@@ -0,0 +1,204 @@
+package x509
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ovh/okms-cli/common/utils/cadb"
+	"github.com/ovh/okms-cli/common/utils/certprofile"
+	"github.com/ovh/okms-cli/common/utils/csrpolicy"
+)
+
+// errNonCompliantRequest is returned by [certIssuer.issue] when a request is rejected by the CSR policy.
+var errNonCompliantRequest = errors.New("The certificate request does not comply with the policy")
+
+// certIssuer issues certificates from certificate requests, with a CA whose key is stored in the KMS.
+// It is shared by the sign command and the EST server.
+type certIssuer struct {
+	ca      *x509.Certificate
+	signer  crypto.Signer
+	db      *cadb.DB
+	policy  *csrpolicy.Policy
+	profile *certprofile.Profile
+	// authorityKeyId is the CA key identifier. If nil, the CA's Subject Key Id is used.
+	authorityKeyId []byte
+
+	validity     time.Duration
+	validitySet  bool
+	serverAuth   bool
+	clientAuth   bool
+	ocspSigning  bool
+	timeStamping bool
+
+	isCA       bool
+	pathLen    int
+	pathLenSet bool
+	// subjectKeyId, if not nil, replaces the subject key id of the request.
+	subjectKeyId *uuid.UUID
+	// overrideSan, if not nil, replaces the subject alternative names of the request.
+	overrideSan *csrpolicy.SubjectAltNames
+
+	// warn reports non fatal issues, like stripped extensions or capped validity.
+	warn func(format string, args ...any)
+}
+
+// issue checks csr against the policy, then issues and records the certificate. If the certificate is issued
+// but cannot be recorded, it is returned with the error. Otherwise the serial number reserved for it is released
+// on failure.
+func (iss *certIssuer) issue(csr *x509.CertificateRequest) (cert *x509.Certificate, err error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	policy := iss.policy
+	if policy == nil {
+		policy = new(csrpolicy.Policy)
+	}
+	if san := iss.overrideSan; san != nil {
+		san.Override(csr)
+	}
+	if err := policy.Check(csr); err != nil {
+		return nil, fmt.Errorf("%w:\n%w", errNonCompliantRequest, err)
+	}
+	extensions, stripped, err := policy.Extensions(csr)
+	if err != nil {
+		return nil, fmt.Errorf("%w:\n%w", errNonCompliantRequest, err)
+	}
+	for _, oid := range stripped {
+		iss.warn("Warning: extension %s of the certificate request is not allowed and was stripped\n", oid)
+	}
+
+	// The profile's CA settings override the command line ones, and are checked against the issuing CA the same way
+	isCA, pathLen, pathLenSet := iss.isCA, iss.pathLen, iss.pathLenSet
+	if p := iss.profile; p != nil {
+		if p.CA != nil {
+			isCA = *p.CA
+		}
+		if p.PathLen != nil {
+			pathLen, pathLenSet = *p.PathLen, true
+		}
+	}
+	var (
+		maxPathLen     int
+		maxPathLenZero bool
+	)
+	if isCA {
+		if maxPathLen, maxPathLenZero, err = intermediatePathLen(iss.ca, pathLen, pathLenSet); err != nil {
+			return nil, err
+		}
+	}
+
+	serial, err := newUniqueSerialNumber(iss.db)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cert == nil {
+			releaseSerialNumber(iss.db, serial)
+		}
+	}()
+	certTemplate := &x509.Certificate{
+		SignatureAlgorithm: iss.ca.SignatureAlgorithm,
+		DNSNames:           csr.DNSNames,
+		EmailAddresses:     csr.EmailAddresses,
+		IPAddresses:        csr.IPAddresses,
+		URIs:               csr.URIs,
+		ExtraExtensions:    extensions,
+
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		MaxPathLen:            maxPathLen,
+		MaxPathLenZero:        maxPathLenZero,
+
+		SerialNumber:   serial,
+		Issuer:         iss.ca.Subject,
+		Subject:        csr.Subject,
+		AuthorityKeyId: iss.authorityKeyId,
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().Add(iss.validity),
+		ExtKeyUsage:    []x509.ExtKeyUsage{},
+	}
+	if isCA {
+		certTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	} else {
+		certTemplate.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
+		if iss.clientAuth {
+			certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		}
+		if iss.serverAuth {
+			certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		}
+		if iss.ocspSigning {
+			certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning)
+			certTemplate.ExtraExtensions = append(certTemplate.ExtraExtensions, ocspNoCheckExtension())
+		}
+		if iss.timeStamping {
+			// RFC 3161 requires the time stamping extended key usage to be the only one, and to be critical,
+			// which x509.CreateCertificate does not do.
+			certTemplate.KeyUsage = x509.KeyUsageDigitalSignature
+			certTemplate.ExtraExtensions = append(certTemplate.ExtraExtensions, timeStampingExtKeyUsageExtension())
+		}
+	}
+
+	csrKeyId, found := extractCsrSubjectKeyId(csr)
+	if iss.subjectKeyId != nil {
+		csrKeyId = *iss.subjectKeyId
+		// Do not let the request's subject key identifier override the given one
+		certTemplate.ExtraExtensions = slices.DeleteFunc(certTemplate.ExtraExtensions, func(ext pkix.Extension) bool {
+			return ext.Id.Equal(OID_CE_SUBJECT_KEY_IDENTIFIER)
+		})
+		found = true
+	} else if isCA && !found {
+		iss.warn("Warning: the request has no KMS key id, the KEY-ID will be required to sign with the new CA. Use --subject-key-id to set it\n")
+	}
+	if found {
+		certTemplate.SubjectKeyId = csrKeyId[:]
+	}
+
+	if err := applyProfile(iss.profile, certTemplate, iss.validitySet); err != nil {
+		return nil, err
+	}
+	if policy.CapValidity(certTemplate) {
+		iss.warn("Warning: validity capped to %s by the policy\n", policy.MaxValidity)
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, certTemplate, iss.ca, csr.PublicKey, iss.signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err = x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	// The certificate is returned even if it cannot be recorded, so that it is not lost
+	return cert, recordCertificate(iss.db, certBytes)
+}
+
+// intermediatePathLen returns the path length constraint of an intermediate CA issued by ca. If not set,
+// it is the issuing CA's one minus one, or unlimited.
+func intermediatePathLen(ca *x509.Certificate, pathLen int, set bool) (int, bool, error) {
+	issuerPathLen := -1
+	if ca.MaxPathLen > 0 || ca.MaxPathLenZero {
+		issuerPathLen = ca.MaxPathLen
+	}
+	switch {
+	case issuerPathLen == 0:
+		return 0, false, errors.New("The issuing CA path length constraint does not allow issuing intermediate CAs")
+	case !set:
+		if issuerPathLen < 0 {
+			return -1, false, nil
+		}
+		pathLen = issuerPathLen - 1
+	case pathLen < 0:
+		return 0, false, errors.New("Path length must be positive")
+	case issuerPathLen > 0 && pathLen >= issuerPathLen:
+		return 0, false, fmt.Errorf("Path length must be lower than the issuing CA's one (%d)", issuerPathLen)
+	}
+	return pathLen, pathLen == 0, nil
+}
@@ -0,0 +1,318 @@
+package x509
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/utils"
+	"github.com/ovh/okms-cli/common/utils/cadb"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	ocspRequestContentType  = "application/ocsp-request"
+	ocspResponseContentType = "application/ocsp-response"
+	// ocspMaxRequestSize is the maximum accepted size of an OCSP request body.
+	ocspMaxRequestSize = 64 * 1024
+)
+
+func newOcspCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ocsp",
+		Short: "OCSP responder signing with a key stored in the KMS",
+	}
+	cmd.AddCommand(
+		newOcspRespondCommand(),
+	)
+	return cmd
+}
+
+// ocspResponder creates OCSP responses for the certificates issued by a CA,
+// from a JSON revoke list as used by "x509 create crl", and from the CA state directory if any.
+type ocspResponder struct {
+	ca *x509.Certificate
+	// responderCert is the delegated OCSP signing certificate, or nil if responses are signed by the CA.
+	responderCert *x509.Certificate
+	signer        crypto.Signer
+	// revokeList is the path of the JSON revoke list, if any.
+	revokeList string
+	// db is the CA state directory, whose revoked certificates are reported as revoked, and whose
+	// certificates issued by the CA are known, if not nil.
+	db *cadb.DB
+	// issued are the serial numbers of the certificates issued by the CA. If nil, every
+	// certificate not revoked is considered good.
+	issued   map[string]bool
+	validity time.Duration
+}
+
+// respond returns a DER encoded OCSP response for the DER encoded OCSP request.
+// Malformed requests and requests for another CA get an error response. An error is
+// returned along with an internalError response if the response cannot be created.
+func (r *ocspResponder) respond(reqDer []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(reqDer)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	if !r.isIssuer(req) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	resp, err := r.respondSerial(req.SerialNumber, req.HashAlgorithm)
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, err
+	}
+	return resp, nil
+}
+
+// respondSerial returns a DER encoded OCSP response for the certificate with the given serial number.
+func (r *ocspResponder) respondSerial(serial *big.Int, issuerHash crypto.Hash) ([]byte, error) {
+	var entries []revocationEntry
+	if r.revokeList != "" {
+		var err error
+		if entries, err = loadRevocationEntries(r.revokeList); err != nil {
+			return nil, err
+		}
+	}
+	issued := r.issued
+	if r.db != nil {
+		idx, err := r.db.Load()
+		if err != nil {
+			return nil, err
+		}
+		issued = maps.Clone(r.issued)
+		if issued == nil {
+			issued = make(map[string]bool)
+		}
+		for _, e := range idx.IssuedBy(r.ca) {
+			issued[e.SerialNumber.String()] = true
+			if e.RevocationDate != nil {
+				entries = append(entries, revocationEntry{SerialNumber: e.SerialNumber, RevocationDate: *e.RevocationDate, ReasonCode: e.ReasonCode})
+			}
+		}
+	}
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: serial,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(r.validity),
+		IssuerHash:   issuerHash,
+	}
+	if issued != nil && !issued[serial.String()] {
+		template.Status = ocsp.Unknown
+	}
+	for _, entry := range entries {
+		if entry.SerialNumber.Int == nil || entry.SerialNumber.Cmp(serial) != 0 {
+			continue
+		}
+		template.Status = ocsp.Revoked
+		template.RevokedAt = entry.RevocationDate
+		if entry.ReasonCode != nil {
+			template.RevocationReason = *entry.ReasonCode
+		}
+		break
+	}
+
+	signingCert := r.ca
+	if r.responderCert != nil {
+		signingCert = r.responderCert
+		template.Certificate = r.responderCert
+	}
+	return ocsp.CreateResponse(r.ca, signingCert, template, r.signer)
+}
+
+// isIssuer checks that the request targets a certificate issued by the responder's CA.
+func (r *ocspResponder) isIssuer(req *ocsp.Request) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm asn1.RawValue
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(r.ca.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(r.ca.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+	return bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash)
+}
+
+func (r *ocspResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var reqDer []byte
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		// RFC 6960 appendix A.1: the request is base64 encoded and URL-escaped in the path.
+		var b64 string
+		if b64, err = url.PathUnescape(strings.TrimPrefix(req.URL.EscapedPath(), "/")); err == nil {
+			reqDer, err = base64.StdEncoding.DecodeString(b64)
+		}
+	case http.MethodPost:
+		if req.Header.Get("Content-Type") != ocspRequestContentType {
+			http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		reqDer, err = io.ReadAll(http.MaxBytesReader(w, req.Body, ocspMaxRequestSize))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := ocsp.MalformedRequestErrorResponse
+	if err == nil {
+		if resp, err = r.respond(reqDer); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to answer OCSP request from %s: %s\n", req.RemoteAddr, err)
+		}
+	}
+	w.Header().Set("Content-Type", ocspResponseContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	_, _ = w.Write(resp)
+}
+
+func newOcspRespondCommand() *cobra.Command {
+	var (
+		requestFile   string
+		serial        string
+		responderFile string
+		issuedFile    string
+		validity      time.Duration
+		serve         bool
+		listen        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "respond CA [REVOKE_LIST] [KEY-ID]",
+		Short: "Create OCSP responses, or serve them over HTTP",
+		Long: `Create DER encoded OCSP responses for the certificates issued by CA, signed with a key stored in the KMS.
+
+REVOKE_LIST is the same JSON revoke list as used by "okms x509 create crl". Certificates listed there are
+reported as revoked. Other certificates are reported as good, unless --issued is given, in which case
+certificates not issued by the CA are reported as unknown. Requests for certificates of another CA get
+an "unauthorized" error response.
+
+If a CA state directory is set, the certificates revoked there with "okms x509 revoke" are reported as revoked,
+and the certificates recorded neither there nor in --issued are reported as unknown. REVOKE_LIST is then optional.
+
+Responses are signed by the CA itself, or by a delegated OCSP signing certificate given with --responder-cert.
+A delegated certificate must be issued by CA and have the OCSP signing extended key usage.
+The KEY-ID parameter is the signing key: the CA's key, or the delegated responder's key. It can be left empty
+if the signing certificate's Subject Key Id matches the key id UUID.
+
+With --serve, an HTTP OCSP responder is started instead (RFC 6960 appendix A), answering both GET and POST
+requests. REVOKE_LIST and the state directory are read again for each request, so that revocations are taken
+into account immediately.`,
+		Example: `  # Answer an OCSP request, and check the response with openssl
+  openssl ocsp -issuer ca.pem -cert cert.pem -no_nonce -reqout req.der
+  okms x509 ocsp respond ca.pem revoked.json --request req.der > resp.der
+  openssl ocsp -respin resp.der -issuer ca.pem -cert cert.pem -CAfile ca.pem -no_nonce
+
+  # Serve OCSP responses
+  okms x509 ocsp respond ca.pem revoked.json --serve --listen :8080`,
+		Args: cobra.RangeArgs(1, 3),
+		Run: func(cmd *cobra.Command, args []string) {
+			ca := exit.OnErr2(x509utils.LoadCertificates(args[0]))[0]
+			responder := &ocspResponder{
+				ca:       ca,
+				db:       openCaDatabase(cmd),
+				validity: validity,
+			}
+			// With a CA state directory, REVOKE_LIST is optional, so a single argument after CA
+			// is the KEY-ID if it is a key reference.
+			keyArgs := args[1:]
+			if len(args) == 3 || (len(args) == 2 && (responder.db == nil || !utils.IsKeyRef(args[1]))) {
+				responder.revokeList = args[1]
+				keyArgs = args[2:]
+				// Fail early on invalid revoke list
+				exit.OnErr2(loadRevocationEntries(responder.revokeList))
+			} else if responder.db == nil {
+				exit.OnErr(errors.New("Missing REVOKE_LIST parameter, required when no CA state directory is set"))
+			}
+
+			signingCert := ca
+			if responderFile != "" {
+				responder.responderCert = exit.OnErr2(x509utils.LoadCertificates(responderFile))[0]
+				if err := responder.responderCert.CheckSignatureFrom(ca); err != nil {
+					exit.OnErr(fmt.Errorf("The responder certificate is not issued by the CA: %w", err))
+				}
+				if !slices.Contains(responder.responderCert.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning) {
+					exit.OnErr(errors.New("The responder certificate does not have the OCSP signing extended key usage"))
+				}
+				signingCert = responder.responderCert
+			}
+			keyId := keyIdFromArgOrCert(cmd.Context(), keyArgs, 0, signingCert)
+			responder.signer = exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId))
+			if !x509utils.PublicKeysEqual(signingCert.PublicKey, responder.signer.Public()) {
+				exit.OnErr(errors.New("The signing certificate's public key does not match the signing key"))
+			}
+
+			if issuedFile != "" {
+				responder.issued = make(map[string]bool)
+				for _, c := range exit.OnErr2(x509utils.LoadCertificates(issuedFile)) {
+					responder.issued[c.SerialNumber.String()] = true
+				}
+			}
+
+			if serve {
+				server := &http.Server{
+					Addr:              listen,
+					Handler:           responder,
+					ReadHeaderTimeout: 10 * time.Second,
+					ReadTimeout:       30 * time.Second,
+					WriteTimeout:      30 * time.Second,
+				}
+				fmt.Fprintf(os.Stderr, "OCSP responder listening on %s\n", listen)
+				exit.OnErr(common.Serve(cmd.Context(), server, server.ListenAndServe))
+				return
+			}
+
+			var resp []byte
+			if requestFile != "" {
+				resp = exit.OnErr2(responder.respond(exit.OnErr2(os.ReadFile(requestFile))))
+				if bytes.Equal(resp, ocsp.UnauthorizedErrorResponse) {
+					exit.OnErr(errors.New("The OCSP request is not for a certificate issued by the CA"))
+				} else if bytes.Equal(resp, ocsp.MalformedRequestErrorResponse) {
+					exit.OnErr(errors.New("Malformed OCSP request"))
+				}
+			} else {
+				resp = exit.OnErr2(responder.respondSerial(exit.OnErr2(x509utils.ParseBigInt(serial)), crypto.SHA1))
+			}
+			exit.OnErr2(os.Stdout.Write(resp))
+		},
+	}
+
+	cmd.Flags().StringVar(&requestFile, "request", "", "Path to a DER encoded OCSP request")
+	cmd.Flags().StringVar(&serial, "serial", "", "Serial number of the certificate to create a response for (decimal, or 0x-prefixed hex)")
+	cmd.Flags().StringVar(&responderFile, "responder-cert", "", "Path to a PEM encoded delegated OCSP signing certificate")
+	cmd.Flags().StringVar(&issuedFile, "issued", "", "Path to a PEM bundle of the certificates issued by the CA. Other certificates are reported as unknown")
+	cmd.Flags().DurationVar(&validity, "validity", 24*time.Hour, "Validity of the responses (next update)")
+	cmd.Flags().BoolVar(&serve, "serve", false, "Serve OCSP responses over HTTP")
+	cmd.Flags().StringVar(&listen, "listen", ":8080", "Address to listen on with --serve")
+	cmd.MarkFlagsOneRequired("request", "serial", "serve")
+	cmd.MarkFlagsMutuallyExclusive("request", "serial", "serve")
+
+	return cmd
+}
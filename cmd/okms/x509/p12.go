@@ -0,0 +1,131 @@
+package x509
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/pkcs12"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/ovh/okms-cli/internal/utils"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+func newExportP12Command() *cobra.Command {
+	var (
+		wrappingKeyId     string
+		wrappingAlgorithm string
+		unwrapWith        string
+		password          string
+		friendlyName      string
+		legacy            bool
+		outFile           string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export-p12 CERT KEY-ID",
+		Short: "Export a certificate and its extractable KMS private key as a PKCS#12 bundle",
+		Long: `Export a certificate and its extractable KMS private key as a password protected PKCS#12 bundle.
+
+The CERT file may be a bundle, starting with the certificate and followed by its chain, which is included in
+the PKCS#12 bundle. KEY-ID is the certificate's private key, which must have been created as extractable.
+
+The private key is exported wrapped with the KMS transport key --wrapping-key-id, and unwrapped locally with the
+transport private key given with --unwrap-with, so that it never transits in the clear.
+
+The password is given with --password, as a value, @path to read it from a file, or - to read it from stdin.
+It is prompted for otherwise. The bundle uses AES-256-CBC, PBKDF2 and SHA-256, or triple DES and SHA-1 with
+--legacy for older systems.`,
+		Example: `  okms x509 export-p12 cert.pem <key-id> --wrapping-key-id <transport-id> \
+      --unwrap-with @transport.pem --password @password.txt --out bundle.p12`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			certs := exit.OnErr2(x509utils.LoadCertificates(args[0]))
+			keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[1]))
+			wrapKeyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), wrappingKeyId))
+			algo := types.WrappingAlgorithms(wrappingAlgorithm)
+			if !algo.Valid() {
+				exit.OnErr(fmt.Errorf("Invalid wrapping algorithm %q, expected one of [RSA-OAEP|RSA-OAEP-256]", wrappingAlgorithm))
+			}
+			transportKey, ok := exit.OnErr2(x509utils.ParsePrivateKey(flagsmgmt.BytesFromArg(unwrapWith, 32*1024))).(*rsa.PrivateKey)
+			if !ok {
+				exit.OnErr(errors.New("The transport private key must be an RSA key"))
+			}
+
+			wrappedKeys := exit.OnErr2(common.Client().GetWrappedServiceKey(cmd.Context(), common.GetOkmsId(), keyId, wrapKeyId, types.PKCS8, algo))
+			key := unwrapPrivateKey(wrappedKeys, transportKey)
+			if !x509utils.PublicKeysEqual(certs[0].PublicKey, key.Public()) {
+				exit.OnErr(errors.New("The certificate's public key does not match the KMS key"))
+			}
+
+			if password == "" {
+				password = promptNewPassword("PKCS#12 password")
+			} else {
+				password = strings.TrimRight(flagsmgmt.StringFromArg(password, 1024), "\r\n")
+			}
+			chain := x509utils.OrderChain(certs[0], certs)[1:]
+			p12 := exit.OnErr2(pkcs12.Encode(password, key, certs[0], chain, pkcs12.Options{Legacy: legacy, FriendlyName: friendlyName}))
+			exit.OnErr(utils.WritePrivateFile(outFile, p12))
+		},
+	}
+
+	cmd.Flags().StringVar(&wrappingKeyId, "wrapping-key-id", "", "ID of the transport (wrapping) key used to export the private key")
+	cmd.Flags().StringVar(&wrappingAlgorithm, "wrapping-algorithm", string(types.RSAOAEP256), "Key wrapping algorithm [RSA-OAEP|RSA-OAEP-256]")
+	cmd.Flags().StringVar(&unwrapWith, "unwrap-with", "", "PEM encoded private part of the transport key, as a value, @path or - for stdin")
+	cmd.Flags().StringVar(&password, "password", "", "Password of the bundle, as a value, @path or - for stdin. Prompted if not set")
+	cmd.Flags().StringVar(&friendlyName, "name", "", "Friendly name (alias) of the key in the bundle")
+	cmd.Flags().BoolVar(&legacy, "legacy", false, "Use the legacy triple DES and SHA-1 algorithms")
+	cmd.Flags().StringVar(&outFile, "out", "", "Path of the PKCS#12 file to write")
+	_ = cmd.MarkFlagRequired("wrapping-key-id")
+	_ = cmd.MarkFlagRequired("unwrap-with")
+	_ = cmd.MarkFlagRequired("out")
+	return cmd
+}
+
+// unwrapPrivateKey decrypts the wrapped PKCS#8 key material with the transport key, and returns the private key.
+func unwrapPrivateKey(wrappedKeys []types.WrappedKeyEntry, transportKey *rsa.PrivateKey) crypto.Signer {
+	key, err := common.UnwrapKey(wrappedKeys, transportKey, func(_ types.WrappedKeyEntry, material []byte) (crypto.Signer, error) {
+		key, err := x509utils.ParsePrivateKey(material)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("Unsupported private key type %T", key)
+		}
+		return signer, nil
+	})
+	if err != nil {
+		exit.OnErr(fmt.Errorf("The exported key material contains no private key: %w", err))
+	}
+	return key
+}
+
+// promptNewPassword prompts for a new password twice, until both match.
+func promptNewPassword(prompt string) string {
+	for {
+		password := promptPassword(prompt)
+		if password == "" {
+			fmt.Fprintln(os.Stderr, "The password cannot be empty")
+			continue
+		}
+		if promptPassword("Confirm "+prompt) == password {
+			return password
+		}
+		fmt.Fprintln(os.Stderr, "Passwords do not match")
+	}
+}
+
+func promptPassword(prompt string) string {
+	return exit.OnErr2(pterm.DefaultInteractiveTextInput.WithMask("*").WithOnInterruptFunc(func() {
+		exit.OnErr(errors.New("Interrupted"))
+	}).Show(prompt))
+}
@@ -1,16 +1,17 @@
 package x509
 
 import (
-	"crypto/rand"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/utils/csrpolicy"
 	"github.com/ovh/okms-cli/common/utils/exit"
 	"github.com/ovh/okms-cli/common/utils/x509utils"
 	"github.com/spf13/cobra"
@@ -21,8 +22,18 @@ func createSignCsrCommand() *cobra.Command {
 		validity        time.Duration
 		usageServerAuth bool
 		usageClientAuth bool
-
-		isCA bool
+		usageTimeStamp  bool
+		usageOcspSign   bool
+
+		isCA         bool
+		pathLen      int
+		subjectKeyId string
+		chain        bool
+		withRoot     bool
+		profile      *profileParams
+		policyFile   string
+		overrideSan  []string
+		key          *signerParams
 	)
 
 	cmd := &cobra.Command{
@@ -30,88 +41,143 @@ func createSignCsrCommand() *cobra.Command {
 		Short: "Sign a certificate request with a CA whose key is stored in the KMS",
 		Long: `Sign a certificate request with a CA whose key is stored in the KMS.
 
+The CA file may be a bundle, starting with the issuing CA certificate and followed by its chain. With --chain,
+the issued certificate is output followed by the issuing CA and its intermediates, in the order expected by
+servers like nginx or Envoy. The root CA is included with --with-root.
+
 The KEY-ID parameter can be left empty if the CA's Subject Key Id matches the key id UUID. Otherwise,
-KEY-ID must be the CA's private key UUID`,
+KEY-ID must be the CA's private key UUID. A CA key created with KMIP is given with --kmip instead.
+
+Intermediate CAs are issued with --new-ca. Their path length is derived from the issuing CA's one, unless set
+with --path-len. Their Subject Key Id is the KMS key id found in the request, or the one given with
+--subject-key-id, so that they can sign without giving KEY-ID.
+
+If a CA state directory is set, the issued certificate is recorded there.
+
+The subject alternative names of the request can be replaced with --override-san, using the OpenSSL format
+(DNS:name, email:address, IP:address or URI:uri).
+
+The extensions decided by the issuer (key usages, basic constraints, ...) are never copied from the request.
+The subject key identifier is copied, and the other extensions are stripped unless allowed by a CSR policy.
+` + csrPolicyHelp + profileHelp,
 		Args: cobra.RangeArgs(2, 3),
 		Run: func(cmd *cobra.Command, args []string) {
+			if cmd.Flags().Changed("path-len") && !isCA {
+				exit.OnErr(errors.New("The --path-len flag requires --new-ca"))
+			}
 			csrData := exit.OnErr2(os.ReadFile(args[0]))
-			caData := exit.OnErr2(os.ReadFile(args[1]))
-
-			caDer := exit.OnErr2(x509utils.PemDecode(caData))
-			ca := exit.OnErr2(x509.ParseCertificate(caDer.Bytes))
+			caCerts := exit.OnErr2(x509utils.LoadCertificates(args[1]))
+			ca := caCerts[0]
 
 			csrDer := exit.OnErr2(x509utils.PemDecode(csrData))
 			csr := exit.OnErr2(x509.ParseCertificateRequest(csrDer.Bytes))
-			exit.OnErr(csr.CheckSignature())
-
-			var keyId uuid.UUID
-			if len(args) >= 3 {
-				keyId = exit.OnErr2(uuid.Parse(args[2]))
-			} else {
-				if len(ca.SubjectKeyId) == 0 || len(ca.SubjectKeyId) != 16 {
-					exit.OnErr(errors.New("Cannot use CA's subject key id, please provide the KEY-ID"))
-				}
-				keyId = uuid.UUID(ca.SubjectKeyId)
-			}
 
-			signer := exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId))
-
-			certTemplate := &x509.Certificate{
-				SignatureAlgorithm: ca.SignatureAlgorithm,
-				DNSNames:           csr.DNSNames,
-				EmailAddresses:     csr.EmailAddresses,
-				IPAddresses:        csr.IPAddresses,
-				URIs:               csr.URIs,
-				ExtraExtensions:    csr.Extensions,
-
-				IsCA:                  isCA,
-				BasicConstraintsValid: !isCA,
-
-				SerialNumber:   newSerialNumber(),
-				Issuer:         ca.Subject,
-				Subject:        csr.Subject,
-				AuthorityKeyId: keyId[:],
-				NotBefore:      time.Now(),
-				NotAfter:       time.Now().Add(validity),
-				ExtKeyUsage:    []x509.ExtKeyUsage{},
+			issuer := &certIssuer{
+				ca:           ca,
+				profile:      exit.OnErr2(profile.load()),
+				validity:     validity,
+				validitySet:  cmd.Flags().Changed("validity"),
+				serverAuth:   usageServerAuth,
+				clientAuth:   usageClientAuth,
+				ocspSigning:  usageOcspSign,
+				timeStamping: usageTimeStamp,
+				isCA:         isCA,
+				pathLen:      pathLen,
+				pathLenSet:   cmd.Flags().Changed("path-len"),
+				warn:         func(format string, args ...any) { fmt.Fprintf(os.Stderr, format, args...) },
 			}
-			if isCA {
-				certTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
-			} else {
-				certTemplate.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
-				if usageClientAuth {
-					certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
-				}
-				if usageServerAuth {
-					certTemplate.ExtKeyUsage = append(certTemplate.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+			if policyFile != "" {
+				issuer.policy = exit.OnErr2(csrpolicy.Load(policyFile))
+			}
+			if len(overrideSan) > 0 {
+				issuer.overrideSan = exit.OnErr2(csrpolicy.ParseSubjectAltNames(overrideSan))
+			}
+			if subjectKeyId != "" {
+				csrKeyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), subjectKeyId))
+				pub := exit.OnErr2(common.Client().ExportPublicKey(cmd.Context(), common.GetOkmsId(), csrKeyId))
+				if !x509utils.PublicKeysEqual(csr.PublicKey, pub) {
+					exit.OnErr(fmt.Errorf("The certificate request public key does not match the KMS key %s", csrKeyId))
 				}
+				issuer.subjectKeyId = &csrKeyId
 			}
 
-			if csrKeyId, found := extractCsrSubjectKeyId(csr); found {
-				certTemplate.SubjectKeyId = csrKeyId[:]
-			}
+			issuer.signer, issuer.authorityKeyId = key.signer(cmd, args, 2, ca)
+			issuer.db = openCaDatabase(cmd)
 
-			certBytes := exit.OnErr2(x509.CreateCertificate(rand.Reader, certTemplate, ca, csr.PublicKey, signer))
+			cert, err := issuer.issue(csr)
+			if cert == nil {
+				exit.OnErr(err)
+			}
 
 			pemBlock := pem.Block{
 				Type:  "CERTIFICATE",
-				Bytes: certBytes,
+				Bytes: cert.Raw,
 			}
 			exit.OnErr(pem.Encode(os.Stdout, &pemBlock))
+			if chain || withRoot {
+				for _, c := range x509utils.OrderChain(ca, caCerts) {
+					if !withRoot && x509utils.IsSelfSigned(c) {
+						continue
+					}
+					exit.OnErr(pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}))
+				}
+			}
+			// Fail on the record error once the certificate is printed
+			exit.OnErr(err)
 		},
 	}
 	cmd.Flags().DurationVar(&validity, "validity", 365*24*time.Hour, "Validity duration")
 	cmd.Flags().BoolVar(&usageServerAuth, "server-auth", false, "Enable server auth extended key usage")
 	cmd.Flags().BoolVar(&usageClientAuth, "client-auth", false, "Enable client auth extended key usage")
+	cmd.Flags().BoolVar(&usageOcspSign, "ocsp-signing", false, "Enable OCSP signing extended key usage, for delegated OCSP responders")
+	cmd.Flags().BoolVar(&usageTimeStamp, "time-stamping", false, "Sign as a time stamping authority certificate (critical time stamping extended key usage)")
 
 	cmd.Flags().BoolVar(&isCA, "new-ca", false, "Sign as a CA certificate")
+	cmd.Flags().IntVar(&pathLen, "path-len", -1, "Maximum path length of the new CA. Defaults to the issuing CA's one minus one, or unlimited")
+	cmd.Flags().StringVar(&subjectKeyId, "subject-key-id", "", "ID of the KMS key of the request, used as Subject Key Id. The request public key must match it")
+	cmd.Flags().BoolVar(&chain, "chain", false, "Output the certificate followed by the issuing CA chain, without the root CA")
+	cmd.Flags().BoolVar(&withRoot, "with-root", false, "Output the certificate followed by the issuing CA chain, including the root CA")
+
+	profile = setProfileFlags(cmd)
+	key = setSignerFlags(cmd)
+	cmd.Flags().StringVar(&policyFile, "csr-policy", "", "Path to a YAML policy enforced on the certificate request")
+	cmd.Flags().StringSliceVar(&overrideSan, "override-san", nil, "Comma separated list of subject alternative names replacing the requested ones (ex: DNS:example.com,IP:10.0.0.1)")
 
 	cmd.MarkFlagsMutuallyExclusive("new-ca", "server-auth")
 	cmd.MarkFlagsMutuallyExclusive("new-ca", "client-auth")
+	cmd.MarkFlagsMutuallyExclusive("ocsp-signing", "new-ca")
+	cmd.MarkFlagsMutuallyExclusive("ocsp-signing", "time-stamping")
+	cmd.MarkFlagsMutuallyExclusive("time-stamping", "new-ca")
+	cmd.MarkFlagsMutuallyExclusive("time-stamping", "server-auth")
+	cmd.MarkFlagsMutuallyExclusive("time-stamping", "client-auth")
+	cmd.MarkFlagsMutuallyExclusive("chain", "with-root")
+	cmd.MarkFlagsMutuallyExclusive("kmip", "subject-key-id")
 
 	return cmd
 }
 
+// csrPolicyHelp documents the CSR policies, for the sign command help.
+const csrPolicyHelp = `
+A YAML CSR policy can be given with --csr-policy. The request is rejected if it does not comply. All fields are optional:
+
+  maxValidity: 2160h                 # Validity cap
+  minRsaKeySize: 3072
+  minEcdsaKeySize: 256
+  requiredSubjectFields: [commonName, organization]  # Also serialNumber, country, organizationalUnit, locality,
+                                                     # province, streetAddress and postalCode
+  allowedDomains: [example.com]      # example.com and its subdomains. Use .example.com for subdomains only.
+                                     # Also applies to a common name which is a host name
+  allowedIPRanges: [10.0.0.0/8]
+  allowedEmailDomains: [example.com]
+  allowedURIDomains: [example.com]
+  unknownExtensions: strip           # Or reject
+  allowedExtensions: [1.2.3.4]       # OIDs of the extensions copied from the request
+
+The subject alternative names are rebuilt from the checked DNS names, email addresses, IP addresses and URIs.
+Other names, like the okms.domain ones, are unknown extensions unless 2.5.29.17 is in allowedExtensions, in
+which case the request extension is copied as is.
+`
+
 func extractCsrSubjectKeyId(csr *x509.CertificateRequest) (uuid.UUID, bool) {
 	for _, ext := range csr.Extensions {
 		if !ext.Id.Equal(OID_CE_SUBJECT_KEY_IDENTIFIER) {
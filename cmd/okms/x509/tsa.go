@@ -0,0 +1,225 @@
+package x509
+
+import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/tsp"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+)
+
+// tsaMaxRequestSize is the maximum accepted size of a time-stamp request body.
+const tsaMaxRequestSize = 64 * 1024
+
+func newTsaCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tsa",
+		Short: "RFC 3161 time stamping authority backed by a KMS key",
+	}
+	cmd.AddCommand(
+		newTsaSignCommand(),
+		newTsaServeCommand(),
+	)
+	return cmd
+}
+
+type tsaParams struct {
+	certFile string
+	policy   string
+	accuracy time.Duration
+}
+
+func setTsaFlags(cmd *cobra.Command) *tsaParams {
+	params := new(tsaParams)
+	cmd.Flags().StringVar(&params.certFile, "tsa-cert", "", "Path to the PEM encoded TSA certificate, optionally followed by its chain")
+	cmd.Flags().StringVar(&params.policy, "policy", "", "TSA policy OID (ex: 1.3.6.1.4.1.99999.1)")
+	cmd.Flags().DurationVar(&params.accuracy, "accuracy", time.Second, "Accuracy of the time source. 0 to leave unspecified")
+	_ = cmd.MarkFlagRequired("tsa-cert")
+	_ = cmd.MarkFlagRequired("policy")
+	return params
+}
+
+// authority loads the TSA certificate and creates a time stamping authority signing with the
+// KMS key identified by the KEY-ID argument at index idx, or by the certificate's subject key id.
+func (params *tsaParams) authority(ctx context.Context, args []string, idx int) *tsp.Authority {
+	certs := exit.OnErr2(x509utils.LoadCertificates(params.certFile))
+	keyId := keyIdFromArgOrCert(ctx, args, idx, certs[0])
+	signer := exit.OnErr2(common.Client().NewSigner(ctx, common.GetOkmsId(), keyId))
+	if !x509utils.PublicKeysEqual(certs[0].PublicKey, signer.Public()) {
+		exit.OnErr(errors.New("The TSA certificate's public key does not match the signing key"))
+	}
+	tsa := &tsp.Authority{
+		Signer:       signer,
+		Certificate:  certs[0],
+		Chain:        certs[1:],
+		Policy:       exit.OnErr2(x509utils.ParseObjectIdentifier(params.policy)),
+		Accuracy:     params.accuracy,
+		SerialNumber: newSerialNumber,
+	}
+	exit.OnErr(tsa.CheckCertificate())
+	return tsa
+}
+
+func newTsaSignCommand() *cobra.Command {
+	var (
+		requestFile string
+		digest      string
+		hashAlg     string
+		nonce       bool
+		noCerts     bool
+		params      *tsaParams
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sign [KEY-ID]",
+		Short: "Create an RFC 3161 time-stamp response",
+		Long: `Create a DER encoded RFC 3161 time-stamp response (TimeStampResp), signed with a key stored in the KMS.
+
+The time-stamped data is either a DER encoded time-stamp request given with --request (like the ones
+created with "openssl ts -query"), or a hex encoded message digest given with --digest.
+
+The certificate given with --tsa-cert must be the TSA certificate, with the time stamping extended key
+usage (see "okms x509 sign --time-stamping"). It can be followed in the same file by its issuers chain.
+
+The KEY-ID parameter can be left empty if the certificate's Subject Key Id matches the key id UUID.`,
+		Example: `  # Time-stamp a file, and verify the response with openssl
+  openssl ts -query -data data.bin -sha256 -cert -out data.tsq
+  okms x509 tsa sign --tsa-cert tsa.pem --policy 1.3.6.1.4.1.99999.1 --request data.tsq > data.tsr
+  openssl ts -verify -in data.tsr -queryfile data.tsq -CAfile ca.pem`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var reqDer []byte
+			if requestFile != "" {
+				reqDer = exit.OnErr2(os.ReadFile(requestFile))
+			} else {
+				hash := exit.OnErr2(parseHashAlgorithm(hashAlg))
+				req := &tsp.Request{
+					HashAlgorithm: hash,
+					HashedMessage: exit.OnErr2(hex.DecodeString(digest)),
+					CertReq:       !noCerts,
+				}
+				if nonce {
+					req.Nonce = newSerialNumber()
+				}
+				reqDer = exit.OnErr2(req.Marshal())
+			}
+
+			resp := exit.OnErr2(params.authority(cmd.Context(), args, 0).Respond(reqDer))
+			parsed := exit.OnErr2(tsp.ParseResponse(resp))
+			if parsed.Status != tsp.StatusGranted {
+				exit.OnErr(fmt.Errorf("Time-stamp request rejected: %s", strings.Join(parsed.StatusString, ", ")))
+			}
+			exit.OnErr2(os.Stdout.Write(resp))
+		},
+	}
+
+	params = setTsaFlags(cmd)
+	cmd.Flags().StringVar(&requestFile, "request", "", "Path to a DER encoded time-stamp request")
+	cmd.Flags().StringVar(&digest, "digest", "", "Hex encoded digest of the data to time-stamp")
+	cmd.Flags().StringVar(&hashAlg, "hash", "sha256", "Hash algorithm of --digest [sha256|sha384|sha512]")
+	cmd.Flags().BoolVar(&nonce, "nonce", false, "Include a random nonce in the response (with --digest)")
+	cmd.Flags().BoolVar(&noCerts, "no-certs", false, "Do not embed the TSA certificates in the response (with --digest)")
+	cmd.MarkFlagsOneRequired("request", "digest")
+	cmd.MarkFlagsMutuallyExclusive("request", "digest")
+	cmd.MarkFlagsMutuallyExclusive("request", "nonce")
+	cmd.MarkFlagsMutuallyExclusive("request", "no-certs")
+
+	return cmd
+}
+
+func newTsaServeCommand() *cobra.Command {
+	var (
+		listen string
+		params *tsaParams
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve [KEY-ID]",
+		Short: "Serve an RFC 3161 time stamping authority over HTTP",
+		Long: `Serve an RFC 3161 time stamping authority over HTTP, signing with a key stored in the KMS.
+
+Time-stamp requests are POSTed with the "application/timestamp-query" content type, and
+time-stamp responses are returned with the "application/timestamp-reply" content type.
+
+The certificate given with --tsa-cert must be the TSA certificate, with the time stamping extended key
+usage (see "okms x509 sign --time-stamping"). It can be followed in the same file by its issuers chain.
+
+The KEY-ID parameter can be left empty if the certificate's Subject Key Id matches the key id UUID.`,
+		Example: `  okms x509 tsa serve --tsa-cert tsa.pem --policy 1.3.6.1.4.1.99999.1 --listen :3161
+  curl -s -H "Content-Type: application/timestamp-query" --data-binary @data.tsq http://localhost:3161 > data.tsr`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tsa := params.authority(cmd.Context(), args, 0)
+			server := &http.Server{
+				Addr:              listen,
+				Handler:           newTsaHandler(tsa),
+				ReadHeaderTimeout: 10 * time.Second,
+				ReadTimeout:       30 * time.Second,
+				WriteTimeout:      30 * time.Second,
+			}
+			fmt.Fprintf(os.Stderr, "Time stamping authority listening on %s\n", listen)
+			exit.OnErr(common.Serve(cmd.Context(), server, server.ListenAndServe))
+		},
+	}
+
+	params = setTsaFlags(cmd)
+	cmd.Flags().StringVar(&listen, "listen", ":3161", "Address to listen on")
+
+	return cmd
+}
+
+func newTsaHandler(tsa *tsp.Authority) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != tsp.CONTENT_TYPE_QUERY {
+			http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		reqDer, err := io.ReadAll(http.MaxBytesReader(w, r.Body, tsaMaxRequestSize))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		resp, err := tsa.Respond(reqDer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to time-stamp request from %s: %s\n", r.RemoteAddr, err)
+			if resp, err = tsp.RejectionResponse(tsp.FailureSystemFailure, ""); err != nil {
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", tsp.CONTENT_TYPE_REPLY)
+		w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+		_, _ = w.Write(resp)
+	})
+}
+
+func parseHashAlgorithm(name string) (crypto.Hash, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "")) {
+	case "sha256":
+		return crypto.SHA256, nil
+	case "sha384":
+		return crypto.SHA384, nil
+	case "sha512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("Unsupported hash algorithm %q", name)
+	}
+}
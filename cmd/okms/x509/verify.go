@@ -0,0 +1,268 @@
+package x509
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/ovh/okms-cli/cmd/okms/common"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/output"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"github.com/spf13/cobra"
+)
+
+// Verification check status values.
+const (
+	checkPassed  = "passed"
+	checkFailed  = "failed"
+	checkSkipped = "skipped"
+)
+
+var verifyUsages = map[string]x509.ExtKeyUsage{
+	"server": x509.ExtKeyUsageServerAuth,
+	"client": x509.ExtKeyUsageClientAuth,
+}
+
+type verifyCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+}
+
+type verifyReport struct {
+	Subject string        `json:"subject"`
+	Chain   []string      `json:"chain,omitempty"`
+	Valid   bool          `json:"valid"`
+	Checks  []verifyCheck `json:"checks"`
+}
+
+func (r *verifyReport) add(name string, err error) {
+	check := verifyCheck{Name: name, Status: checkPassed}
+	if err != nil {
+		check.Status = checkFailed
+		check.Details = err.Error()
+		r.Valid = false
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+func (r *verifyReport) skip(name, reason string) {
+	r.Checks = append(r.Checks, verifyCheck{Name: name, Status: checkSkipped, Details: reason})
+}
+
+func newVerifyCommand() *cobra.Command {
+	var (
+		rootsFile         string
+		systemRoots       bool
+		intermediatesFile string
+		crlFiles          []string
+		usage             string
+		hostname          string
+		caKeyId           string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify CERT",
+		Short: "Verify a certificate chain, its usage and its revocation status",
+		Long: `Verify a certificate chain, its usage and its revocation status.
+
+The chain is built from the certificate up to one of the trusted roots given with --ca-roots, using the
+intermediate certificates given with --intermediates. Each check is reported separately, and the command
+fails if any of them fails:
+  - validity: the certificate validity period
+  - chain: the chain building and signatures, validity periods and name constraints of the chain
+  - usage: the extended key usage of the chain, with --usage
+  - hostname: the certificate subject alternative names, with --hostname
+  - revocation: the revocation status of the chain certificates in the CRLs given with --crl. The CRL of the
+    certificate issuer is required, the ones of the intermediate CAs are checked if given
+  - ca-key: the issuing CA public key matches the KMS key given with --ca-key-id`,
+		Args: cobra.ExactArgs(1),
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// The KMS configuration is only needed to check the CA key
+			if caKeyId != "" {
+				cmd.Parent().PersistentPreRun(cmd, args)
+			} else {
+				exit.OnErr(flagsmgmt.CheckOutputFormat(cmd))
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			leaf := exit.OnErr2(x509utils.LoadCertificates(args[0]))[0]
+			if rootsFile == "" && !systemRoots {
+				exit.OnErr(errors.New("No trusted roots, use --ca-roots or --system-roots"))
+			}
+
+			opts := x509.VerifyOptions{
+				Roots:         x509.NewCertPool(),
+				Intermediates: x509.NewCertPool(),
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			}
+			if systemRoots {
+				opts.Roots = exit.OnErr2(x509.SystemCertPool())
+			}
+			var candidates []*x509.Certificate
+			if rootsFile != "" {
+				roots := exit.OnErr2(x509utils.LoadCertificates(rootsFile))
+				for _, c := range roots {
+					opts.Roots.AddCert(c)
+				}
+				candidates = append(candidates, roots...)
+			}
+			if intermediatesFile != "" {
+				intermediates := exit.OnErr2(x509utils.LoadCertificates(intermediatesFile))
+				for _, c := range intermediates {
+					opts.Intermediates.AddCert(c)
+				}
+				candidates = append(candidates, intermediates...)
+			}
+			var crls []*x509.RevocationList
+			for _, file := range crlFiles {
+				crls = append(crls, exit.OnErr2(x509utils.LoadRevocationLists(file))...)
+			}
+			var extUsage x509.ExtKeyUsage
+			if usage != "" {
+				var ok bool
+				if extUsage, ok = verifyUsages[usage]; !ok {
+					exit.OnErr(fmt.Errorf("Invalid usage %q, must be server or client", usage))
+				}
+			}
+
+			now := time.Now()
+			report := &verifyReport{Subject: leaf.Subject.String(), Valid: true}
+			report.add("validity", checkValidityPeriod(leaf, now))
+
+			var chain []*x509.Certificate
+			chains, err := leaf.Verify(opts)
+			if err == nil {
+				chain = chains[0]
+				for _, c := range chain {
+					report.Chain = append(report.Chain, c.Subject.String())
+				}
+			}
+			report.add("chain", err)
+
+			switch {
+			case usage == "":
+				report.skip("usage", "No usage given")
+			case chain == nil:
+				report.skip("usage", "No valid chain")
+			default:
+				opts.KeyUsages = []x509.ExtKeyUsage{extUsage}
+				_, err := leaf.Verify(opts)
+				report.add("usage", err)
+			}
+
+			if hostname == "" {
+				report.skip("hostname", "No hostname given")
+			} else {
+				report.add("hostname", leaf.VerifyHostname(hostname))
+			}
+
+			issuer := findIssuer(leaf, chain, candidates)
+			switch {
+			case len(crls) == 0:
+				report.skip("revocation", "No CRL given")
+			case issuer == nil:
+				report.skip("revocation", "Issuer certificate not found")
+			default:
+				report.add("revocation", checkChainRevocation(leaf, issuer, chain, crls, now))
+			}
+
+			switch {
+			case caKeyId == "":
+				report.skip("ca-key", "No CA key id given")
+			case issuer == nil:
+				report.add("ca-key", errors.New("Issuer certificate not found"))
+			default:
+				keyId := exit.OnErr2(common.ResolveKeyId(cmd.Context(), caKeyId))
+				pub := exit.OnErr2(common.Client().ExportPublicKey(cmd.Context(), common.GetOkmsId(), keyId))
+				if !x509utils.PublicKeysEqual(issuer.PublicKey, pub) {
+					report.add("ca-key", fmt.Errorf("The public key of %s does not match the KMS key %s", issuer.Subject, keyId))
+				} else {
+					report.add("ca-key", nil)
+				}
+			}
+
+			if cmd.Flag("output").Value.String() == string(flagsmgmt.JSON_OUTPUT_FORMAT) {
+				output.JsonPrint(report)
+			} else {
+				printVerifyReport(report)
+			}
+			if !report.Valid {
+				exit.OnErr(errors.New("Certificate verification failed"))
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&rootsFile, "ca-roots", "", "Path to the trusted root certificates bundle")
+	cmd.Flags().BoolVar(&systemRoots, "system-roots", false, "Trust the system root certificates")
+	cmd.Flags().StringVar(&intermediatesFile, "intermediates", "", "Path to the intermediate certificates bundle")
+	cmd.Flags().StringSliceVar(&crlFiles, "crl", nil, "Path to a CRL file. Can be repeated")
+	cmd.Flags().StringVar(&usage, "usage", "", "Required extended key usage [server|client]")
+	cmd.Flags().StringVar(&hostname, "hostname", "", "Hostname or IP address the certificate must be valid for")
+	cmd.Flags().StringVar(&caKeyId, "ca-key-id", "", "ID of the KMS key the issuing CA public key must match")
+
+	return cmd
+}
+
+func checkValidityPeriod(cert *x509.Certificate, at time.Time) error {
+	if at.Before(cert.NotBefore) {
+		return fmt.Errorf("The certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+	}
+	if at.After(cert.NotAfter) {
+		return fmt.Errorf("The certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// findIssuer returns the issuer of cert in chain if it was built, or among the candidates otherwise.
+// A self-signed certificate is its own issuer.
+func findIssuer(cert *x509.Certificate, chain, candidates []*x509.Certificate) *x509.Certificate {
+	if len(chain) > 1 {
+		return chain[1]
+	}
+	for _, c := range slices.Concat([]*x509.Certificate{cert}, candidates) {
+		if cert.CheckSignatureFrom(c) == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// checkChainRevocation checks the revocation status of cert, which requires a CRL from its issuer,
+// and of the intermediate CAs of chain, for which a CRL is optional.
+func checkChainRevocation(cert, issuer *x509.Certificate, chain []*x509.Certificate, crls []*x509.RevocationList, at time.Time) error {
+	if cert == issuer {
+		return nil
+	}
+	var errs []error
+	if err := x509utils.CheckRevocation(cert, issuer, crls, at); err != nil {
+		errs = append(errs, err)
+	}
+	for i := 1; i < len(chain)-1; i++ {
+		err := x509utils.CheckRevocation(chain[i], chain[i+1], crls, at)
+		if err != nil && !errors.Is(err, x509utils.ErrNoRevocationList) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func printVerifyReport(report *verifyReport) {
+	fmt.Println("Subject:", report.Subject)
+	if len(report.Chain) > 0 {
+		fmt.Println("Chain:  ", strings.Join(report.Chain, " -> "))
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"Check", "Status", "Details"})
+	for _, c := range report.Checks {
+		exit.OnErr(table.Append([]string{c.Name, c.Status, c.Details}))
+	}
+	exit.OnErr(table.Render())
+}
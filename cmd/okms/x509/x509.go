@@ -1,13 +1,26 @@
 package x509
 
 import (
+	"context"
+	"crypto"
 	"crypto/rand"
+	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"errors"
+	"fmt"
 	"math"
 	"math/big"
+	"os"
 
+	"github.com/google/uuid"
 	"github.com/ovh/okms-cli/cmd/okms/common"
+	kmipcmd "github.com/ovh/okms-cli/cmd/okms/kmip"
+	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/utils/cadb"
+	"github.com/ovh/okms-cli/common/utils/certprofile"
+	"github.com/ovh/okms-cli/common/utils/exit"
+	"github.com/ovh/okms-cli/internal/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -18,17 +31,64 @@ var (
 	//
 	// [RFC 5280 Section 4.2.1.2]: https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.2
 	OID_CE_SUBJECT_KEY_IDENTIFIER = asn1.ObjectIdentifier{2, 5, 29, 14}
+
+	// SubjectAltName as defined in [RFC 5280 Section 4.2.1.6].
+	//
+	// [RFC 5280 Section 4.2.1.6]: https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.6
+	OID_CE_SUBJECT_ALT_NAME = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+	// ExtKeyUsage as defined in [RFC 5280 Section 4.2.1.12].
+	//
+	// [RFC 5280 Section 4.2.1.12]: https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.12
+	OID_CE_EXT_KEY_USAGE = asn1.ObjectIdentifier{2, 5, 29, 37}
+	// id-kp-timeStamping extended key usage.
+	OID_KP_TIME_STAMPING = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+	// id-pkix-ocsp-nocheck extension, as defined in [RFC 6960 Section 4.2.2.2.1].
+	//
+	// [RFC 6960 Section 4.2.2.2.1]: https://datatracker.ietf.org/doc/html/rfc6960#section-4.2.2.2.1
+	OID_PKIX_OCSP_NOCHECK = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+	// DeltaCRLIndicator as defined in [RFC 5280 Section 5.2.4].
+	//
+	//   BaseCRLNumber ::= CRLNumber
+	//
+	// [RFC 5280 Section 5.2.4]: https://datatracker.ietf.org/doc/html/rfc5280#section-5.2.4
+	OID_CE_DELTA_CRL_INDICATOR = asn1.ObjectIdentifier{2, 5, 29, 27}
+	// FreshestCRL as defined in [RFC 5280 Section 5.2.6].
+	//
+	// [RFC 5280 Section 5.2.6]: https://datatracker.ietf.org/doc/html/rfc5280#section-5.2.6
+	OID_CE_FRESHEST_CRL = asn1.ObjectIdentifier{2, 5, 29, 46}
 )
 
 func CreateX509Command(cust common.CustomizeFunc) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "x509",
 		Short: "Generate, and sign x509 certificates",
+		Long:  "Generate, and sign x509 certificates.\n\n" + common.KeyRefHelp,
 	}
 	common.SetupRestApiFlags(cmd, cust)
+	restPreRun := cmd.PersistentPreRun
+	cmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		// Commands signing with a KMIP key do not need the REST API configuration
+		if f := cmd.Flags().Lookup("kmip"); f != nil && f.Changed {
+			exit.OnErr(flagsmgmt.CheckOutputFormat(cmd))
+			return
+		}
+		restPreRun(cmd, args)
+	}
+	cmd.PersistentFlags().String("state-dir", "", "Local CA state directory, tracking issued certificates, revocations and CRL numbers (env: KMS_X509_STATE_DIR)")
 	cmd.AddCommand(
 		newCreateCommand(),
 		createSignCsrCommand(),
+		newCmsCommand(),
+		newTsaCommand(),
+		newOcspCommand(),
+		newRevokeCommand(),
+		newListIssuedCommand(),
+		newInspectCommand(),
+		newVerifyCommand(),
+		newEstCommand(),
+		newExportP12Command(),
 	)
 
 	return cmd
@@ -67,3 +127,183 @@ func newSerialNumber() *big.Int {
 	}
 	return serial
 }
+
+// profileHelp documents the certificate profiles, for the issuing commands help.
+const profileHelp = `
+A YAML certificate profile can be given with --cert-profile, named so because --profile selects the configuration
+profile. Its settings override the ones from the command line, including --new-ca and --path-len, which are still checked
+against the issuing CA constraints, and the extended key usages of --time-stamping. All its fields are optional:
+
+  validity: 8760h                    # Default validity, if --validity is not set
+  ca: true                           # Issue a CA certificate
+  pathLen: 0                         # Maximum path length of a CA
+  keyUsage: [digitalSignature]       # digitalSignature, contentCommitment, keyEncipherment, dataEncipherment,
+                                     # keyAgreement, certSign, crlSign, encipherOnly, decipherOnly
+  extKeyUsage: [codeSigning]         # serverAuth, clientAuth, codeSigning, emailProtection, ocspSigning,
+                                     # timeStamping, any, or an OID
+  extKeyUsageCritical: false
+  subjectAltNames:
+    types: [dns, email, ip, uri]     # SAN types kept from the request
+    required: false                  # Require at least one SAN
+    dnsNames: [], emails: [], ips: [], uris: []  # SANs added to every certificate
+  nameConstraints:                   # CA only
+    critical: true
+    permittedDNSDomains: [.example.com]
+    excludedIPRanges: [10.0.0.0/8]   # Also excludedDNSDomains, permittedIPRanges, permitted/excludedEmailAddresses
+                                     # and permitted/excludedURIDomains
+  ocspServers: [http://ocsp.example.com]
+  issuingCertificateURLs: [http://pki.example.com/ca.crt]
+  crlDistributionPoints: [http://pki.example.com/ca.crl]
+  policies: [2.23.140.1.2.1]
+  extensions:                        # Extra extensions, with base64 encoded DER values
+    - oid: 1.2.3.4
+      critical: false
+      value: BQA=`
+
+type profileParams struct {
+	file string
+}
+
+// setProfileFlags adds the --cert-profile flag to cmd.
+func setProfileFlags(cmd *cobra.Command) *profileParams {
+	params := new(profileParams)
+	cmd.Flags().StringVar(&params.file, "cert-profile", "", "Path to a YAML certificate profile (--profile selects the configuration profile)")
+	return params
+}
+
+// load loads the profile if one is set, or returns nil.
+func (params *profileParams) load() (*certprofile.Profile, error) {
+	if params.file == "" {
+		return nil, nil
+	}
+	return certprofile.Load(params.file)
+}
+
+// apply loads the profile if one is set, and applies it to tmpl. The profile's validity
+// is used unless --validity is explicitly set.
+func (params *profileParams) apply(cmd *cobra.Command, tmpl *x509.Certificate) {
+	profile := exit.OnErr2(params.load())
+	exit.OnErr(applyProfile(profile, tmpl, cmd.Flags().Changed("validity")))
+}
+
+// applyProfile applies profile to tmpl if not nil. The profile's validity is used unless validitySet is true.
+func applyProfile(profile *certprofile.Profile, tmpl *x509.Certificate, validitySet bool) error {
+	if profile == nil {
+		return nil
+	}
+	if profile.Validity > 0 && !validitySet {
+		tmpl.NotAfter = tmpl.NotBefore.Add(profile.Validity)
+	}
+	return profile.Apply(tmpl)
+}
+
+// openCaDatabase opens the local CA state directory set with --state-dir or KMS_X509_STATE_DIR,
+// or returns nil if none is set.
+func openCaDatabase(cmd *cobra.Command) *cadb.DB {
+	dir := cmd.Flag("state-dir").Value.String()
+	if dir == "" {
+		dir = os.Getenv("KMS_X509_STATE_DIR")
+	}
+	if dir == "" {
+		return nil
+	}
+	return exit.OnErr2(cadb.Open(exit.OnErr2(utils.ExpandTilde(dir))))
+}
+
+// newUniqueSerialNumber creates a new random serial number. If db is not nil, the serial number is reserved in db,
+// so that no other certificate is issued with it.
+func newUniqueSerialNumber(db *cadb.DB) (*big.Int, error) {
+	if db == nil {
+		return newSerialNumber(), nil
+	}
+	return db.ReserveSerial(newSerialNumber)
+}
+
+// releaseSerialNumber releases a serial number reserved with newUniqueSerialNumber in db, if not nil, when no
+// certificate could be issued with it. A failure is only reported as a warning, since the serial number stays unused.
+func releaseSerialNumber(db *cadb.DB, serial *big.Int) {
+	if db == nil {
+		return
+	}
+	if err := db.ReleaseSerial(serial); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to release the reserved serial number %s: %s\n", serial, err)
+	}
+}
+
+// recordCertificate records the DER encoded issued certificate in db, if not nil.
+func recordCertificate(db *cadb.DB, der []byte) error {
+	if db == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	if err := db.Record(cert); err != nil {
+		return fmt.Errorf("Failed to record the issued certificate: %w", err)
+	}
+	return nil
+}
+
+// keyIdFromArgOrCert resolves the KEY-ID argument at index idx in args if present. Otherwise
+// the key id is taken from the certificate's subject key id, or the program exits if it's not a valid UUID.
+func keyIdFromArgOrCert(ctx context.Context, args []string, idx int, cert *x509.Certificate) uuid.UUID {
+	if len(args) > idx {
+		return exit.OnErr2(common.ResolveKeyId(ctx, args[idx]))
+	}
+	if len(cert.SubjectKeyId) == 0 || len(cert.SubjectKeyId) != 16 {
+		exit.OnErr(errors.New("Cannot use CA's subject key id, please provide the KEY-ID"))
+	}
+	return uuid.UUID(cert.SubjectKeyId)
+}
+
+// signerParams selects the signing key: a KMS key given as KEY-ID, or a KMIP private key given with --kmip.
+type signerParams struct {
+	kmipId string
+}
+
+// setSignerFlags adds the --kmip flag, and the KMIP connection flags, to cmd.
+func setSignerFlags(cmd *cobra.Command) *signerParams {
+	params := new(signerParams)
+	cmd.Flags().StringVar(&params.kmipId, "kmip", "", "Unique identifier of a KMIP private key to sign with, instead of KEY-ID. Its public key must be linked to it")
+	kmipcmd.AddDialFlags(cmd)
+	return params
+}
+
+// signer returns the signer of the KEY-ID argument at index idx in args, or of the KMIP key given with --kmip,
+// and its key identifier. Without KEY-ID, the key id is taken from cert's subject key id if cert is not nil.
+// The key identifier of a KMIP key is its unique identifier if it is a UUID, or nil otherwise.
+func (params *signerParams) signer(cmd *cobra.Command, args []string, idx int, cert *x509.Certificate) (crypto.Signer, []byte) {
+	if params.kmipId != "" {
+		if len(args) > idx {
+			exit.OnErr(errors.New("KEY-ID cannot be used with --kmip"))
+		}
+		signer := exit.OnErr2(kmipcmd.Dial(cmd).Signer(cmd.Context(), params.kmipId, ""))
+		if keyId, err := uuid.Parse(params.kmipId); err == nil {
+			return signer, keyId[:]
+		}
+		return signer, nil
+	}
+	var keyId uuid.UUID
+	if cert != nil {
+		keyId = keyIdFromArgOrCert(cmd.Context(), args, idx, cert)
+	} else if len(args) > idx {
+		keyId = exit.OnErr2(common.ResolveKeyId(cmd.Context(), args[idx]))
+	} else {
+		exit.OnErr(errors.New("Missing KEY-ID parameter, or --kmip flag"))
+	}
+	return exit.OnErr2(common.Client().NewSigner(cmd.Context(), common.GetOkmsId(), keyId)), keyId[:]
+}
+
+// timeStampingExtKeyUsageExtension returns a critical extended key usage extension containing
+// only the time stamping usage, as required by RFC 3161 for TSA certificates.
+func timeStampingExtKeyUsageExtension() pkix.Extension {
+	value := exit.OnErr2(asn1.Marshal([]asn1.ObjectIdentifier{OID_KP_TIME_STAMPING}))
+	return pkix.Extension{Id: OID_CE_EXT_KEY_USAGE, Critical: true, Value: value}
+}
+
+// ocspNoCheckExtension returns the id-pkix-ocsp-nocheck extension, telling clients not to check the
+// revocation status of a delegated OCSP responder certificate.
+func ocspNoCheckExtension() pkix.Extension {
+	return pkix.Extension{Id: OID_PKIX_OCSP_NOCHECK, Value: asn1.NullBytes}
+}
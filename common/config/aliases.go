@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var keyAliasRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func keyAliasesKey(profile string) string {
+	return fmt.Sprintf("profiles.%s.keyAliases", profile)
+}
+
+// KeyAliases returns the key IDs of the key aliases defined in the profile, by alias.
+func KeyAliases(profile string) map[string]string {
+	return k.StringMap(keyAliasesKey(profile))
+}
+
+// KeyAlias returns the key ID of a key alias defined in the profile.
+func KeyAlias(profile, alias string) (string, bool) {
+	key := keyAliasesKey(profile) + "." + alias
+	if !keyAliasRegexp.MatchString(alias) || !k.Exists(key) {
+		return "", false
+	}
+	return k.String(key), true
+}
+
+// SetKeyAlias defines or replaces a key alias in the profile.
+func SetKeyAlias(profile, alias, keyId string) error {
+	if !keyAliasRegexp.MatchString(alias) {
+		return fmt.Errorf("Invalid key alias %q, only letters, digits, '-' and '_' are allowed", alias)
+	}
+	return k.Set(keyAliasesKey(profile)+"."+alias, keyId)
+}
+
+// RemoveKeyAlias removes a key alias from the profile.
+func RemoveKeyAlias(profile, alias string) error {
+	if _, ok := KeyAlias(profile, alias); !ok {
+		return fmt.Errorf("Key alias %q is not defined in profile %q", alias, profile)
+	}
+	k.Delete(keyAliasesKey(profile) + "." + alias)
+	return nil
+}
@@ -103,6 +103,29 @@ func LoadEndpointConfig(command *cobra.Command, service, configFile string) Endp
 	return loadV1(command, service)
 }
 
+// LoadProfileEndpointConfig loads the endpoint configuration of the service from the given profile, instead of the
+// profile selected with --profile. The endpoint flags of the command are ignored, as they apply to the selected
+// profile only.
+func LoadProfileEndpointConfig(service, configFile, profile string) (EndpointConfig, error) {
+	if _, err := LoadFromFile("okms", configFile); err != nil {
+		return EndpointConfig{}, fmt.Errorf("Failed to load config file: %w", err)
+	}
+	if !k.Exists("profiles." + profile) {
+		return EndpointConfig{}, fmt.Errorf("Profile %q is not defined", profile)
+	}
+	return loadProfile(&cobra.Command{}, service, profile), nil
+}
+
+// LoadServiceEndpointConfig loads the endpoint configuration of the service from the profile selected with --profile,
+// for the commands of another service. The endpoint flags of the command are ignored, as they configure the
+// command's own service.
+func LoadServiceEndpointConfig(command *cobra.Command, service, configFile string) (EndpointConfig, error) {
+	if _, err := LoadFromFile("okms", configFile); err != nil {
+		return EndpointConfig{}, fmt.Errorf("Failed to load config file: %w", err)
+	}
+	return loadProfile(&cobra.Command{}, service, SelectedProfile(command)), nil
+}
+
 func ProfileList() []string {
 	list := k.MapKeys("profiles")
 	if len(list) == 0 {
@@ -134,11 +157,20 @@ func loadV1(command *cobra.Command, service string) EndpointConfig {
 		panic("Invalid config version (expect 1)")
 	}
 
+	return loadProfile(command, service, SelectedProfile(command))
+}
+
+// SelectedProfile returns the profile selected with the --profile flag, the KMS_PROFILE environment variable,
+// or in the loaded configuration file.
+func SelectedProfile(command *cobra.Command) string {
 	profile := GetString(k, "profile", "KMS_PROFILE", command.Flags().Lookup("profile"))
 	if profile == "" {
 		profile = "default"
 	}
+	return profile
+}
 
+func loadProfile(command *cobra.Command, service, profile string) EndpointConfig {
 	envPrefix := "KMS_" + strings.ToUpper(service)
 	profilePrefix := fmt.Sprintf("profiles.%s.", profile)
 
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/ovh/okms-cli/common/flagsmgmt"
+	"github.com/ovh/okms-cli/common/utils/exit"
 	"github.com/spf13/cobra"
 )
 
@@ -24,9 +25,10 @@ func SetupEndpointFlags(command *cobra.Command, service string, init func(cmd *c
 	command.PersistentFlags().Var(new(AuthMethodFlag), "auth-method", "Authentication method to use")
 
 	var format = flagsmgmt.TEXT_OUTPUT_FORMAT
-	command.PersistentFlags().Var(&format, "output", "The formatting style for command output.")
+	command.PersistentFlags().Var(&format, "output", "The formatting style for command output. ndjson is only supported by the listings of keys and secrets")
 
 	command.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		exit.OnErr(flagsmgmt.CheckOutputFormat(cmd))
 		configFile, _ := cmd.Flags().GetString("config")
 
 		cfg := LoadEndpointConfig(cmd, service, configFile)
@@ -3,10 +3,8 @@ package config
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/asn1"
 	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/google/uuid"
 	"github.com/knadh/koanf/v2"
@@ -72,46 +70,5 @@ func loadCertPool(caFile string) *x509.CertPool {
 }
 
 func getOkmsId(cert *x509.Certificate) (string, error) {
-	for _, ext := range cert.Extensions { //
-		// See https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.6
-		if !ext.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 17}) {
-			continue
-		}
-		var seq asn1.RawValue
-		_, err := asn1.Unmarshal(ext.Value, &seq)
-		if err != nil {
-			return "", err
-		}
-		for rest := seq.Bytes; len(rest) > 0; {
-			var val asn1.RawValue
-			rest, err = asn1.Unmarshal(rest, &val)
-			if err != nil {
-				return "", err
-			}
-			if val.Tag != 0 {
-				continue
-			}
-
-			var oid asn1.ObjectIdentifier
-			rem, err := asn1.Unmarshal(val.Bytes, &oid)
-			if err != nil {
-				return "", err
-			}
-			if !oid.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}) {
-				continue
-			}
-			if _, err = asn1.Unmarshal(rem, &val); err != nil {
-				return "", err
-			}
-			var othername string
-			if _, err := asn1.Unmarshal(val.Bytes, &othername); err != nil {
-				return "", err
-			}
-			prefix := "okms.domain:"
-			if strings.HasPrefix(othername, prefix) {
-				return othername[len(prefix):], nil
-			}
-		}
-	}
-	return "", errors.New("No OKMS domain id found")
+	return x509utils.OkmsDomainId(cert.Extensions)
 }
@@ -0,0 +1,77 @@
+package config
+
+import "fmt"
+
+// KeyRotationPolicy is the rotation policy of a key, as stored in the profile. The durations are like 90d.
+type KeyRotationPolicy struct {
+	RotateEvery string `json:"rotateEvery"`
+	WarnBefore  string `json:"warnBefore,omitempty"`
+	// Context is the context of the successor keys. Defaults to the key name.
+	Context string `json:"context,omitempty"`
+}
+
+func keyPoliciesKey(profile string) string {
+	return fmt.Sprintf("profiles.%s.keyPolicies", profile)
+}
+
+// KeyRotationPolicies returns the rotation policies defined in the profile, by key ID.
+func KeyRotationPolicies(profile string) map[string]KeyRotationPolicy {
+	prefix := keyPoliciesKey(profile)
+	policies := map[string]KeyRotationPolicy{}
+	for _, keyId := range k.MapKeys(prefix) {
+		key := prefix + "." + keyId
+		policies[keyId] = KeyRotationPolicy{
+			RotateEvery: k.String(key + ".rotateEvery"),
+			WarnBefore:  k.String(key + ".warnBefore"),
+			Context:     k.String(key + ".context"),
+		}
+	}
+	return policies
+}
+
+// SetKeyRotationPolicy defines or replaces the rotation policy of a key in the profile.
+func SetKeyRotationPolicy(profile, keyId string, policy KeyRotationPolicy) error {
+	key := keyPoliciesKey(profile) + "." + keyId
+	k.Delete(key)
+	if err := k.Set(key+".rotateEvery", policy.RotateEvery); err != nil {
+		return err
+	}
+	if policy.WarnBefore != "" {
+		if err := k.Set(key+".warnBefore", policy.WarnBefore); err != nil {
+			return err
+		}
+	}
+	if policy.Context != "" {
+		return k.Set(key+".context", policy.Context)
+	}
+	return nil
+}
+
+// RemoveKeyRotationPolicy removes the rotation policy of a key from the profile.
+func RemoveKeyRotationPolicy(profile, keyId string) error {
+	key := keyPoliciesKey(profile) + "." + keyId
+	if !k.Exists(key) {
+		return fmt.Errorf("No rotation policy is defined for key %s in profile %q", keyId, profile)
+	}
+	k.Delete(key)
+	return nil
+}
+
+func retiredKeysKey(profile string) string {
+	return fmt.Sprintf("profiles.%s.retiredKeys", profile)
+}
+
+// RetiredKeys returns the retirement dates of the keys replaced by a successor, and not deactivated yet, by key ID.
+func RetiredKeys(profile string) map[string]string {
+	return k.StringMap(retiredKeysKey(profile))
+}
+
+// SetRetiredKey records in the profile that a key was retired at the given RFC3339 date.
+func SetRetiredKey(profile, keyId, date string) error {
+	return k.Set(retiredKeysKey(profile)+"."+keyId, date)
+}
+
+// RemoveRetiredKey removes a retired key from the profile, once deactivated.
+func RemoveRetiredKey(profile, keyId string) {
+	k.Delete(retiredKeysKey(profile) + "." + keyId)
+}
@@ -1,28 +1,48 @@
 package flagsmgmt
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
 
 type OutputFormat string
 
 const (
 	JSON_OUTPUT_FORMAT OutputFormat = "json"
 	TEXT_OUTPUT_FORMAT OutputFormat = "text"
+	// NDJSON_OUTPUT_FORMAT prints one JSON object per line. Only the listings annotated with NDJSON_ANNOTATION
+	// support it.
+	NDJSON_OUTPUT_FORMAT OutputFormat = "ndjson"
 )
 
+// NDJSON_ANNOTATION is the annotation of the commands supporting the ndjson output format.
+const NDJSON_ANNOTATION = "ndjson"
+
 func (e *OutputFormat) String() string {
 	return string(*e)
 }
 
 func (e *OutputFormat) Set(v string) error {
 	switch v {
-	case "json", "text":
+	case "json", "text", "ndjson":
 		*e = OutputFormat(v)
 		return nil
 	default:
-		return errors.New(`must be one of "text", "json"`)
+		return errors.New(`must be one of "text", "json", "ndjson"`)
 	}
 }
 
 func (e *OutputFormat) Type() string {
-	return "text|json"
+	return "text|json|ndjson"
+}
+
+// CheckOutputFormat fails if the ndjson output format is selected for a command which does not support it.
+func CheckOutputFormat(cmd *cobra.Command) error {
+	f := cmd.Flags().Lookup("output")
+	if f == nil || f.Value.String() != string(NDJSON_OUTPUT_FORMAT) || cmd.Annotations[NDJSON_ANNOTATION] == "true" {
+		return nil
+	}
+	return fmt.Errorf("The %s output format is not supported by %q", NDJSON_OUTPUT_FORMAT, cmd.CommandPath())
 }
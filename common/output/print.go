@@ -14,3 +14,11 @@ func JsonPrint(resp any) {
 		os.Exit(1)
 	}
 }
+
+// NdjsonPrint prints resp as a single line of JSON, to stream the items of a listing as newline delimited JSON.
+func NdjsonPrint(resp any) {
+	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
+		fmt.Fprintln(os.Stderr, "Fail to marshall response")
+		os.Exit(1)
+	}
+}
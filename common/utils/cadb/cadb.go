@@ -0,0 +1,346 @@
+// Package cadb implements a local certificate authority database, tracking the certificates
+// issued by one or several CAs, their revocations, and the CRL numbers. Like OpenSSL's index.txt,
+// it is stored in a directory, as a JSON index file and a copy of each issued certificate.
+package cadb
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+)
+
+const (
+	indexFile   = "index.json"
+	lockFile    = "index.json.lock"
+	certsDir    = "certs"
+	lockTimeout = 10 * time.Second
+)
+
+// Certificate status values.
+const (
+	StatusValid   = "valid"
+	StatusRevoked = "revoked"
+	StatusExpired = "expired"
+)
+
+// Entry is an issued certificate record.
+type Entry struct {
+	SerialNumber   x509utils.BigInt `json:"serialNumber"`
+	Subject        string           `json:"subject"`
+	Issuer         string           `json:"issuer"`
+	AuthorityKeyId string           `json:"authorityKeyId,omitempty"`
+	NotBefore      time.Time        `json:"notBefore"`
+	NotAfter       time.Time        `json:"notAfter"`
+	IssuedAt       time.Time        `json:"issuedAt"`
+	RevocationDate *time.Time       `json:"revocationDate,omitempty"`
+	ReasonCode     *int             `json:"reasonCode,omitempty"`
+	// File is the path of the PEM certificate, relative to the database directory.
+	File string `json:"file"`
+}
+
+// Status returns the status of the certificate at the given time.
+func (e *Entry) Status(at time.Time) string {
+	switch {
+	case e.RevocationDate != nil:
+		return StatusRevoked
+	case at.After(e.NotAfter):
+		return StatusExpired
+	default:
+		return StatusValid
+	}
+}
+
+// IssuedBy returns whether the certificate was issued by the given CA. The authority key id is used
+// if both the entry and the CA have one. Otherwise the issuer name is compared to the CA's subject.
+func (e *Entry) IssuedBy(ca *x509.Certificate) bool {
+	if e.AuthorityKeyId != "" && len(ca.SubjectKeyId) > 0 {
+		return e.AuthorityKeyId == hex.EncodeToString(ca.SubjectKeyId)
+	}
+	return e.Issuer == ca.Subject.String()
+}
+
+// Index is the content of the database.
+type Index struct {
+	Version      int      `json:"version"`
+	Certificates []*Entry `json:"certificates"`
+	// CrlNumbers are the last CRL numbers issued, by CA.
+	CrlNumbers map[string]x509utils.BigInt `json:"crlNumbers,omitempty"`
+	// ReservedSerials are the serial numbers reserved for certificates being issued, until they are recorded.
+	ReservedSerials []x509utils.BigInt `json:"reservedSerials,omitempty"`
+}
+
+// Find returns the entries with the given serial number.
+func (idx *Index) Find(serial *big.Int) []*Entry {
+	var entries []*Entry
+	for _, e := range idx.Certificates {
+		if e.SerialNumber.Int != nil && e.SerialNumber.Cmp(serial) == 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// isSerialUsed returns true if a certificate with the given serial number is recorded or being issued.
+func (idx *Index) isSerialUsed(serial *big.Int) bool {
+	return len(idx.Find(serial)) > 0 || slices.ContainsFunc(idx.ReservedSerials, func(n x509utils.BigInt) bool {
+		return n.Int != nil && n.Cmp(serial) == 0
+	})
+}
+
+// IssuedBy returns the entries of the certificates issued by the given CA.
+func (idx *Index) IssuedBy(ca *x509.Certificate) []*Entry {
+	var entries []*Entry
+	for _, e := range idx.Certificates {
+		if e.IssuedBy(ca) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// DB is a CA database stored in a local directory.
+type DB struct {
+	dir string
+}
+
+// Open opens the CA database stored in dir, creating the directory if needed.
+func Open(dir string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Join(dir, certsDir), 0o700); err != nil {
+		return nil, fmt.Errorf("Failed to create CA state directory: %w", err)
+	}
+	return &DB{dir: dir}, nil
+}
+
+// Dir returns the directory of the database.
+func (db *DB) Dir() string {
+	return db.dir
+}
+
+// Load reads the database index.
+func (db *DB) Load() (*Index, error) {
+	idx := &Index{Version: 1}
+	data, err := os.ReadFile(filepath.Join(db.dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("Invalid CA index file: %w", err)
+	}
+	if idx.Version != 1 {
+		return nil, fmt.Errorf("Unsupported CA index version %d", idx.Version)
+	}
+	return idx, nil
+}
+
+// Update loads the index, calls fn to modify it, and writes it back if fn succeeds.
+// The database is locked during the whole update.
+func (db *DB) Update(fn func(*Index) error) error {
+	unlock, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	idx, err := db.Load()
+	if err != nil {
+		return err
+	}
+	if err := fn(idx); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(db.dir, indexFile), data)
+}
+
+// IsSerialUsed returns true if a certificate with the given serial number is already recorded or reserved.
+func (db *DB) IsSerialUsed(serial *big.Int) (bool, error) {
+	idx, err := db.Load()
+	if err != nil {
+		return false, err
+	}
+	return idx.isSerialUsed(serial), nil
+}
+
+// ReserveSerial creates serial numbers with newSerial until one is neither recorded nor reserved, and reserves it
+// until the certificate issued with it is recorded. The database is locked meanwhile, so that concurrent issuers
+// cannot get the same serial number.
+func (db *DB) ReserveSerial(newSerial func() *big.Int) (*big.Int, error) {
+	var serial *big.Int
+	err := db.Update(func(idx *Index) error {
+		serial = newSerial()
+		for idx.isSerialUsed(serial) {
+			serial = newSerial()
+		}
+		idx.ReservedSerials = append(idx.ReservedSerials, x509utils.BigInt{Int: serial})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return serial, nil
+}
+
+// ReleaseSerial releases a serial number reserved with [DB.ReserveSerial], when no certificate could be issued with it.
+func (db *DB) ReleaseSerial(serial *big.Int) error {
+	return db.Update(func(idx *Index) error {
+		idx.ReservedSerials = slices.DeleteFunc(idx.ReservedSerials, func(n x509utils.BigInt) bool {
+			return n.Int != nil && n.Cmp(serial) == 0
+		})
+		return nil
+	})
+}
+
+// Record adds an issued certificate to the database.
+func (db *DB) Record(cert *x509.Certificate) error {
+	file := filepath.Join(certsDir, strings.ToUpper(cert.SerialNumber.Text(16))+".pem")
+	return db.Update(func(idx *Index) error {
+		for _, e := range idx.Find(cert.SerialNumber) {
+			if e.Issuer == cert.Issuer.String() && e.AuthorityKeyId == hex.EncodeToString(cert.AuthorityKeyId) {
+				return fmt.Errorf("A certificate with serial number %s is already recorded", cert.SerialNumber)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(db.dir, file)); err == nil {
+			file = filepath.Join(certsDir, fmt.Sprintf("%s-%d.pem", strings.ToUpper(cert.SerialNumber.Text(16)), len(idx.Certificates)))
+		}
+		idx.ReservedSerials = slices.DeleteFunc(idx.ReservedSerials, func(n x509utils.BigInt) bool {
+			return n.Int != nil && n.Cmp(cert.SerialNumber) == 0
+		})
+		pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		if err := os.WriteFile(filepath.Join(db.dir, file), pemData, 0o600); err != nil {
+			return err
+		}
+		idx.Certificates = append(idx.Certificates, &Entry{
+			SerialNumber:   x509utils.BigInt{Int: cert.SerialNumber},
+			Subject:        cert.Subject.String(),
+			Issuer:         cert.Issuer.String(),
+			AuthorityKeyId: hex.EncodeToString(cert.AuthorityKeyId),
+			NotBefore:      cert.NotBefore,
+			NotAfter:       cert.NotAfter,
+			IssuedAt:       time.Now(),
+			File:           file,
+		})
+		return nil
+	})
+}
+
+// Revoke marks the certificate with the given serial number as revoked. If ca is not nil,
+// only the certificates issued by ca are considered. It fails if the certificate is unknown,
+// ambiguous, or already revoked.
+func (db *DB) Revoke(serial *big.Int, ca *x509.Certificate, reason int, at time.Time) (*Entry, error) {
+	var revoked *Entry
+	err := db.Update(func(idx *Index) error {
+		entries := idx.Find(serial)
+		if ca != nil {
+			entries = slices.DeleteFunc(entries, func(e *Entry) bool { return !e.IssuedBy(ca) })
+		}
+		switch len(entries) {
+		case 0:
+			return fmt.Errorf("No certificate with serial number %s found", serial)
+		case 1:
+		default:
+			return fmt.Errorf("Several certificates with serial number %s found, please specify the issuing CA", serial)
+		}
+		if entries[0].RevocationDate != nil {
+			return fmt.Errorf("Certificate with serial number %s is already revoked", serial)
+		}
+		at = at.UTC().Truncate(time.Second)
+		entries[0].RevocationDate = &at
+		entries[0].ReasonCode = &reason
+		revoked = entries[0]
+		return nil
+	})
+	return revoked, err
+}
+
+// NextCrlNumber returns and records the next CRL number of the given CA. If number is not
+// nil, it is used instead, but must be greater than the last recorded one.
+func (db *DB) NextCrlNumber(ca *x509.Certificate, number *big.Int) (*big.Int, error) {
+	key := caKey(ca)
+	err := db.Update(func(idx *Index) error {
+		last := big.NewInt(0)
+		if n, ok := idx.CrlNumbers[key]; ok && n.Int != nil {
+			last = n.Int
+		}
+		if number == nil {
+			number = new(big.Int).Add(last, big.NewInt(1))
+		} else if number.Cmp(last) <= 0 {
+			return fmt.Errorf("CRL number must be greater than the last issued one (%s)", last)
+		}
+		if idx.CrlNumbers == nil {
+			idx.CrlNumbers = make(map[string]x509utils.BigInt)
+		}
+		idx.CrlNumbers[key] = x509utils.BigInt{Int: number}
+		return nil
+	})
+	return number, err
+}
+
+// ReadCertificate reads the certificate of an entry.
+func (db *DB) ReadCertificate(e *Entry) (*x509.Certificate, error) {
+	certs, err := x509utils.LoadCertificates(filepath.Join(db.dir, e.File))
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// caKey returns the key identifying a CA in the index: its subject key id if any, or its subject.
+func caKey(ca *x509.Certificate) string {
+	if len(ca.SubjectKeyId) > 0 {
+		return hex.EncodeToString(ca.SubjectKeyId)
+	}
+	return ca.Subject.String()
+}
+
+// lock takes an exclusive lock on the database, and returns a function to release it.
+func (db *DB) lock() (func(), error) {
+	path := filepath.Join(db.dir, lockFile)
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Failed to lock the CA database, remove %q if no other process is using it", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// writeFileAtomic writes data to a temporary file, then renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,123 @@
+package cadb
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ovh/okms-cli/internal/testcerts"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveSerial(t *testing.T) {
+	db, err := Open(t.TempDir())
+	require.NoError(t, err)
+	ca, caKey := testcerts.NewCA(t, "ca")
+	leaf, _ := testcerts.NewLeaf(t, 100, ca, caKey)
+	require.NoError(t, db.Record(leaf))
+
+	// The recorded and reserved serial numbers are skipped
+	candidates := []int64{100, 101, 101, 102}
+	next := func() *big.Int {
+		n := big.NewInt(candidates[0])
+		candidates = candidates[1:]
+		return n
+	}
+	serial, err := db.ReserveSerial(next)
+	require.NoError(t, err)
+	require.EqualValues(t, 101, serial.Int64())
+	serial, err = db.ReserveSerial(next)
+	require.NoError(t, err)
+	require.EqualValues(t, 102, serial.Int64())
+	used, err := db.IsSerialUsed(big.NewInt(102))
+	require.NoError(t, err)
+	require.True(t, used)
+
+	// Recording the certificate releases its reservation
+	leaf2, _ := testcerts.NewLeaf(t, 101, ca, caKey)
+	require.NoError(t, db.Record(leaf2))
+	idx, err := db.Load()
+	require.NoError(t, err)
+	require.Len(t, idx.ReservedSerials, 1)
+	require.EqualValues(t, 102, idx.ReservedSerials[0].Int64())
+
+	// Releasing a reservation makes the serial number available again
+	require.NoError(t, db.ReleaseSerial(big.NewInt(102)))
+	used, err = db.IsSerialUsed(big.NewInt(102))
+	require.NoError(t, err)
+	require.False(t, used)
+}
+
+func TestRecordAndRevoke(t *testing.T) {
+	db, err := Open(t.TempDir())
+	require.NoError(t, err)
+	ca1, ca1Key := testcerts.NewCA(t, "ca 1")
+	ca2, ca2Key := testcerts.NewCA(t, "ca 2")
+	leaf1, _ := testcerts.NewLeaf(t, 100, ca1, ca1Key)
+	leaf2, _ := testcerts.NewLeaf(t, 100, ca2, ca2Key)
+	leaf3, _ := testcerts.NewLeaf(t, 101, ca1, ca1Key)
+
+	for _, c := range []*x509.Certificate{leaf1, leaf2, leaf3} {
+		require.NoError(t, db.Record(c))
+	}
+	require.Error(t, db.Record(leaf1))
+
+	used, err := db.IsSerialUsed(big.NewInt(100))
+	require.NoError(t, err)
+	require.True(t, used)
+
+	idx, err := db.Load()
+	require.NoError(t, err)
+	require.Len(t, idx.Certificates, 3)
+	require.Len(t, idx.IssuedBy(ca1), 2)
+	require.Len(t, idx.IssuedBy(ca2), 1)
+	cert, err := db.ReadCertificate(idx.Find(big.NewInt(101))[0])
+	require.NoError(t, err)
+	require.Equal(t, leaf3.Raw, cert.Raw)
+
+	// Serial 100 is ambiguous without the CA
+	_, err = db.Revoke(big.NewInt(100), nil, 1, time.Now())
+	require.Error(t, err)
+	entry, err := db.Revoke(big.NewInt(100), ca2, 1, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, StatusRevoked, entry.Status(time.Now()))
+	_, err = db.Revoke(big.NewInt(100), ca2, 1, time.Now())
+	require.Error(t, err)
+	_, err = db.Revoke(big.NewInt(999), nil, 1, time.Now())
+	require.Error(t, err)
+
+	idx, err = db.Load()
+	require.NoError(t, err)
+	for _, e := range idx.IssuedBy(ca1) {
+		require.Equal(t, StatusValid, e.Status(time.Now()))
+		require.Equal(t, StatusExpired, e.Status(time.Now().Add(2*time.Hour)))
+	}
+	require.Equal(t, StatusRevoked, idx.IssuedBy(ca2)[0].Status(time.Now()))
+}
+
+func TestNextCrlNumber(t *testing.T) {
+	db, err := Open(t.TempDir())
+	require.NoError(t, err)
+	ca1, _ := testcerts.NewCA(t, "ca 1")
+	ca2, _ := testcerts.NewCA(t, "ca 2")
+
+	n, err := db.NextCrlNumber(ca1, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n.Int64())
+	n, err = db.NextCrlNumber(ca1, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n.Int64())
+	n, err = db.NextCrlNumber(ca2, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n.Int64())
+
+	_, err = db.NextCrlNumber(ca1, big.NewInt(2))
+	require.Error(t, err)
+	n, err = db.NextCrlNumber(ca1, big.NewInt(10))
+	require.NoError(t, err)
+	require.Equal(t, int64(10), n.Int64())
+	n, err = db.NextCrlNumber(ca1, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(11), n.Int64())
+}
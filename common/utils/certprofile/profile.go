@@ -0,0 +1,425 @@
+// Package certprofile implements certificate issuance profiles loaded from YAML files.
+//
+// A profile declares the key usages, extended key usages, basic constraints, subject alternative
+// names policy, name constraints, authority information access, CRL distribution points, policies
+// and extra extensions of the certificates issued with it.
+package certprofile
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"go.yaml.in/yaml/v3"
+)
+
+var (
+	oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtensionExtKeyUsage    = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+type extKeyUsage struct {
+	usage x509.ExtKeyUsage
+	oid   asn1.ObjectIdentifier
+}
+
+var extKeyUsages = map[string]extKeyUsage{
+	"any":             {x509.ExtKeyUsageAny, asn1.ObjectIdentifier{2, 5, 29, 37, 0}},
+	"serverAuth":      {x509.ExtKeyUsageServerAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}},
+	"clientAuth":      {x509.ExtKeyUsageClientAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}},
+	"codeSigning":     {x509.ExtKeyUsageCodeSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}},
+	"emailProtection": {x509.ExtKeyUsageEmailProtection, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}},
+	"timeStamping":    {x509.ExtKeyUsageTimeStamping, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}},
+	"ocspSigning":     {x509.ExtKeyUsageOCSPSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 9}},
+}
+
+// SAN types, as used in [SubjectAltNames.Types].
+const (
+	SanDNS   = "dns"
+	SanEmail = "email"
+	SanIP    = "ip"
+	SanURI   = "uri"
+)
+
+// Profile is a certificate issuance profile.
+type Profile struct {
+	// Validity is the default validity duration of the certificates.
+	Validity time.Duration `yaml:"validity"`
+	// CA tells whether the certificates are CA certificates. If not set, the issuing command decides.
+	// CA certificates get the certSign, crlSign and digitalSignature key usages unless KeyUsage is set.
+	CA *bool `yaml:"ca"`
+	// PathLen is the maximum path length of CA certificates. Unlimited if not set.
+	PathLen *int `yaml:"pathLen"`
+	// KeyUsage are the key usages names (ex: digitalSignature, certSign).
+	KeyUsage []string `yaml:"keyUsage"`
+	// ExtKeyUsage are the extended key usages names (ex: serverAuth, codeSigning), or OIDs.
+	ExtKeyUsage []string `yaml:"extKeyUsage"`
+	// ExtKeyUsageCritical marks the extended key usage extension as critical.
+	// It is always critical if timeStamping is the only extended key usage, as required by RFC 3161.
+	ExtKeyUsageCritical    bool             `yaml:"extKeyUsageCritical"`
+	SubjectAltNames        SubjectAltNames  `yaml:"subjectAltNames"`
+	NameConstraints        *NameConstraints `yaml:"nameConstraints"`
+	OCSPServers            []string         `yaml:"ocspServers"`
+	IssuingCertificateURLs []string         `yaml:"issuingCertificateURLs"`
+	CRLDistributionPoints  []string         `yaml:"crlDistributionPoints"`
+	// Policies are the certificate policies OIDs.
+	Policies   []string    `yaml:"policies"`
+	Extensions []Extension `yaml:"extensions"`
+}
+
+// SubjectAltNames is the subject alternative names policy of a profile. When it changes the names, the
+// other names copied from the request, like the okms.domain ones, are dropped.
+type SubjectAltNames struct {
+	// Types are the SAN types kept from the request (dns, email, ip, uri). All are kept if not set.
+	Types []string `yaml:"types"`
+	// Required fails the issuance if the certificate has no SAN.
+	Required bool     `yaml:"required"`
+	DNSNames []string `yaml:"dnsNames"`
+	Emails   []string `yaml:"emails"`
+	IPs      []string `yaml:"ips"`
+	URIs     []string `yaml:"uris"`
+}
+
+// NameConstraints are the name constraints of CA certificates.
+type NameConstraints struct {
+	Critical                bool     `yaml:"critical"`
+	PermittedDNSDomains     []string `yaml:"permittedDNSDomains"`
+	ExcludedDNSDomains      []string `yaml:"excludedDNSDomains"`
+	PermittedIPRanges       []string `yaml:"permittedIPRanges"`
+	ExcludedIPRanges        []string `yaml:"excludedIPRanges"`
+	PermittedEmailAddresses []string `yaml:"permittedEmailAddresses"`
+	ExcludedEmailAddresses  []string `yaml:"excludedEmailAddresses"`
+	PermittedURIDomains     []string `yaml:"permittedURIDomains"`
+	ExcludedURIDomains      []string `yaml:"excludedURIDomains"`
+}
+
+// Extension is an extra extension.
+type Extension struct {
+	OID      string `yaml:"oid"`
+	Critical bool   `yaml:"critical"`
+	// Value is the base64 encoded DER value of the extension.
+	Value string `yaml:"value"`
+}
+
+// Load reads and validates a YAML profile file.
+func Load(file string) (*Profile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid profile %q: %w", file, err)
+	}
+	return p, nil
+}
+
+// Parse parses and validates a YAML profile. Unknown fields are rejected.
+func Parse(data []byte) (*Profile, error) {
+	p := new(Profile)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the profile values.
+func (p *Profile) Validate() error {
+	if p.Validity < 0 {
+		return errors.New("Validity must be positive")
+	}
+	if p.PathLen != nil {
+		if *p.PathLen < 0 {
+			return errors.New("Path length must be positive")
+		}
+		if p.CA == nil || !*p.CA {
+			return errors.New("Path length requires ca to be true")
+		}
+	}
+	if p.NameConstraints != nil && (p.CA == nil || !*p.CA) {
+		return errors.New("Name constraints require ca to be true")
+	}
+	if _, err := p.keyUsage(); err != nil {
+		return err
+	}
+	if _, _, err := p.extKeyUsage(); err != nil {
+		return err
+	}
+	for _, t := range p.SubjectAltNames.Types {
+		if !slices.Contains([]string{SanDNS, SanEmail, SanIP, SanURI}, t) {
+			return fmt.Errorf("Invalid subject alternative name type %q", t)
+		}
+	}
+	if _, err := parseIPs(p.SubjectAltNames.IPs); err != nil {
+		return err
+	}
+	if _, err := parseURLs(p.SubjectAltNames.URIs); err != nil {
+		return err
+	}
+	for _, u := range slices.Concat(p.OCSPServers, p.IssuingCertificateURLs, p.CRLDistributionPoints) {
+		if _, err := url.ParseRequestURI(u); err != nil {
+			return fmt.Errorf("Invalid URL %q", u)
+		}
+	}
+	if nc := p.NameConstraints; nc != nil {
+		if _, err := parseIPRanges(slices.Concat(nc.PermittedIPRanges, nc.ExcludedIPRanges)); err != nil {
+			return err
+		}
+	}
+	for _, policy := range p.Policies {
+		if _, err := x509.ParseOID(policy); err != nil {
+			return fmt.Errorf("Invalid policy OID %q", policy)
+		}
+	}
+	for _, ext := range p.Extensions {
+		if _, err := x509utils.ParseObjectIdentifier(ext.OID); err != nil {
+			return err
+		}
+		if value, err := base64.StdEncoding.DecodeString(ext.Value); err != nil || len(value) == 0 {
+			return fmt.Errorf("Invalid value for extension %s, must be base64 encoded DER", ext.OID)
+		}
+	}
+	return nil
+}
+
+// Apply applies the profile to a certificate template, whose fields may have been
+// set beforehand from the command line or the certificate request.
+func (p *Profile) Apply(tmpl *x509.Certificate) error {
+	if p.CA != nil {
+		tmpl.IsCA = *p.CA
+		tmpl.BasicConstraintsValid = true
+	}
+	if p.PathLen != nil {
+		tmpl.MaxPathLen = *p.PathLen
+		tmpl.MaxPathLenZero = *p.PathLen == 0
+	}
+
+	if len(p.KeyUsage) > 0 {
+		tmpl.KeyUsage, _ = p.keyUsage()
+	} else if p.CA != nil && *p.CA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	}
+	if len(p.ExtKeyUsage) > 0 {
+		// The profile's extended key usages replace the ones set by the command line, including an extension
+		// added to mark them critical, which x509.CreateCertificate would use instead of tmpl.ExtKeyUsage
+		tmpl.ExtraExtensions = slices.DeleteFunc(tmpl.ExtraExtensions, func(e pkix.Extension) bool { return e.Id.Equal(oidExtensionExtKeyUsage) })
+		tmpl.ExtKeyUsage, tmpl.UnknownExtKeyUsage, _ = p.extKeyUsage()
+		if p.ExtKeyUsageCritical || (len(tmpl.ExtKeyUsage) == 1 && tmpl.ExtKeyUsage[0] == x509.ExtKeyUsageTimeStamping && len(tmpl.UnknownExtKeyUsage) == 0) {
+			// x509.CreateCertificate always marks the extended key usage extension as non critical
+			ext, err := p.extKeyUsageExtension()
+			if err != nil {
+				return err
+			}
+			tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, ext)
+		}
+	}
+
+	if err := p.applySubjectAltNames(tmpl); err != nil {
+		return err
+	}
+
+	if nc := p.NameConstraints; nc != nil {
+		tmpl.PermittedDNSDomainsCritical = nc.Critical
+		tmpl.PermittedDNSDomains = nc.PermittedDNSDomains
+		tmpl.ExcludedDNSDomains = nc.ExcludedDNSDomains
+		tmpl.PermittedIPRanges, _ = parseIPRanges(nc.PermittedIPRanges)
+		tmpl.ExcludedIPRanges, _ = parseIPRanges(nc.ExcludedIPRanges)
+		tmpl.PermittedEmailAddresses = nc.PermittedEmailAddresses
+		tmpl.ExcludedEmailAddresses = nc.ExcludedEmailAddresses
+		tmpl.PermittedURIDomains = nc.PermittedURIDomains
+		tmpl.ExcludedURIDomains = nc.ExcludedURIDomains
+	}
+
+	tmpl.OCSPServer = append(tmpl.OCSPServer, p.OCSPServers...)
+	tmpl.IssuingCertificateURL = append(tmpl.IssuingCertificateURL, p.IssuingCertificateURLs...)
+	tmpl.CRLDistributionPoints = append(tmpl.CRLDistributionPoints, p.CRLDistributionPoints...)
+	for _, policy := range p.Policies {
+		oid, _ := x509.ParseOID(policy)
+		tmpl.Policies = append(tmpl.Policies, oid)
+	}
+	for _, ext := range p.Extensions {
+		oid, _ := x509utils.ParseObjectIdentifier(ext.OID)
+		value, _ := base64.StdEncoding.DecodeString(ext.Value)
+		tmpl.ExtraExtensions = slices.DeleteFunc(tmpl.ExtraExtensions, func(e pkix.Extension) bool { return e.Id.Equal(oid) })
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, pkix.Extension{Id: oid, Critical: ext.Critical, Value: value})
+	}
+	return nil
+}
+
+func (p *Profile) applySubjectAltNames(tmpl *x509.Certificate) error {
+	san := p.SubjectAltNames
+	if len(san.Types)+len(san.DNSNames)+len(san.Emails)+len(san.IPs)+len(san.URIs) > 0 {
+		// A subject alternative names extension copied from the request would be used instead of the template names
+		tmpl.ExtraExtensions = slices.DeleteFunc(tmpl.ExtraExtensions, func(e pkix.Extension) bool { return e.Id.Equal(oidExtensionSubjectAltName) })
+	}
+	if len(san.Types) > 0 {
+		if !slices.Contains(san.Types, SanDNS) {
+			tmpl.DNSNames = nil
+		}
+		if !slices.Contains(san.Types, SanEmail) {
+			tmpl.EmailAddresses = nil
+		}
+		if !slices.Contains(san.Types, SanIP) {
+			tmpl.IPAddresses = nil
+		}
+		if !slices.Contains(san.Types, SanURI) {
+			tmpl.URIs = nil
+		}
+	}
+	ips, _ := parseIPs(san.IPs)
+	uris, _ := parseURLs(san.URIs)
+	tmpl.DNSNames = append(tmpl.DNSNames, san.DNSNames...)
+	tmpl.EmailAddresses = append(tmpl.EmailAddresses, san.Emails...)
+	tmpl.IPAddresses = append(tmpl.IPAddresses, ips...)
+	tmpl.URIs = append(tmpl.URIs, uris...)
+	if san.Required && len(tmpl.DNSNames)+len(tmpl.EmailAddresses)+len(tmpl.IPAddresses)+len(tmpl.URIs) == 0 {
+		return errors.New("The profile requires at least one subject alternative name")
+	}
+	return nil
+}
+
+func (p *Profile) keyUsage() (x509.KeyUsage, error) {
+	var usage x509.KeyUsage
+	for _, name := range p.KeyUsage {
+		ku, ok := keyUsages[name]
+		if !ok {
+			return 0, fmt.Errorf("Invalid key usage %q", name)
+		}
+		usage |= ku
+	}
+	return usage, nil
+}
+
+func (p *Profile) extKeyUsage() ([]x509.ExtKeyUsage, []asn1.ObjectIdentifier, error) {
+	var (
+		usages  []x509.ExtKeyUsage
+		unknown []asn1.ObjectIdentifier
+	)
+	for _, name := range p.ExtKeyUsage {
+		if eku, ok := extKeyUsages[name]; ok {
+			usages = append(usages, eku.usage)
+			continue
+		}
+		oid, err := x509utils.ParseObjectIdentifier(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid extended key usage %q", name)
+		}
+		unknown = append(unknown, oid)
+	}
+	return usages, unknown, nil
+}
+
+func (p *Profile) extKeyUsageExtension() (pkix.Extension, error) {
+	var oids []asn1.ObjectIdentifier
+	for _, name := range p.ExtKeyUsage {
+		if eku, ok := extKeyUsages[name]; ok {
+			oids = append(oids, eku.oid)
+		} else {
+			oid, _ := x509utils.ParseObjectIdentifier(name)
+			oids = append(oids, oid)
+		}
+	}
+	value, err := asn1.Marshal(oids)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionExtKeyUsage, Critical: true, Value: value}, nil
+}
+
+// KeyUsageNames returns the names of the key usages set in ku, as used in profiles.
+func KeyUsageNames(ku x509.KeyUsage) []string {
+	var names []string
+	for bit := x509.KeyUsageDigitalSignature; bit <= x509.KeyUsageDecipherOnly; bit <<= 1 {
+		if ku&bit == 0 {
+			continue
+		}
+		for name, usage := range keyUsages {
+			if usage == bit {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// ExtKeyUsageNames returns the names of the extended key usages, as used in profiles.
+// The unknown extended key usages are returned as OIDs.
+func ExtKeyUsageNames(usages []x509.ExtKeyUsage, unknown []asn1.ObjectIdentifier) []string {
+	var names []string
+	for _, eku := range usages {
+		name := fmt.Sprintf("extKeyUsage(%d)", eku)
+		for n, u := range extKeyUsages {
+			if u.usage == eku {
+				name = n
+			}
+		}
+		names = append(names, name)
+	}
+	for _, oid := range unknown {
+		names = append(names, oid.String())
+	}
+	return names
+}
+
+func parseIPs(values []string) ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(values))
+	for _, v := range values {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP address %q", v)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func parseURLs(values []string) ([]*url.URL, error) {
+	urls := make([]*url.URL, 0, len(values))
+	for _, v := range values {
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme == "" {
+			return nil, fmt.Errorf("Invalid URI %q", v)
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+func parseIPRanges(values []string) ([]*net.IPNet, error) {
+	ranges := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid IP range %q", v)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
@@ -0,0 +1,166 @@
+package certprofile
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const intermediateProfile = `
+validity: 43800h
+ca: true
+pathLen: 0
+keyUsage: [certSign, crlSign, digitalSignature]
+nameConstraints:
+  critical: true
+  permittedDNSDomains: [.example.com]
+  excludedIPRanges: [10.0.0.0/8]
+ocspServers: [http://ocsp.example.com]
+issuingCertificateURLs: [http://pki.example.com/ca.crt]
+crlDistributionPoints: [http://pki.example.com/ca.crl]
+policies: [2.23.140.1.2.1]
+extensions:
+  - oid: 1.2.3.4
+    value: BQA=
+`
+
+const codeSigningProfile = `
+keyUsage: [digitalSignature]
+extKeyUsage: [codeSigning, 1.3.6.1.4.1.311.10.3.13]
+extKeyUsageCritical: true
+subjectAltNames:
+  types: [email]
+  required: true
+`
+
+func issue(t *testing.T, p *Profile, tmpl *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.SerialNumber = big.NewInt(1)
+	tmpl.Subject = pkix.Name{CommonName: "test"}
+	tmpl.NotBefore = time.Now()
+	tmpl.NotAfter = time.Now().Add(p.Validity)
+	require.NoError(t, p.Apply(tmpl))
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestIntermediateProfile(t *testing.T) {
+	p, err := Parse([]byte(intermediateProfile))
+	require.NoError(t, err)
+	require.Equal(t, 43800*time.Hour, p.Validity)
+
+	cert := issue(t, p, &x509.Certificate{})
+	require.True(t, cert.IsCA)
+	require.True(t, cert.BasicConstraintsValid)
+	require.True(t, cert.MaxPathLenZero)
+	require.Equal(t, x509.KeyUsageCertSign|x509.KeyUsageCRLSign|x509.KeyUsageDigitalSignature, cert.KeyUsage)
+	require.True(t, cert.PermittedDNSDomainsCritical)
+	require.Equal(t, []string{".example.com"}, cert.PermittedDNSDomains)
+	require.Len(t, cert.ExcludedIPRanges, 1)
+	require.Equal(t, []string{"http://ocsp.example.com"}, cert.OCSPServer)
+	require.Equal(t, []string{"http://pki.example.com/ca.crt"}, cert.IssuingCertificateURL)
+	require.Equal(t, []string{"http://pki.example.com/ca.crl"}, cert.CRLDistributionPoints)
+	require.Len(t, cert.Policies, 1)
+	require.Equal(t, "2.23.140.1.2.1", cert.Policies[0].String())
+	found := false
+	for _, ext := range cert.Extensions {
+		if ext.Id.String() == "1.2.3.4" {
+			require.Equal(t, []byte{5, 0}, ext.Value)
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+func TestCodeSigningProfile(t *testing.T) {
+	p, err := Parse([]byte(codeSigningProfile))
+	require.NoError(t, err)
+
+	cert := issue(t, p, &x509.Certificate{DNSNames: []string{"example.com"}, EmailAddresses: []string{"dev@example.com"}})
+	require.False(t, cert.IsCA)
+	require.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, cert.ExtKeyUsage)
+	require.Len(t, cert.UnknownExtKeyUsage, 1)
+	require.Empty(t, cert.DNSNames)
+	require.Equal(t, []string{"dev@example.com"}, cert.EmailAddresses)
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtensionExtKeyUsage) {
+			require.True(t, ext.Critical)
+		}
+	}
+
+	require.Error(t, p.Apply(&x509.Certificate{DNSNames: []string{"example.com"}}))
+
+	// A subject alternative names extension copied from the request is replaced by the profile names
+	value, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("example.com")}})
+	require.NoError(t, err)
+	cert = issue(t, p, &x509.Certificate{
+		DNSNames:        []string{"example.com"},
+		EmailAddresses:  []string{"dev@example.com"},
+		ExtraExtensions: []pkix.Extension{{Id: oidExtensionSubjectAltName, Value: value}},
+	})
+	require.Empty(t, cert.DNSNames)
+	require.Equal(t, []string{"dev@example.com"}, cert.EmailAddresses)
+}
+
+func TestExtKeyUsageOverride(t *testing.T) {
+	p, err := Parse([]byte("extKeyUsage: [codeSigning]"))
+	require.NoError(t, err)
+	// Like the critical time stamping extension added by "x509 sign --time-stamping"
+	value, err := asn1.Marshal([]asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 8}})
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{ExtraExtensions: []pkix.Extension{{Id: oidExtensionExtKeyUsage, Critical: true, Value: value}}}
+
+	cert := issue(t, p, tmpl)
+	require.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, cert.ExtKeyUsage)
+	count := 0
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtensionExtKeyUsage) {
+			count++
+		}
+	}
+	require.Equal(t, 1, count)
+}
+
+func TestInvalidProfiles(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown field":     "foo: bar",
+		"key usage":         "keyUsage: [foo]",
+		"ext key usage":     "extKeyUsage: [foo]",
+		"path len":          "pathLen: 1",
+		"name constraints":  "nameConstraints: {permittedDNSDomains: [example.com]}",
+		"ip range":          "ca: true\nnameConstraints: {permittedIPRanges: [10.0.0.0]}",
+		"san type":          "subjectAltNames: {types: [foo]}",
+		"url":               "ocspServers: [foo]",
+		"policy":            "policies: [foo]",
+		"extension oid":     "extensions: [{oid: foo, value: BQA=}]",
+		"extension value":   "extensions: [{oid: 1.2.3, value: '!!!'}]",
+		"negative validity": "validity: -1h",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(data))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestUsageNames(t *testing.T) {
+	require.Equal(t, []string{"digitalSignature", "certSign", "crlSign"}, KeyUsageNames(x509.KeyUsageCertSign|x509.KeyUsageCRLSign|x509.KeyUsageDigitalSignature))
+	require.Empty(t, KeyUsageNames(0))
+	require.Equal(t, []string{"serverAuth", "extKeyUsage(10)", "1.2.3"}, ExtKeyUsageNames(
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageMicrosoftServerGatedCrypto},
+		[]asn1.ObjectIdentifier{{1, 2, 3}},
+	))
+}
@@ -0,0 +1,494 @@
+// Package cms implements a subset of the Cryptographic Message Syntax (RFC 5652) needed to
+// produce and verify SignedData structures with signers backed by a [crypto.Signer].
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+)
+
+var (
+	OIDData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	OIDSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	OIDAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	OIDAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	OIDAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidDigestSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// Attribute is an additional signed attribute to add in a SignerInfo.
+type Attribute struct {
+	Type asn1.ObjectIdentifier
+	// Value is marshalled with [asn1.Marshal], unless it's already an [asn1.RawValue].
+	Value any
+}
+
+// SignOptions holds the optional parameters of [Sign].
+type SignOptions struct {
+	// ContentType is the type of the signed content. Defaults to [OIDData].
+	ContentType asn1.ObjectIdentifier
+	// Hash is the digest algorithm. Defaults to the hash matching the signer's key.
+	Hash crypto.Hash
+	// Detached, when true, does not embed the signed content in the SignedData.
+	Detached bool
+	// SigningTime is added as a signed attribute when not zero.
+	SigningTime time.Time
+	// Certificates are additional certificates (ie. the issuers chain) to embed in the SignedData.
+	// The signer's certificate is always embedded, unless NoCertificates is true.
+	Certificates []*x509.Certificate
+	// NoCertificates, when true, does not embed any certificate in the SignedData.
+	NoCertificates bool
+	// Attributes are additional signed attributes.
+	Attributes []Attribute
+}
+
+// Sign creates a DER encoded ContentInfo containing a SignedData over content, signed by
+// signer whose certificate is cert.
+func Sign(content []byte, cert *x509.Certificate, signer crypto.Signer, opts SignOptions) ([]byte, error) {
+	if !x509utils.PublicKeysEqual(cert.PublicKey, signer.Public()) {
+		return nil, errors.New("The certificate's public key does not match the signing key")
+	}
+	contentType := opts.ContentType
+	if contentType == nil {
+		contentType = OIDData
+	}
+	hash := opts.Hash
+	if hash == 0 {
+		var err error
+		if hash, err = x509utils.HashForPublicKey(signer.Public()); err != nil {
+			return nil, err
+		}
+	}
+	digestAlg, err := DigestAlgorithmIdentifier(hash)
+	if err != nil {
+		return nil, err
+	}
+	sigAlg, err := signatureAlgorithmIdentifier(signer.Public(), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	attrs := []Attribute{
+		{Type: OIDAttributeContentType, Value: contentType},
+		{Type: OIDAttributeMessageDigest, Value: digest},
+	}
+	if !opts.SigningTime.IsZero() {
+		attrs = append(attrs, Attribute{Type: OIDAttributeSigningTime, Value: opts.SigningTime.UTC()})
+	}
+	attrs = append(attrs, opts.Attributes...)
+	signedAttrs, err := marshalAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	h = hash.New()
+	h.Write(signedAttrs)
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var attrsSet asn1.RawValue
+	if _, err := asn1.Unmarshal(signedAttrs, &attrsSet); err != nil {
+		return nil, err
+	}
+
+	certs := bytes.Clone(cert.Raw)
+	for _, c := range opts.Certificates {
+		if !c.Equal(cert) {
+			certs = append(certs, c.Raw...)
+		}
+	}
+
+	version := 1
+	if !contentType.Equal(OIDData) {
+		version = 3
+	}
+	sd := signedData{
+		Version:          version,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: contentType},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:    digestAlg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrsSet.Bytes},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	}
+	if !opts.Detached {
+		sd.EncapContentInfo.EContent = content
+	}
+	if !opts.NoCertificates {
+		sd.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs}
+	}
+
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	// Raw values are marshalled as is, so the explicit tag must be set here.
+	return asn1.Marshal(contentInfo{
+		ContentType: OIDSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+// CertificatesOnly creates a DER encoded ContentInfo containing a degenerate SignedData, without
+// content nor signers, used to convey certificates (RFC 5652 section 5.2, "certs-only" in S/MIME).
+func CertificatesOnly(certs []*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	inner, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: OIDData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      []signerInfo{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: OIDSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+// SignedData is a parsed CMS SignedData.
+type SignedData struct {
+	// ContentType is the type of the encapsulated content.
+	ContentType asn1.ObjectIdentifier
+	// Content is the encapsulated content, or nil if the signature is detached.
+	Content []byte
+	// Certificates are the certificates embedded in the SignedData.
+	Certificates []*x509.Certificate
+	// Signers are the parsed signer infos.
+	Signers []*SignerInfo
+}
+
+// SignerInfo is a parsed CMS SignerInfo.
+type SignerInfo struct {
+	// Certificate is the signer's certificate, or nil if not found in the SignedData.
+	Certificate *x509.Certificate
+	// SigningTime is the value of the signing time attribute if present.
+	SigningTime *time.Time
+	// Hash is the digest algorithm used by the signer.
+	Hash crypto.Hash
+
+	raw signerInfo
+	// signedAttrs is the DER encoding of the signed attributes as a SET.
+	signedAttrs []byte
+	attributes  []attribute
+}
+
+// Parse parses a DER encoded ContentInfo containing a SignedData.
+func Parse(der []byte) (*SignedData, error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("Trailing data after CMS content info")
+	}
+	if !ci.ContentType.Equal(OIDSignedData) {
+		return nil, fmt.Errorf("Unsupported CMS content type %s", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+
+	result := &SignedData{
+		ContentType: sd.EncapContentInfo.EContentType,
+		Content:     sd.EncapContentInfo.EContent,
+	}
+	if len(sd.Certificates.Bytes) > 0 {
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		result.Certificates = certs
+	}
+
+	for _, si := range sd.SignerInfos {
+		signer := &SignerInfo{raw: si}
+		hash, err := HashFromDigestAlgorithm(si.DigestAlgorithm.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		signer.Hash = hash
+		if len(si.SignedAttrs.Bytes) > 0 {
+			signer.signedAttrs, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: si.SignedAttrs.Bytes})
+			if err != nil {
+				return nil, err
+			}
+			if _, err := asn1.UnmarshalWithParams(signer.signedAttrs, &signer.attributes, "set"); err != nil {
+				return nil, err
+			}
+			var signingTime time.Time
+			if found, err := signer.Attribute(OIDAttributeSigningTime, &signingTime); err != nil {
+				return nil, err
+			} else if found {
+				signer.SigningTime = &signingTime
+			}
+		}
+		result.Signers = append(result.Signers, signer)
+	}
+	result.matchSignersCertificates()
+	return result, nil
+}
+
+// AddCertificates adds certificates not embedded in the SignedData, which can be used to
+// find the signers certificates.
+func (sd *SignedData) AddCertificates(certs ...*x509.Certificate) {
+	sd.Certificates = append(sd.Certificates, certs...)
+	sd.matchSignersCertificates()
+}
+
+func (sd *SignedData) matchSignersCertificates() {
+	for _, signer := range sd.Signers {
+		if signer.Certificate != nil {
+			continue
+		}
+		sid := signer.raw.SID
+		for _, cert := range sd.Certificates {
+			if bytes.Equal(cert.RawIssuer, sid.Issuer.FullBytes) && cert.SerialNumber.Cmp(sid.SerialNumber) == 0 {
+				signer.Certificate = cert
+				break
+			}
+		}
+	}
+}
+
+// Attribute unmarshals into out the first value of the signed attribute of type oid.
+// It returns false if the attribute is not present.
+func (si *SignerInfo) Attribute(oid asn1.ObjectIdentifier, out any) (bool, error) {
+	for _, attr := range si.attributes {
+		if !attr.Type.Equal(oid) || len(attr.Values) == 0 {
+			continue
+		}
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, out); err != nil {
+			return true, fmt.Errorf("Invalid attribute %s: %w", oid, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Verify checks the signatures of all the signers. detachedContent must be provided
+// if the signed content is not embedded in the SignedData, otherwise it's ignored.
+//
+// Verify does not validate the signers' certificates chains, this must be done by the caller.
+func (sd *SignedData) Verify(detachedContent []byte) error {
+	content := sd.Content
+	if content == nil {
+		if detachedContent == nil {
+			return errors.New("Missing detached content")
+		}
+		content = detachedContent
+	}
+	if len(sd.Signers) == 0 {
+		return errors.New("No signer found")
+	}
+	for _, signer := range sd.Signers {
+		if err := signer.verify(sd.ContentType, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (si *SignerInfo) verify(contentType asn1.ObjectIdentifier, content []byte) error {
+	if si.Certificate == nil {
+		return errors.New("Signer's certificate not found")
+	}
+	sigAlg, err := x509SignatureAlgorithm(si.raw.SignatureAlgorithm.Algorithm, si.Hash)
+	if err != nil {
+		return err
+	}
+	h := si.Hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	signed := content
+	if si.signedAttrs != nil {
+		var msgDigest []byte
+		if found, err := si.Attribute(OIDAttributeMessageDigest, &msgDigest); err != nil {
+			return err
+		} else if !found {
+			return errors.New("Missing message digest attribute")
+		}
+		if !bytes.Equal(msgDigest, digest) {
+			return errors.New("Message digest mismatch")
+		}
+		var ct asn1.ObjectIdentifier
+		if found, err := si.Attribute(OIDAttributeContentType, &ct); err != nil {
+			return err
+		} else if !found || !ct.Equal(contentType) {
+			return errors.New("Content type attribute mismatch")
+		}
+		signed = si.signedAttrs
+	}
+	if err := si.Certificate.CheckSignature(sigAlg, signed, si.raw.Signature); err != nil {
+		return fmt.Errorf("Invalid signature: %w", err)
+	}
+	return nil
+}
+
+func marshalAttributes(attrs []Attribute) ([]byte, error) {
+	rawAttrs := make([]attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		value, ok := attr.Value.(asn1.RawValue)
+		if !ok {
+			der, err := asn1.Marshal(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to marshal attribute %s: %w", attr.Type, err)
+			}
+			value = asn1.RawValue{FullBytes: der}
+		}
+		rawAttrs = append(rawAttrs, attribute{Type: attr.Type, Values: []asn1.RawValue{value}})
+	}
+	return asn1.MarshalWithParams(rawAttrs, "set")
+}
+
+// DigestAlgorithmIdentifier returns the ASN.1 algorithm identifier of the given hash function.
+func DigestAlgorithmIdentifier(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	switch hash {
+	case crypto.SHA256:
+		return pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256}, nil
+	case crypto.SHA384:
+		return pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA384}, nil
+	case crypto.SHA512:
+		return pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA512}, nil
+	default:
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("Unsupported hash algorithm %s", hash)
+	}
+}
+
+// HashFromDigestAlgorithm returns the hash function identified by the given digest algorithm OID.
+func HashFromDigestAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("Unsupported digest algorithm %s", oid)
+	}
+}
+
+func signatureAlgorithmIdentifier(pub crypto.PublicKey, hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	alg, err := x509utils.SignatureAlgorithmFor(pub, hash)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	switch alg {
+	case x509.SHA256WithRSA:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue}, nil
+	case x509.SHA384WithRSA:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA384WithRSA, Parameters: asn1.NullRawValue}, nil
+	case x509.SHA512WithRSA:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA512WithRSA, Parameters: asn1.NullRawValue}, nil
+	case x509.ECDSAWithSHA256:
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	case x509.ECDSAWithSHA384:
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA384}, nil
+	case x509.ECDSAWithSHA512:
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA512}, nil
+	}
+	return pkix.AlgorithmIdentifier{}, fmt.Errorf("Unsupported signature algorithm %s", alg)
+}
+
+func x509SignatureAlgorithm(oid asn1.ObjectIdentifier, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+	switch {
+	case oid.Equal(oidRSAEncryption), oid.Equal(oidSHA256WithRSA), oid.Equal(oidSHA384WithRSA), oid.Equal(oidSHA512WithRSA):
+		switch hash {
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case oid.Equal(oidECDSAWithSHA256):
+		return x509.ECDSAWithSHA256, nil
+	case oid.Equal(oidECDSAWithSHA384):
+		return x509.ECDSAWithSHA384, nil
+	case oid.Equal(oidECDSAWithSHA512):
+		return x509.ECDSAWithSHA512, nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("Unsupported signature algorithm %s", oid)
+}
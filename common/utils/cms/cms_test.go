@@ -0,0 +1,77 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/ovh/okms-cli/internal/testcerts"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	content := []byte("hello world !!!")
+	for name, signer := range map[string]crypto.Signer{"ecdsa": ecKey, "rsa": rsaKey} {
+		for _, detached := range []bool{true, false} {
+			t.Run(name, func(t *testing.T) {
+				cert := testcerts.Issue(t, &x509.Certificate{}, signer.Public(), nil, signer)
+				signingTime := time.Now().Truncate(time.Second)
+				der, err := Sign(content, cert, signer, SignOptions{Detached: detached, SigningTime: signingTime})
+				require.NoError(t, err)
+
+				sd, err := Parse(der)
+				require.NoError(t, err)
+				require.True(t, sd.ContentType.Equal(OIDData))
+				require.Len(t, sd.Signers, 1)
+				require.Equal(t, cert, sd.Signers[0].Certificate)
+				require.NotNil(t, sd.Signers[0].SigningTime)
+				require.True(t, signingTime.Equal(*sd.Signers[0].SigningTime))
+
+				if detached {
+					require.Nil(t, sd.Content)
+					require.Error(t, sd.Verify(nil))
+					require.NoError(t, sd.Verify(content))
+				} else {
+					require.Equal(t, content, sd.Content)
+					require.NoError(t, sd.Verify(nil))
+				}
+				sd.Content = nil
+				require.Error(t, sd.Verify([]byte("tampered")))
+			})
+		}
+	}
+}
+
+func TestSignMismatchingCertificate(t *testing.T) {
+	key1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = Sign([]byte("data"), testcerts.Issue(t, &x509.Certificate{}, key1.Public(), nil, key1), key2, SignOptions{})
+	require.Error(t, err)
+}
+
+func TestCertificatesOnly(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	certs := []*x509.Certificate{testcerts.Issue(t, &x509.Certificate{}, key.Public(), nil, key), testcerts.Issue(t, &x509.Certificate{}, key.Public(), nil, key)}
+
+	der, err := CertificatesOnly(certs)
+	require.NoError(t, err)
+	sd, err := Parse(der)
+	require.NoError(t, err)
+	require.True(t, sd.ContentType.Equal(OIDData))
+	require.Nil(t, sd.Content)
+	require.Empty(t, sd.Signers)
+	require.Equal(t, certs, sd.Certificates)
+}
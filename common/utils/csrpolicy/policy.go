@@ -0,0 +1,382 @@
+// Package csrpolicy implements the policies enforced on certificate signing requests before
+// a CA signs them: allowed subject alternative names, required subject fields, minimum key
+// sizes, maximum validity, and the extensions copied from the request.
+package csrpolicy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ovh/okms-cli/common/utils/x509utils"
+	"go.yaml.in/yaml/v3"
+)
+
+// Actions on unknown extensions, as used in [Policy.UnknownExtensions].
+const (
+	ExtensionsStrip  = "strip"
+	ExtensionsReject = "reject"
+)
+
+var (
+	oidExtensionSubjectKeyId      = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidExtensionKeyUsage          = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionSubjectAltName    = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtensionBasicConstraints  = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtensionExtendedKeyUsage  = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidExtensionNameConstraints   = asn1.ObjectIdentifier{2, 5, 29, 30}
+	oidExtensionCertificatePolicy = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidExtensionCRLDistribution   = asn1.ObjectIdentifier{2, 5, 29, 31}
+	oidExtensionAuthorityInfo     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}
+)
+
+// issuerExtensions are the extensions decided by the issuer. They are always ignored when found in a request.
+var issuerExtensions = []asn1.ObjectIdentifier{
+	oidExtensionKeyUsage,
+	oidExtensionBasicConstraints,
+	oidExtensionExtendedKeyUsage,
+	oidExtensionNameConstraints,
+	oidExtensionCertificatePolicy,
+	oidExtensionCRLDistribution,
+	oidExtensionAuthorityInfo,
+}
+
+// Tags of the subject alternative name types parsed by x509.ParseCertificateRequest.
+const (
+	nameTypeEmail = 1
+	nameTypeDNS   = 2
+	nameTypeURI   = 6
+	nameTypeIP    = 7
+)
+
+var subjectFields = map[string]func(*pkix.Name) bool{
+	"commonName":         func(n *pkix.Name) bool { return n.CommonName != "" },
+	"serialNumber":       func(n *pkix.Name) bool { return n.SerialNumber != "" },
+	"country":            func(n *pkix.Name) bool { return len(n.Country) > 0 },
+	"organization":       func(n *pkix.Name) bool { return len(n.Organization) > 0 },
+	"organizationalUnit": func(n *pkix.Name) bool { return len(n.OrganizationalUnit) > 0 },
+	"locality":           func(n *pkix.Name) bool { return len(n.Locality) > 0 },
+	"province":           func(n *pkix.Name) bool { return len(n.Province) > 0 },
+	"streetAddress":      func(n *pkix.Name) bool { return len(n.StreetAddress) > 0 },
+	"postalCode":         func(n *pkix.Name) bool { return len(n.PostalCode) > 0 },
+}
+
+// Policy is a certificate signing request policy. The zero value accepts any request,
+// but strips the unknown extensions.
+type Policy struct {
+	// MaxValidity caps the validity of the issued certificates. Not capped if zero.
+	MaxValidity time.Duration `yaml:"maxValidity"`
+	// MinRSAKeySize is the minimum size in bits of RSA keys.
+	MinRSAKeySize int `yaml:"minRsaKeySize"`
+	// MinECDSAKeySize is the minimum size in bits of the ECDSA keys curve.
+	MinECDSAKeySize int `yaml:"minEcdsaKeySize"`
+	// RequiredSubjectFields are the subject fields the request must have (ex: commonName, organization).
+	RequiredSubjectFields []string `yaml:"requiredSubjectFields"`
+	// AllowedDomains restricts the DNS names, and the subject common name when it is a host name.
+	// "example.com" allows the domain and its subdomains, ".example.com" only its subdomains.
+	// Any DNS name is allowed if not set.
+	AllowedDomains []string `yaml:"allowedDomains"`
+	// AllowedIPRanges restricts the IP addresses, in CIDR notation. Any IP address is allowed if not set.
+	AllowedIPRanges []string `yaml:"allowedIPRanges"`
+	// AllowedEmailDomains restricts the domains of the email addresses, like AllowedDomains.
+	AllowedEmailDomains []string `yaml:"allowedEmailDomains"`
+	// AllowedURIDomains restricts the hosts of the URIs, like AllowedDomains.
+	AllowedURIDomains []string `yaml:"allowedURIDomains"`
+	// UnknownExtensions is the action on the request extensions which are not allowed: strip (the default) or reject.
+	UnknownExtensions string `yaml:"unknownExtensions"`
+	// AllowedExtensions are the OIDs of the extensions copied from the request. The subject key identifier
+	// is always allowed. Extensions decided by the issuer, like key usages or basic constraints, are always ignored.
+	// The subject alternative names (2.5.29.17) are rebuilt from the checked names unless allowed here, in which
+	// case the request extension is copied as is, with its other names like the okms.domain ones.
+	AllowedExtensions []string `yaml:"allowedExtensions"`
+}
+
+// Load reads and validates a YAML policy file.
+func Load(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSR policy %q: %w", file, err)
+	}
+	return p, nil
+}
+
+// Parse parses and validates a YAML policy. Unknown fields are rejected.
+func Parse(data []byte) (*Policy, error) {
+	p := new(Policy)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the policy values.
+func (p *Policy) Validate() error {
+	if p.MaxValidity < 0 {
+		return errors.New("Maximum validity must be positive")
+	}
+	if p.MinRSAKeySize < 0 || p.MinECDSAKeySize < 0 {
+		return errors.New("Minimum key sizes must be positive")
+	}
+	for _, field := range p.RequiredSubjectFields {
+		if _, ok := subjectFields[field]; !ok {
+			return fmt.Errorf("Invalid subject field %q", field)
+		}
+	}
+	if _, err := parseIPRanges(p.AllowedIPRanges); err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"", ExtensionsStrip, ExtensionsReject}, p.UnknownExtensions) {
+		return fmt.Errorf("Invalid unknown extensions action %q, must be %s or %s", p.UnknownExtensions, ExtensionsStrip, ExtensionsReject)
+	}
+	for _, oid := range p.AllowedExtensions {
+		if _, err := x509utils.ParseObjectIdentifier(oid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check checks the request key, subject and subject alternative names against the policy.
+// All the violations are reported in the returned error.
+func (p *Policy) Check(csr *x509.CertificateRequest) error {
+	var errs []error
+	if err := p.checkPublicKey(csr.PublicKey); err != nil {
+		errs = append(errs, err)
+	}
+	for _, field := range p.RequiredSubjectFields {
+		if !subjectFields[field](&csr.Subject) {
+			errs = append(errs, fmt.Errorf("Subject field %s is required", field))
+		}
+	}
+	if cn := csr.Subject.CommonName; isHostname(cn) && !matchDomains(cn, p.AllowedDomains) {
+		errs = append(errs, fmt.Errorf("Common name %q is not allowed", cn))
+	}
+	errs = append(errs, p.CheckSubjectAltNames(&SubjectAltNames{
+		DNSNames:       csr.DNSNames,
+		EmailAddresses: csr.EmailAddresses,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           csr.URIs,
+	}))
+	return errors.Join(errs...)
+}
+
+// CheckSubjectAltNames checks subject alternative names against the allowed domains and IP ranges.
+func (p *Policy) CheckSubjectAltNames(san *SubjectAltNames) error {
+	var errs []error
+	for _, name := range san.DNSNames {
+		if !matchDomains(name, p.AllowedDomains) {
+			errs = append(errs, fmt.Errorf("DNS name %q is not allowed", name))
+		}
+	}
+	for _, email := range san.EmailAddresses {
+		_, domain, _ := strings.Cut(email, "@")
+		if !matchDomains(domain, p.AllowedEmailDomains) {
+			errs = append(errs, fmt.Errorf("Email address %q is not allowed", email))
+		}
+	}
+	ranges, _ := parseIPRanges(p.AllowedIPRanges)
+	for _, ip := range san.IPAddresses {
+		if len(ranges) > 0 && !slices.ContainsFunc(ranges, func(r *net.IPNet) bool { return r.Contains(ip) }) {
+			errs = append(errs, fmt.Errorf("IP address %s is not allowed", ip))
+		}
+	}
+	for _, uri := range san.URIs {
+		if !matchDomains(uri.Hostname(), p.AllowedURIDomains) {
+			errs = append(errs, fmt.Errorf("URI %q is not allowed", uri))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Extensions returns the request extensions to copy in the certificate, and the OIDs of the stripped ones.
+// It fails if an extension is not allowed and the policy rejects unknown extensions.
+func (p *Policy) Extensions(csr *x509.CertificateRequest) ([]pkix.Extension, []asn1.ObjectIdentifier, error) {
+	var (
+		exts     []pkix.Extension
+		stripped []asn1.ObjectIdentifier
+	)
+	for _, ext := range csr.Extensions {
+		switch {
+		case slices.ContainsFunc(issuerExtensions, ext.Id.Equal):
+		case ext.Id.Equal(oidExtensionSubjectAltName) && !p.allowsExtension(ext.Id):
+			// Rebuilt by the issuer from the parsed names, which drops the other names
+			if !hasOtherNames(ext) {
+				continue
+			}
+			if p.UnknownExtensions == ExtensionsReject {
+				return nil, nil, errors.New("Subject alternative names other than DNS names, email addresses, IP addresses and URIs are not allowed")
+			}
+			stripped = append(stripped, ext.Id)
+		case ext.Id.Equal(oidExtensionSubjectKeyId) || p.allowsExtension(ext.Id):
+			exts = append(exts, ext)
+		case p.UnknownExtensions == ExtensionsReject:
+			return nil, nil, fmt.Errorf("Extension %s is not allowed", ext.Id)
+		default:
+			stripped = append(stripped, ext.Id)
+		}
+	}
+	return exts, stripped, nil
+}
+
+// CapValidity reduces the validity of tmpl to the policy's maximum, and returns whether it was capped.
+func (p *Policy) CapValidity(tmpl *x509.Certificate) bool {
+	if p.MaxValidity == 0 || tmpl.NotAfter.Sub(tmpl.NotBefore) <= p.MaxValidity {
+		return false
+	}
+	tmpl.NotAfter = tmpl.NotBefore.Add(p.MaxValidity)
+	return true
+}
+
+func (p *Policy) checkPublicKey(pub any) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if size := pub.N.BitLen(); size < p.MinRSAKeySize {
+			return fmt.Errorf("RSA key size %d is lower than the minimum %d", size, p.MinRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		if size := pub.Curve.Params().BitSize; size < p.MinECDSAKeySize {
+			return fmt.Errorf("ECDSA key size %d is lower than the minimum %d", size, p.MinECDSAKeySize)
+		}
+	case ed25519.PublicKey:
+	default:
+		return fmt.Errorf("Unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+func (p *Policy) allowsExtension(oid asn1.ObjectIdentifier) bool {
+	return slices.ContainsFunc(p.AllowedExtensions, func(s string) bool {
+		allowed, _ := x509utils.ParseObjectIdentifier(s)
+		return allowed.Equal(oid)
+	})
+}
+
+// hasOtherNames returns whether a subject alternative name extension has names x509.ParseCertificateRequest
+// does not parse, like otherName or directoryName.
+func hasOtherNames(ext pkix.Extension) bool {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(ext.Value, &seq); err != nil {
+		return true
+	}
+	for rest := seq.Bytes; len(rest) > 0; {
+		var name asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+			return true
+		}
+		if name.Class != asn1.ClassContextSpecific || !slices.Contains([]int{nameTypeEmail, nameTypeDNS, nameTypeURI, nameTypeIP}, name.Tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHostname returns whether a subject common name looks like a host name, like www.example.com or *.example.com.
+func isHostname(cn string) bool {
+	if !strings.Contains(cn, ".") {
+		return false
+	}
+	return !strings.ContainsFunc(cn, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-.*_", r))
+	})
+}
+
+// matchDomains returns true if name matches one of the domains, or if domains is empty.
+func matchDomains(name string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "*"))
+		if strings.HasPrefix(domain, ".") {
+			if strings.HasSuffix(name, domain) {
+				return true
+			}
+		} else if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIPRanges(values []string) ([]*net.IPNet, error) {
+	ranges := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid IP range %q", v)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// SubjectAltNames are the subject alternative names of a certificate.
+type SubjectAltNames struct {
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+}
+
+// Override replaces the subject alternative names of a request, including its other names.
+func (san *SubjectAltNames) Override(csr *x509.CertificateRequest) {
+	csr.DNSNames, csr.EmailAddresses, csr.IPAddresses, csr.URIs = san.DNSNames, san.EmailAddresses, san.IPAddresses, san.URIs
+	csr.Extensions = slices.DeleteFunc(slices.Clone(csr.Extensions), func(ext pkix.Extension) bool {
+		return ext.Id.Equal(oidExtensionSubjectAltName)
+	})
+}
+
+// ParseSubjectAltNames parses subject alternative names in the OpenSSL format: DNS:name, email:address, IP:address or URI:uri.
+func ParseSubjectAltNames(values []string) (*SubjectAltNames, error) {
+	san := new(SubjectAltNames)
+	for _, v := range values {
+		typ, value, ok := strings.Cut(v, ":")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("Invalid subject alternative name %q, must be prefixed by DNS:, email:, IP: or URI:", v)
+		}
+		switch strings.ToLower(typ) {
+		case "dns":
+			san.DNSNames = append(san.DNSNames, value)
+		case "email":
+			san.EmailAddresses = append(san.EmailAddresses, value)
+		case "ip":
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address %q", value)
+			}
+			san.IPAddresses = append(san.IPAddresses, ip)
+		case "uri":
+			u, err := url.Parse(value)
+			if err != nil || u.Scheme == "" {
+				return nil, fmt.Errorf("Invalid URI %q", value)
+			}
+			san.URIs = append(san.URIs, u)
+		default:
+			return nil, fmt.Errorf("Invalid subject alternative name type %q", typ)
+		}
+	}
+	return san, nil
+}
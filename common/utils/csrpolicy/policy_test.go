@@ -0,0 +1,200 @@
+package csrpolicy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `
+maxValidity: 2160h
+minRsaKeySize: 3072
+minEcdsaKeySize: 256
+requiredSubjectFields: [commonName, organization]
+allowedDomains: [example.com, .internal.net]
+allowedIPRanges: [10.0.0.0/8]
+allowedEmailDomains: [example.com]
+unknownExtensions: reject
+allowedExtensions: [1.2.3.4]
+`
+
+func newRequest(t *testing.T, key any, tmpl *x509.CertificateRequest) *x509.CertificateRequest {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	require.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	require.NoError(t, err)
+	return csr
+}
+
+func TestCheck(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	csr := newRequest(t, ecKey, &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "test", Organization: []string{"OVH"}},
+		DNSNames:       []string{"example.com", "www.example.com", "*.example.com", "host.internal.net"},
+		IPAddresses:    []net.IP{net.ParseIP("10.1.2.3")},
+		EmailAddresses: []string{"dev@example.com"},
+	})
+	require.NoError(t, p.Check(csr))
+
+	csr = newRequest(t, ecKey, &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "test"},
+		DNSNames:       []string{"example.com.evil.org", "internal.net"},
+		IPAddresses:    []net.IP{net.ParseIP("192.168.0.1")},
+		EmailAddresses: []string{"dev@evil.org"},
+	})
+	err = p.Check(csr)
+	require.Error(t, err)
+	for _, msg := range []string{"organization", "example.com.evil.org", "\"internal.net\"", "192.168.0.1", "dev@evil.org"} {
+		require.Contains(t, err.Error(), msg)
+	}
+
+	csr = newRequest(t, ecKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "www.evil.org", Organization: []string{"OVH"}}})
+	require.ErrorContains(t, p.Check(csr), "Common name \"www.evil.org\" is not allowed")
+	csr = newRequest(t, ecKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "WWW.example.com", Organization: []string{"OVH"}}})
+	require.NoError(t, p.Check(csr))
+	csr = newRequest(t, ecKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "Test client v1.2", Organization: []string{"OVH"}}})
+	require.NoError(t, p.Check(csr))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	csr = newRequest(t, rsaKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "test", Organization: []string{"OVH"}}})
+	require.ErrorContains(t, p.Check(csr), "RSA key size 2048")
+
+	require.NoError(t, new(Policy).Check(csr))
+}
+
+func TestExtensions(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	bc, err := asn1.Marshal(struct{ IsCA bool }{true})
+	require.NoError(t, err)
+	csr := newRequest(t, key, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "test"},
+		DNSNames: []string{"example.com"},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidExtensionBasicConstraints, Critical: true, Value: bc},
+			{Id: oidExtensionSubjectKeyId, Value: []byte{4, 1, 1}},
+			{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: []byte{5, 0}},
+			{Id: asn1.ObjectIdentifier{1, 2, 3, 5}, Value: []byte{5, 0}},
+		},
+	})
+
+	exts, stripped, err := new(Policy).Extensions(csr)
+	require.NoError(t, err)
+	require.Len(t, exts, 1)
+	require.True(t, exts[0].Id.Equal(oidExtensionSubjectKeyId))
+	require.Len(t, stripped, 2)
+
+	p := &Policy{UnknownExtensions: ExtensionsReject, AllowedExtensions: []string{"1.2.3.4"}}
+	_, _, err = p.Extensions(csr)
+	require.ErrorContains(t, err, "1.2.3.5")
+
+	p.AllowedExtensions = append(p.AllowedExtensions, "1.2.3.5")
+	exts, stripped, err = p.Extensions(csr)
+	require.NoError(t, err)
+	require.Len(t, exts, 3)
+	require.Empty(t, stripped)
+}
+
+func sanExtension(t *testing.T, names ...asn1.RawValue) pkix.Extension {
+	t.Helper()
+	value, err := asn1.Marshal(names)
+	require.NoError(t, err)
+	return pkix.Extension{Id: oidExtensionSubjectAltName, Value: value}
+}
+
+func TestSubjectAltNamesExtension(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	dns := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeDNS, Bytes: []byte("example.com")}
+	upn, err := asn1.Marshal(struct {
+		Id    asn1.ObjectIdentifier
+		Value string `asn1:"explicit,tag:0,utf8"`
+	}{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}, "okms.domain:1234"})
+	require.NoError(t, err)
+	otherName := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: upn[2:]}
+
+	csr := newRequest(t, key, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: "test"},
+		ExtraExtensions: []pkix.Extension{sanExtension(t, dns)},
+	})
+	require.Equal(t, []string{"example.com"}, csr.DNSNames)
+	exts, stripped, err := new(Policy).Extensions(csr)
+	require.NoError(t, err)
+	require.Empty(t, exts)
+	require.Empty(t, stripped)
+
+	csr = newRequest(t, key, &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: "test"},
+		ExtraExtensions: []pkix.Extension{sanExtension(t, dns, otherName)},
+	})
+	_, stripped, err = new(Policy).Extensions(csr)
+	require.NoError(t, err)
+	require.Equal(t, []asn1.ObjectIdentifier{oidExtensionSubjectAltName}, stripped)
+	_, _, err = (&Policy{UnknownExtensions: ExtensionsReject}).Extensions(csr)
+	require.ErrorContains(t, err, "Subject alternative names other than")
+
+	exts, stripped, err = (&Policy{UnknownExtensions: ExtensionsReject, AllowedExtensions: []string{"2.5.29.17"}}).Extensions(csr)
+	require.NoError(t, err)
+	require.Empty(t, stripped)
+	require.Equal(t, []pkix.Extension{csr.Extensions[0]}, exts)
+
+	(&SubjectAltNames{DNSNames: []string{"www.example.com"}}).Override(csr)
+	require.Equal(t, []string{"www.example.com"}, csr.DNSNames)
+	require.Empty(t, csr.Extensions)
+}
+
+func TestCapValidity(t *testing.T) {
+	p := &Policy{MaxValidity: time.Hour}
+	now := time.Now()
+	tmpl := &x509.Certificate{NotBefore: now, NotAfter: now.Add(30 * time.Minute)}
+	require.False(t, p.CapValidity(tmpl))
+	tmpl.NotAfter = now.Add(2 * time.Hour)
+	require.True(t, p.CapValidity(tmpl))
+	require.Equal(t, now.Add(time.Hour), tmpl.NotAfter)
+}
+
+func TestParseSubjectAltNames(t *testing.T) {
+	san, err := ParseSubjectAltNames([]string{"DNS:example.com", "email:dev@example.com", "IP:10.0.0.1", "URI:spiffe://example.com/a"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"example.com"}, san.DNSNames)
+	require.Equal(t, []string{"dev@example.com"}, san.EmailAddresses)
+	require.Len(t, san.IPAddresses, 1)
+	require.Len(t, san.URIs, 1)
+
+	for _, v := range []string{"example.com", "DNS:", "IP:foo", "URI:foo", "foo:bar"} {
+		_, err := ParseSubjectAltNames([]string{v})
+		require.Error(t, err, v)
+	}
+}
+
+func TestInvalidPolicies(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown field":     "foo: bar",
+		"subject field":     "requiredSubjectFields: [foo]",
+		"ip range":          "allowedIPRanges: [10.0.0.1]",
+		"extensions action": "unknownExtensions: keep",
+		"extension oid":     "allowedExtensions: [foo]",
+		"negative validity": "maxValidity: -1h",
+		"negative key size": "minRsaKeySize: -1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(data))
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Package jwe encrypts and decrypts JWE Compact Serialization tokens whose content encryption key is wrapped with
+// an RSA key, as used by the KMS to import and export wrapped key material.
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Key management algorithms
+const (
+	RSAOAEP    = "RSA-OAEP"
+	RSAOAEP256 = "RSA-OAEP-256"
+)
+
+// A256GCM is the content encryption algorithm used by [Encrypt].
+const A256GCM = "A256GCM"
+
+// Header is the protected header of a JWE token.
+type Header struct {
+	Algorithm  string `json:"alg"`
+	Encryption string `json:"enc"`
+	KeyId      string `json:"kid,omitempty"`
+	Compress   string `json:"zip,omitempty"`
+}
+
+// Decrypt decrypts the JWE Compact Serialization token with key. The token must use the RSA-OAEP or RSA-OAEP-256
+// key management algorithm, and an AES GCM or AES CBC HMAC SHA-2 content encryption algorithm.
+func Decrypt(token string, key *rsa.PrivateKey) ([]byte, *Header, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 5 {
+		return nil, nil, errors.New("Invalid JWE compact serialization")
+	}
+	var raw [5][]byte
+	for i, p := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid JWE compact serialization: %w", err)
+		}
+		raw[i] = b
+	}
+	header := new(Header)
+	if err := json.Unmarshal(raw[0], header); err != nil {
+		return nil, nil, fmt.Errorf("Invalid JWE header: %w", err)
+	}
+	if header.Compress != "" {
+		return nil, nil, fmt.Errorf("Unsupported JWE compression %q", header.Compress)
+	}
+	oaepHash, err := oaepHash(header.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, err := contentEncryption(header.Encryption)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cek, err := rsa.DecryptOAEP(oaepHash, nil, key, raw[1], nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to unwrap the content encryption key: %w", err)
+	}
+	if len(cek) != enc.keySize {
+		return nil, nil, errors.New("Invalid content encryption key size")
+	}
+	// The additional authenticated data is the encoded protected header
+	plaintext, err := enc.decrypt(cek, raw[2], raw[3], raw[4], []byte(parts[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, header, nil
+}
+
+// Encrypt encrypts plaintext for the RSA public key with the given key management algorithm, and returns
+// the JWE Compact Serialization token. The content is encrypted with A256GCM. The optional kid identifies key.
+func Encrypt(plaintext []byte, key *rsa.PublicKey, alg, kid string) (string, error) {
+	return encrypt(plaintext, key, alg, A256GCM, kid)
+}
+
+func encrypt(plaintext []byte, key *rsa.PublicKey, alg, encAlg, kid string) (string, error) {
+	oaepHash, err := oaepHash(alg)
+	if err != nil {
+		return "", err
+	}
+	enc, err := contentEncryption(encAlg)
+	if err != nil {
+		return "", err
+	}
+	cek := make([]byte, enc.keySize)
+	if _, err := rand.Read(cek); err != nil {
+		return "", err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(oaepHash, rand.Reader, key, cek, nil)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(Header{Algorithm: alg, Encryption: encAlg, KeyId: kid})
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	iv, ciphertext, tag, err := enc.encrypt(cek, plaintext, []byte(encodedHeader))
+	if err != nil {
+		return "", err
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	return strings.Join([]string{encodedHeader, b64(encryptedKey), b64(iv), b64(ciphertext), b64(tag)}, "."), nil
+}
+
+func oaepHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case RSAOAEP:
+		return sha1.New(), nil
+	case RSAOAEP256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("Unsupported JWE key management algorithm %q, expected %s or %s", alg, RSAOAEP, RSAOAEP256)
+}
+
+// contentCipher is a JWE content encryption algorithm.
+type contentCipher struct {
+	keySize int
+	// hmac is the hash of the AES CBC HMAC SHA-2 algorithms, nil for AES GCM.
+	hmac func() hash.Hash
+}
+
+func contentEncryption(enc string) (*contentCipher, error) {
+	switch enc {
+	case "A128GCM":
+		return &contentCipher{keySize: 16}, nil
+	case "A192GCM":
+		return &contentCipher{keySize: 24}, nil
+	case "A256GCM":
+		return &contentCipher{keySize: 32}, nil
+	case "A128CBC-HS256":
+		return &contentCipher{keySize: 32, hmac: sha256.New}, nil
+	case "A192CBC-HS384":
+		return &contentCipher{keySize: 48, hmac: sha512.New384}, nil
+	case "A256CBC-HS512":
+		return &contentCipher{keySize: 64, hmac: sha512.New}, nil
+	}
+	return nil, fmt.Errorf("Unsupported JWE content encryption algorithm %q", enc)
+}
+
+func (c *contentCipher) encrypt(cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error) {
+	if c.hmac == nil {
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		iv = make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, nil, err
+		}
+		sealed := gcm.Seal(nil, iv, plaintext, aad)
+		return iv, sealed[:len(plaintext)], sealed[len(plaintext):], nil
+	}
+
+	macKey, encKey := cek[:len(cek)/2], cek[len(cek)/2:]
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv = make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	n := block.BlockSize() - len(plaintext)%block.BlockSize()
+	ciphertext = make([]byte, len(plaintext)+n)
+	copy(ciphertext, plaintext)
+	for i := len(plaintext); i < len(ciphertext); i++ {
+		ciphertext[i] = byte(n)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	return iv, ciphertext, c.tag(macKey, iv, ciphertext, aad), nil
+}
+
+func (c *contentCipher) decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	if c.hmac == nil {
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != gcm.NonceSize() {
+			return nil, errors.New("Invalid JWE initialization vector")
+		}
+		plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), aad)
+		if err != nil {
+			return nil, errors.New("Failed to decrypt the JWE content")
+		}
+		return plaintext, nil
+	}
+
+	// RFC 7518 section 5.2: the first half of the key is the MAC key, the second half the encryption key
+	macKey, encKey := cek[:len(cek)/2], cek[len(cek)/2:]
+	if !hmac.Equal(c.tag(macKey, iv, ciphertext, aad), tag) {
+		return nil, errors.New("Failed to decrypt the JWE content")
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() || len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.New("Failed to decrypt the JWE content")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return unpad(plaintext, block.BlockSize())
+}
+
+// tag computes the authentication tag of the AES CBC HMAC SHA-2 algorithms.
+func (c *contentCipher) tag(macKey, iv, ciphertext, aad []byte) []byte {
+	mac := hmac.New(c.hmac, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(len(aad))*8))
+	return mac.Sum(nil)[:len(macKey)]
+}
+
+// unpad removes the PKCS#7 padding of data.
+func unpad(data []byte, blockSize int) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize {
+		return nil, errors.New("Failed to decrypt the JWE content")
+	}
+	padding := data[len(data)-n:]
+	for _, b := range padding {
+		if subtle.ConstantTimeByteEq(b, byte(n)) != 1 {
+			return nil, errors.New("Failed to decrypt the JWE content")
+		}
+	}
+	return data[:len(data)-n], nil
+}
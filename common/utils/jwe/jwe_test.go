@@ -0,0 +1,72 @@
+package jwe
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	plaintext := []byte("some wrapped key material")
+
+	for _, alg := range []string{RSAOAEP, RSAOAEP256} {
+		for _, enc := range []string{"A128GCM", "A192GCM", "A256GCM", "A128CBC-HS256", "A192CBC-HS384", "A256CBC-HS512"} {
+			t.Run(alg+"/"+enc, func(t *testing.T) {
+				token, err := encrypt(plaintext, &key.PublicKey, alg, enc, "")
+				require.NoError(t, err)
+				got, hdr, err := Decrypt(token, key)
+				require.NoError(t, err)
+				require.Equal(t, plaintext, got)
+				require.Equal(t, alg, hdr.Algorithm)
+				require.Equal(t, enc, hdr.Encryption)
+
+				// Tampering with the header must be detected
+				parts := strings.Split(token, ".")
+				hdrJson, _ := json.Marshal(map[string]string{"alg": alg, "enc": enc, "kid": "other"})
+				parts[0] = base64.RawURLEncoding.EncodeToString(hdrJson)
+				_, _, err = Decrypt(strings.Join(parts, "."), key)
+				require.ErrorContains(t, err, "Failed to decrypt the JWE content")
+			})
+		}
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	token, err := Encrypt([]byte("data"), &key.PublicKey, RSAOAEP256, "")
+	require.NoError(t, err)
+
+	_, _, err = Decrypt(token, other)
+	require.ErrorContains(t, err, "Failed to unwrap the content encryption key")
+
+	_, _, err = Decrypt("a.b.c", key)
+	require.ErrorContains(t, err, "Invalid JWE compact serialization")
+
+	hdr := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RSA1_5","enc":"A256GCM"}`))
+	_, _, err = Decrypt(hdr+token[strings.Index(token, "."):], key)
+	require.ErrorContains(t, err, "Unsupported JWE key management algorithm")
+}
+
+func TestEncrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	token, err := Encrypt([]byte("key material"), &key.PublicKey, RSAOAEP, "transport-key")
+	require.NoError(t, err)
+	got, hdr, err := Decrypt(token, key)
+	require.NoError(t, err)
+	require.Equal(t, []byte("key material"), got)
+	require.Equal(t, Header{Algorithm: RSAOAEP, Encryption: A256GCM, KeyId: "transport-key"}, *hdr)
+
+	_, err = Encrypt([]byte("key material"), &key.PublicKey, "RSA1_5", "")
+	require.ErrorContains(t, err, "Unsupported JWE key management algorithm")
+}
@@ -0,0 +1,298 @@
+// Package keyaudit implements the compliance policies the service keys of a domain are audited against,
+// and the pass/fail reports of the audits.
+package keyaudit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ovh/okms-cli/common/flagsmgmt/restflags"
+	"github.com/ovh/okms-cli/common/utils/keyrotation"
+	"github.com/ovh/okms-sdk-go/types"
+	"go.yaml.in/yaml/v3"
+)
+
+// Rules of a policy, as reported in the violations.
+const (
+	RuleMinRSAKeySize           = "minRsaKeySize"
+	RuleMinOctKeySize           = "minOctKeySize"
+	RuleAllowedCurves           = "allowedCurves"
+	RuleForbidExtractable       = "forbidExtractable"
+	RuleRequiredProtectionLevel = "requiredProtectionLevel"
+	RuleMaxAge                  = "maxAge"
+	RuleForbiddenUsages         = "forbiddenUsages"
+)
+
+// Policy is a key compliance policy. The zero value accepts any key.
+type Policy struct {
+	// MinRSAKeySize is the minimum size in bits of RSA keys.
+	MinRSAKeySize int `yaml:"minRsaKeySize"`
+	// MinOctKeySize is the minimum size in bits of symmetric keys.
+	MinOctKeySize int `yaml:"minOctKeySize"`
+	// AllowedCurves restricts the curves of EC keys (P-256, P-384, P-521). Any curve is allowed if not set.
+	AllowedCurves []string `yaml:"allowedCurves"`
+	// ForbidExtractable rejects the keys whose material can be exported.
+	ForbidExtractable bool `yaml:"forbidExtractable"`
+	// RequiredProtectionLevel is the protection level the keys must have: soft or hsm. Any level is allowed if not set.
+	RequiredProtectionLevel string `yaml:"requiredProtectionLevel"`
+	// MaxAge is the maximum age of the keys since their creation, like 365d. Not checked if zero.
+	MaxAge Duration `yaml:"maxAge"`
+	// ForbiddenUsages lists the usage combinations a key must not have all together, like [encrypt, sign].
+	ForbiddenUsages [][]string `yaml:"forbiddenUsages"`
+}
+
+// Duration is a policy duration, decoded from a number of days like 90d, of weeks like 2w, or a duration like 36h.
+type Duration time.Duration
+
+// UnmarshalYAML decodes the duration with [keyrotation.ParseDuration].
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := keyrotation.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load reads and validates a YAML policy file.
+func Load(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key audit policy %q: %w", file, err)
+	}
+	return p, nil
+}
+
+// Parse parses and validates a YAML policy. Unknown fields are rejected.
+func Parse(data []byte) (*Policy, error) {
+	p := new(Policy)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the policy values, and normalizes the protection level.
+func (p *Policy) Validate() error {
+	if p.MinRSAKeySize < 0 || p.MinOctKeySize < 0 {
+		return errors.New("Minimum key sizes must be positive")
+	}
+	if p.MaxAge < 0 {
+		return errors.New("Maximum age must be positive")
+	}
+	for _, c := range p.AllowedCurves {
+		var curve restflags.CurveType
+		if err := curve.Set(c); err != nil {
+			return fmt.Errorf("Invalid curve: %w", err)
+		}
+	}
+	if p.RequiredProtectionLevel != "" {
+		var level restflags.ProtectionLevel
+		if err := level.Set(p.RequiredProtectionLevel); err != nil {
+			return fmt.Errorf("Invalid protection level: %w", err)
+		}
+		p.RequiredProtectionLevel = string(level)
+	}
+	for _, usages := range p.ForbiddenUsages {
+		if len(usages) == 0 {
+			return errors.New("Forbidden usage combinations cannot be empty")
+		}
+		var usage restflags.KeyUsageList
+		if err := usage.Set(strings.Join(usages, ",")); err != nil {
+			return fmt.Errorf("Invalid forbidden usage: %w", err)
+		}
+	}
+	return nil
+}
+
+// Attributes are the key attributes which are not part of the key response fields.
+type Attributes struct {
+	State     types.KeyStates
+	CreatedAt time.Time
+	// Extractable is nil when the key does not expose it.
+	Extractable *bool
+}
+
+// Violation is a policy rule a key does not comply with.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Result is the audit result of a key.
+type Result struct {
+	Id         uuid.UUID       `json:"id"`
+	Name       string          `json:"name"`
+	Type       types.KeyTypes  `json:"type"`
+	State      types.KeyStates `json:"state"`
+	Passed     bool            `json:"passed"`
+	Violations []Violation     `json:"violations,omitempty"`
+	// Unchecked lists the rules which could not be checked, because the key does not expose the audited attribute.
+	Unchecked []Violation `json:"unchecked,omitempty"`
+}
+
+// Audit checks a key against the policy. The age of the key is computed at the given time.
+func (p *Policy) Audit(key *types.GetServiceKeyResponse, attrs Attributes, now time.Time) Result {
+	res := Result{Id: key.Id, Name: key.Name, Type: key.Type, State: attrs.State}
+	violate := func(rule, format string, args ...any) {
+		res.Violations = append(res.Violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	uncheck := func(rule, message string) {
+		res.Unchecked = append(res.Unchecked, Violation{Rule: rule, Message: message})
+	}
+
+	minSize, sizeRule := 0, ""
+	switch key.Type {
+	case types.RSA:
+		minSize, sizeRule = p.MinRSAKeySize, RuleMinRSAKeySize
+	case types.Oct:
+		minSize, sizeRule = p.MinOctKeySize, RuleMinOctKeySize
+	}
+	if minSize > 0 {
+		if key.Size == nil {
+			uncheck(sizeRule, "The key size is unknown")
+		} else if int(*key.Size) < minSize {
+			violate(sizeRule, "Key size is %d bits, expected at least %d", *key.Size, minSize)
+		}
+	}
+
+	if key.Type == types.EC && len(p.AllowedCurves) > 0 {
+		if key.Curve == nil {
+			uncheck(RuleAllowedCurves, "The key curve is unknown")
+		} else if !slices.Contains(p.AllowedCurves, string(*key.Curve)) {
+			violate(RuleAllowedCurves, "Curve %s is not allowed", *key.Curve)
+		}
+	}
+
+	if p.ForbidExtractable {
+		if attrs.Extractable == nil {
+			uncheck(RuleForbidExtractable, "The key does not expose whether it is extractable")
+		} else if *attrs.Extractable {
+			violate(RuleForbidExtractable, "Key is extractable")
+		}
+	}
+
+	if p.RequiredProtectionLevel != "" {
+		if key.ProtectionLevel == "" {
+			uncheck(RuleRequiredProtectionLevel, "The key protection level is unknown")
+		} else if string(key.ProtectionLevel) != p.RequiredProtectionLevel {
+			violate(RuleRequiredProtectionLevel, "Protection level is %s, expected %s", key.ProtectionLevel, p.RequiredProtectionLevel)
+		}
+	}
+
+	if p.MaxAge > 0 {
+		if attrs.CreatedAt.IsZero() {
+			uncheck(RuleMaxAge, "The key creation date is unknown")
+		} else if age := now.Sub(attrs.CreatedAt); age > time.Duration(p.MaxAge) {
+			violate(RuleMaxAge, "Key was created %d days ago on %s, expected at most %s", int(age.Hours()/24), attrs.CreatedAt.Format(time.DateOnly), keyrotation.FormatDuration(time.Duration(p.MaxAge)))
+		}
+	}
+
+	var usage []types.CryptographicUsages
+	if key.Operations != nil {
+		usage = *key.Operations
+	}
+	for _, combination := range p.ForbiddenUsages {
+		if !slices.ContainsFunc(combination, func(u string) bool { return !slices.Contains(usage, types.CryptographicUsages(u)) }) {
+			violate(RuleForbiddenUsages, "Usage combination [%s] is forbidden", strings.Join(combination, ","))
+		}
+	}
+
+	res.Passed = len(res.Violations) == 0
+	return res
+}
+
+// Report is the audit report of the keys of a domain.
+type Report struct {
+	Passed int      `json:"passed"`
+	Failed int      `json:"failed"`
+	Keys   []Result `json:"keys"`
+}
+
+// Add adds a key result to the report.
+func (r *Report) Add(res Result) {
+	if res.Passed {
+		r.Passed++
+	} else {
+		r.Failed++
+	}
+	r.Keys = append(r.Keys, res)
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnit returns the report as a JUnit XML test suite, with a test case per key.
+func (r *Report) JUnit(name string, timestamp time.Time) ([]byte, error) {
+	suite := junitTestSuite{
+		Name:      name,
+		Tests:     len(r.Keys),
+		Failures:  r.Failed,
+		Timestamp: timestamp.UTC().Format(time.RFC3339),
+		TestCases: []junitTestCase{},
+	}
+	for _, res := range r.Keys {
+		tc := junitTestCase{Name: fmt.Sprintf("%s (%s)", res.Name, res.Id), ClassName: name}
+		if !res.Passed {
+			var lines []string
+			for _, v := range res.Violations {
+				lines = append(lines, v.Rule+": "+v.Message)
+			}
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d policy rules violated", len(res.Violations)),
+				Type:    "policy",
+				Text:    strings.Join(lines, "\n"),
+			}
+		}
+		var unchecked []string
+		for _, v := range res.Unchecked {
+			unchecked = append(unchecked, "Not checked "+v.Rule+": "+v.Message)
+		}
+		tc.SystemOut = strings.Join(unchecked, "\n")
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	out, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
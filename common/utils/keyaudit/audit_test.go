@@ -0,0 +1,121 @@
+package keyaudit
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ovh/okms-cli/common/utils"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `
+minRsaKeySize: 3072
+minOctKeySize: 256
+allowedCurves: [P-384]
+forbidExtractable: true
+requiredProtectionLevel: hsm
+maxAge: 365d
+forbiddenUsages:
+  - [encrypt, sign]
+`
+
+var now = time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	require.NoError(t, err)
+	require.Equal(t, "HSM", p.RequiredProtectionLevel)
+	require.Equal(t, Duration(365*24*time.Hour), p.MaxAge)
+
+	p, err = Parse([]byte("maxAge: 2w"))
+	require.NoError(t, err)
+	require.Equal(t, Duration(14*24*time.Hour), p.MaxAge)
+
+	for policy, msg := range map[string]string{
+		"minRsaKeySize: -1":               "Minimum key sizes must be positive",
+		"maxAge: -1h":                     "Maximum age must be positive",
+		"maxAge: 1y":                      "Invalid duration",
+		"allowedCurves: [P-192]":          "Invalid curve",
+		"requiredProtectionLevel: cloud":  "Invalid protection level",
+		"forbiddenUsages: [[encrypt, x]]": "Invalid forbidden usage",
+		"forbiddenUsages: [[]]":           "cannot be empty",
+		"minRsaSize: 2048":                "field minRsaSize not found",
+	} {
+		_, err := Parse([]byte(policy))
+		require.ErrorContains(t, err, msg, policy)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	require.NoError(t, err)
+
+	compliant := &types.GetServiceKeyResponse{
+		Id:              uuid.New(),
+		Name:            "compliant",
+		Type:            types.EC,
+		Curve:           utils.PtrTo(types.P384),
+		Operations:      &[]types.CryptographicUsages{types.Sign, types.Verify},
+		ProtectionLevel: types.HSM,
+	}
+	res := p.Audit(compliant, Attributes{State: types.KeyStatesActive, CreatedAt: now.AddDate(0, -1, 0), Extractable: utils.PtrTo(false)}, now)
+	require.True(t, res.Passed)
+	require.Empty(t, res.Violations)
+	require.Empty(t, res.Unchecked)
+
+	rsaKey := &types.GetServiceKeyResponse{
+		Id:              uuid.New(),
+		Name:            "weak",
+		Type:            types.RSA,
+		Size:            utils.PtrTo(types.N2048),
+		Operations:      &[]types.CryptographicUsages{types.Encrypt, types.Decrypt, types.Sign},
+		ProtectionLevel: types.SOFTWARE,
+	}
+	res = p.Audit(rsaKey, Attributes{State: types.KeyStatesActive, CreatedAt: now.AddDate(-2, 0, 0), Extractable: utils.PtrTo(true)}, now)
+	require.False(t, res.Passed)
+	var rules []string
+	for _, v := range res.Violations {
+		rules = append(rules, v.Rule)
+	}
+	require.Equal(t, []string{RuleMinRSAKeySize, RuleForbidExtractable, RuleRequiredProtectionLevel, RuleMaxAge, RuleForbiddenUsages}, rules)
+	require.Equal(t, "Key size is 2048 bits, expected at least 3072", res.Violations[0].Message)
+	require.Equal(t, "Key was created 730 days ago on 2024-06-01, expected at most 365d", res.Violations[3].Message)
+
+	ecKey := &types.GetServiceKeyResponse{Id: uuid.New(), Name: "unknown", Type: types.EC, Curve: utils.PtrTo(types.P256)}
+	res = p.Audit(ecKey, Attributes{State: types.KeyStatesActive}, now)
+	require.False(t, res.Passed)
+	require.Equal(t, []Violation{{Rule: RuleAllowedCurves, Message: "Curve P-256 is not allowed"}}, res.Violations)
+	require.Len(t, res.Unchecked, 3)
+
+	// The zero policy accepts any key
+	res = new(Policy).Audit(rsaKey, Attributes{}, now)
+	require.True(t, res.Passed)
+	require.Empty(t, res.Unchecked)
+}
+
+func TestJUnit(t *testing.T) {
+	p, err := Parse([]byte("minOctKeySize: 256\nforbidExtractable: true"))
+	require.NoError(t, err)
+
+	var report Report
+	report.Add(p.Audit(&types.GetServiceKeyResponse{Id: uuid.New(), Name: "small", Type: types.Oct, Size: utils.PtrTo(types.N128)}, Attributes{Extractable: utils.PtrTo(false)}, now))
+	report.Add(p.Audit(&types.GetServiceKeyResponse{Id: uuid.New(), Name: "ok", Type: types.Oct, Size: utils.PtrTo(types.N256)}, Attributes{}, now))
+	require.Equal(t, 1, report.Passed)
+	require.Equal(t, 1, report.Failed)
+
+	data, err := report.JUnit("okms keys audit", now)
+	require.NoError(t, err)
+	var suite junitTestSuite
+	require.NoError(t, xml.Unmarshal(data, &suite))
+	require.Equal(t, 2, suite.Tests)
+	require.Equal(t, 1, suite.Failures)
+	require.Equal(t, "2026-06-01T00:00:00Z", suite.Timestamp)
+	require.Len(t, suite.TestCases, 2)
+	require.NotNil(t, suite.TestCases[0].Failure)
+	require.Equal(t, "minOctKeySize: Key size is 128 bits, expected at least 256", suite.TestCases[0].Failure.Text)
+	require.Nil(t, suite.TestCases[1].Failure)
+	require.Contains(t, suite.TestCases[1].SystemOut, "Not checked forbidExtractable")
+}
@@ -0,0 +1,284 @@
+// Package keyfilter implements the client side filters and sort orders of the service key listings.
+package keyfilter
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ovh/okms-cli/common/flagsmgmt/restflags"
+	"github.com/ovh/okms-sdk-go/types"
+)
+
+// Fields are the names of the filter fields, as accepted by [Filter.Set].
+var Fields = []string{"type", "state", "name", "usage", "protection-level", "created-after", "created-before"}
+
+// Key is a service key, with the attributes used by the filters and sort orders.
+type Key struct {
+	*types.GetServiceKeyResponse
+	State types.KeyStates
+	// CreatedAt is the creation date of the key, zero if unknown. A key with an unknown creation date never
+	// matches the date filters.
+	CreatedAt time.Time
+}
+
+// Filter selects service keys. The zero value matches any key.
+type Filter struct {
+	// Types are the allowed key types. Any type matches if empty.
+	Types []types.KeyTypes
+	// States are the allowed key states. Any state matches if empty.
+	States []types.KeyStates
+	// Name is a glob pattern, or a regular expression between slashes, the key names must match.
+	Name string
+	// Usage lists the operations the keys must all allow.
+	Usage []types.CryptographicUsages
+	// ProtectionLevels are the allowed protection levels. Any level matches if empty.
+	ProtectionLevels []types.ProtectionLevelEnum
+	// CreatedAfter and CreatedBefore bound the key creation dates, when not zero.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	nameRegexp *regexp.Regexp
+}
+
+// Set parses and sets the value of a filter field. Type, state, usage and protection-level accept
+// comma-separated lists. Dates are formatted as 2006-01-02 or RFC3339.
+func (f *Filter) Set(field, value string) error {
+	switch field {
+	case "type":
+		f.Types = nil
+		for _, v := range splitList(value) {
+			var kt restflags.KeyType
+			if err := kt.Set(v); err != nil {
+				return fmt.Errorf("Invalid type filter: %w", err)
+			}
+			f.Types = append(f.Types, kt.RestModel())
+		}
+	case "state":
+		f.States = nil
+		for _, v := range splitList(value) {
+			state := types.KeyStates(v)
+			if !slices.Contains(states, state) {
+				return fmt.Errorf("Invalid state filter %q, must be one of %s", v, joinStates(states))
+			}
+			f.States = append(f.States, state)
+		}
+	case "name":
+		if err := f.setName(value); err != nil {
+			return err
+		}
+	case "usage":
+		var usage restflags.KeyUsageList
+		if err := usage.Set(value); err != nil {
+			return fmt.Errorf("Invalid usage filter: %w", err)
+		}
+		f.Usage = usage.ToCryptographicUsage()
+	case "protection-level":
+		f.ProtectionLevels = nil
+		for _, v := range splitList(value) {
+			var level restflags.ProtectionLevel
+			if err := level.Set(v); err != nil {
+				return fmt.Errorf("Invalid protection level filter: %w", err)
+			}
+			f.ProtectionLevels = append(f.ProtectionLevels, level.RestModel())
+		}
+	case "created-after", "created-before":
+		date, err := ParseDate(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s filter: %w", field, err)
+		}
+		if field == "created-after" {
+			f.CreatedAfter = date
+		} else {
+			f.CreatedBefore = date
+		}
+	default:
+		return fmt.Errorf("Unknown filter field %q, must be one of %s", field, strings.Join(Fields, ", "))
+	}
+	return nil
+}
+
+// Parse parses a filter expression made of space-separated FIELD=VALUE terms, which must all match,
+// like "name=tmp-* state=active created-before=2025-01-01". The fields are the ones accepted by [Filter.Set].
+func Parse(expr string) (*Filter, error) {
+	f := new(Filter)
+	terms := strings.Fields(expr)
+	if len(terms) == 0 {
+		return nil, errors.New("Empty filter expression")
+	}
+	for _, term := range terms {
+		field, value, ok := strings.Cut(term, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("Invalid filter term %q, expected FIELD=VALUE", term)
+		}
+		if err := f.Set(field, value); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// states are the states a key can be in.
+var states = []types.KeyStates{
+	types.KeyStatesPreActive,
+	types.KeyStatesActive,
+	types.KeyStatesDeactivated,
+	types.KeyStatesCompromised,
+	types.KeyStatesDestroyed,
+	types.KeyStatesDestroyedCompromised,
+}
+
+func joinStates(states []types.KeyStates) string {
+	names := make([]string, 0, len(states))
+	for _, s := range states {
+		names = append(names, string(s))
+	}
+	return strings.Join(names, ", ")
+}
+
+func splitList(value string) []string {
+	var list []string
+	for v := range strings.SplitSeq(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (f *Filter) setName(pattern string) error {
+	f.Name, f.nameRegexp = pattern, nil
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return fmt.Errorf("Invalid name regular expression: %w", err)
+		}
+		f.nameRegexp = re
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("Invalid name pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// ParseDate parses a date formatted as 2006-01-02, or as RFC3339.
+func ParseDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid date %q, must be formatted as 2006-01-02 or 2006-01-02T15:04:05Z07:00", value)
+	}
+	return date, nil
+}
+
+// ListState returns the state to list the keys with, so that the API returns the keys in the filtered states.
+// The given default state is used when the filter has no state.
+func (f *Filter) ListState(def types.KeyStates) types.KeyStates {
+	switch len(f.States) {
+	case 0:
+		return def
+	case 1:
+		return f.States[0]
+	default:
+		return types.KeyStatesAll
+	}
+}
+
+// Match returns whether a key passes the filter.
+func (f *Filter) Match(k *Key) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, k.Type) {
+		return false
+	}
+	if len(f.States) > 0 && !slices.Contains(f.States, k.State) {
+		return false
+	}
+	if f.nameRegexp != nil {
+		if !f.nameRegexp.MatchString(k.Name) {
+			return false
+		}
+	} else if f.Name != "" {
+		if ok, _ := path.Match(f.Name, k.Name); !ok {
+			return false
+		}
+	}
+	if len(f.Usage) > 0 {
+		if k.Operations == nil {
+			return false
+		}
+		for _, u := range f.Usage {
+			if !slices.Contains(*k.Operations, u) {
+				return false
+			}
+		}
+	}
+	if len(f.ProtectionLevels) > 0 && !slices.Contains(f.ProtectionLevels, k.ProtectionLevel) {
+		return false
+	}
+	if (!f.CreatedAfter.IsZero() || !f.CreatedBefore.IsZero()) && k.CreatedAt.IsZero() {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !k.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !k.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// SortFields are the fields the keys can be sorted by.
+var SortFields = []string{"name", "created", "type", "state", "id"}
+
+// Sort is a sort order of service keys.
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses a sort order formatted as FIELD[:asc|desc].
+func ParseSort(value string) (Sort, error) {
+	field, dir, _ := strings.Cut(value, ":")
+	if !slices.Contains(SortFields, field) {
+		return Sort{}, fmt.Errorf("Invalid sort field %q, must be one of %s", field, strings.Join(SortFields, ", "))
+	}
+	switch dir {
+	case "", "asc":
+		return Sort{Field: field}, nil
+	case "desc":
+		return Sort{Field: field, Desc: true}, nil
+	default:
+		return Sort{}, errors.New("Invalid sort direction, must be asc or desc")
+	}
+}
+
+// Apply sorts the keys. Keys with equal fields are sorted by name, then by ID.
+func (s Sort) Apply(keys []Key) {
+	slices.SortStableFunc(keys, func(a, b Key) int {
+		var c int
+		switch s.Field {
+		case "created":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case "type":
+			c = cmp.Compare(a.Type, b.Type)
+		case "state":
+			c = cmp.Compare(a.State, b.State)
+		case "id":
+			c = cmp.Compare(a.Id.String(), b.Id.String())
+		}
+		if c == 0 {
+			c = cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Id.String(), b.Id.String()))
+		}
+		if s.Desc {
+			c = -c
+		}
+		return c
+	})
+}
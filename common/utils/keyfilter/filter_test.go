@@ -0,0 +1,155 @@
+package keyfilter
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(name string, typ types.KeyTypes, state types.KeyStates, created string, ops ...types.CryptographicUsages) Key {
+	date, err := time.Parse(time.DateOnly, created)
+	if err != nil {
+		panic(err)
+	}
+	return Key{
+		GetServiceKeyResponse: &types.GetServiceKeyResponse{
+			Id:              uuid.New(),
+			Name:            name,
+			Type:            typ,
+			Operations:      &ops,
+			ProtectionLevel: types.SOFTWARE,
+		},
+		State:     state,
+		CreatedAt: date,
+	}
+}
+
+func names(keys []Key) []string {
+	var list []string
+	for _, k := range keys {
+		list = append(list, k.Name)
+	}
+	return list
+}
+
+func filterKeys(t *testing.T, keys []Key, fields map[string]string) []string {
+	t.Helper()
+	var f Filter
+	for field, value := range fields {
+		require.NoError(t, f.Set(field, value))
+	}
+	var matches []Key
+	for _, k := range keys {
+		if f.Match(&k) {
+			matches = append(matches, k)
+		}
+	}
+	return names(matches)
+}
+
+var testKeys = []Key{
+	newKey("app-1", types.Oct, types.KeyStatesActive, "2024-03-01", types.Encrypt, types.Decrypt),
+	newKey("app-2", types.RSA, types.KeyStatesDeactivated, "2025-06-01", types.Sign, types.Verify),
+	newKey("tmp-1", types.EC, types.KeyStatesActive, "2024-12-31", types.Sign),
+	newKey("tmp-2", types.Oct, types.KeyStatesCompromised, "2025-01-15", types.WrapKey, types.UnwrapKey),
+}
+
+func TestMatch(t *testing.T) {
+	require.Equal(t, []string{"app-1", "app-2", "tmp-1", "tmp-2"}, filterKeys(t, testKeys, nil))
+	require.Equal(t, []string{"app-1", "tmp-2"}, filterKeys(t, testKeys, map[string]string{"type": "oct"}))
+	require.Equal(t, []string{"app-2", "tmp-1"}, filterKeys(t, testKeys, map[string]string{"type": "RSA, ec"}))
+	require.Equal(t, []string{"app-2", "tmp-2"}, filterKeys(t, testKeys, map[string]string{"state": "deactivated,compromised"}))
+	require.Equal(t, []string{"tmp-1", "tmp-2"}, filterKeys(t, testKeys, map[string]string{"name": "tmp-*"}))
+	require.Equal(t, []string{"app-2", "tmp-2"}, filterKeys(t, testKeys, map[string]string{"name": "/-2$/"}))
+	require.Equal(t, []string{"app-2", "tmp-1"}, filterKeys(t, testKeys, map[string]string{"usage": "sign"}))
+	require.Equal(t, []string{"app-2"}, filterKeys(t, testKeys, map[string]string{"usage": "sign,verify"}))
+	require.Empty(t, filterKeys(t, testKeys, map[string]string{"protection-level": "hsm"}))
+	require.Equal(t, []string{"tmp-1", "tmp-2"}, filterKeys(t, testKeys, map[string]string{"created-after": "2024-06-01", "created-before": "2025-02-01T00:00:00Z"}))
+	require.Equal(t, []string{"tmp-1"}, filterKeys(t, testKeys, map[string]string{"name": "tmp-*", "state": "active", "created-before": "2025-01-01"}))
+
+	// A key with an unknown creation date only matches when no date filter is set
+	unknown := newKey("unknown", types.Oct, types.KeyStatesActive, "2024-01-01")
+	unknown.CreatedAt = time.Time{}
+	keys := append(slices.Clone(testKeys), unknown)
+	require.Contains(t, filterKeys(t, keys, map[string]string{"type": "oct"}), "unknown")
+	require.Equal(t, []string{"app-1", "tmp-1"}, filterKeys(t, keys, map[string]string{"created-before": "2025-01-01"}))
+	require.Equal(t, []string{"app-2", "tmp-2"}, filterKeys(t, keys, map[string]string{"created-after": "2025-01-01"}))
+}
+
+func TestSetErrors(t *testing.T) {
+	for field, value := range map[string]string{
+		"type":             "des",
+		"state":            "all",
+		"name":             "[",
+		"usage":            "print",
+		"protection-level": "cloud",
+		"created-after":    "yesterday",
+		"owner":            "me",
+	} {
+		var f Filter
+		require.Error(t, f.Set(field, value), field)
+	}
+	var f Filter
+	require.ErrorContains(t, f.Set("name", "/(/"), "Invalid name regular expression")
+}
+
+func TestListState(t *testing.T) {
+	var f Filter
+	require.Equal(t, types.KeyStatesActive, f.ListState(types.KeyStatesActive))
+	require.NoError(t, f.Set("state", "deactivated"))
+	require.Equal(t, types.KeyStatesDeactivated, f.ListState(types.KeyStatesActive))
+	require.NoError(t, f.Set("state", "active,deactivated"))
+	require.Equal(t, types.KeyStatesAll, f.ListState(types.KeyStatesActive))
+}
+
+func TestSort(t *testing.T) {
+	keys := append([]Key{}, testKeys...)
+	s, err := ParseSort("created:desc")
+	require.NoError(t, err)
+	s.Apply(keys)
+	require.Equal(t, []string{"app-2", "tmp-2", "tmp-1", "app-1"}, names(keys))
+
+	s, err = ParseSort("type")
+	require.NoError(t, err)
+	s.Apply(keys)
+	require.Equal(t, []string{"tmp-1", "app-2", "app-1", "tmp-2"}, names(keys))
+
+	s, err = ParseSort("name:asc")
+	require.NoError(t, err)
+	s.Apply(keys)
+	require.Equal(t, []string{"app-1", "app-2", "tmp-1", "tmp-2"}, names(keys))
+
+	_, err = ParseSort("size")
+	require.ErrorContains(t, err, "Invalid sort field")
+	_, err = ParseSort("name:up")
+	require.ErrorContains(t, err, "Invalid sort direction")
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse("name=tmp-*  state=active,deactivated\tcreated-before=2025-01-01")
+	require.NoError(t, err)
+	require.Equal(t, "tmp-*", f.Name)
+	require.Equal(t, []types.KeyStates{types.KeyStatesActive, types.KeyStatesDeactivated}, f.States)
+	var matches []Key
+	for _, k := range testKeys {
+		if f.Match(&k) {
+			matches = append(matches, k)
+		}
+	}
+	require.Equal(t, []string{"tmp-1"}, names(matches))
+
+	for expr, msg := range map[string]string{
+		"":              "Empty filter expression",
+		"name":          `Invalid filter term "name"`,
+		"state=":        `Invalid filter term "state="`,
+		"owner=me":      `Unknown filter field "owner"`,
+		"type=oct size": `Invalid filter term "size"`,
+	} {
+		_, err := Parse(expr)
+		require.ErrorContains(t, err, msg, expr)
+	}
+}
@@ -0,0 +1,294 @@
+// Package keymanifest implements the declarative manifests of the keys a service needs, and the plan of the
+// changes which provision them in a KMS domain.
+package keymanifest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ovh/okms-cli/common/flagsmgmt/restflags"
+	"github.com/ovh/okms-sdk-go/types"
+	"go.yaml.in/yaml/v3"
+)
+
+// Manifest declares the keys of a domain.
+type Manifest struct {
+	Keys []Key `yaml:"keys"`
+}
+
+// Key declares a key. Keys are matched with the domain keys by ID when set, by name otherwise.
+type Key struct {
+	// Name of the key. A key matched by ID is renamed to it.
+	Name string `yaml:"name"`
+	// Id is the optional ID of the key.
+	Id string `yaml:"id"`
+	// Type is the key type: oct, rsa or ec.
+	Type string `yaml:"type"`
+	// Size in bits of oct and rsa keys. Defaults to 256 for oct keys, and 2048 for rsa keys.
+	Size int32 `yaml:"size"`
+	// Curve of ec keys: P-256, P-384 or P-521. Defaults to P-256.
+	Curve string `yaml:"curve"`
+	// Usage lists the key operations (sign, verify, encrypt, decrypt, wrapKey, unwrapKey, deriveKey, deriveBits).
+	Usage []string `yaml:"usage"`
+	// ProtectionLevel is soft or hsm. The domain default is used if not set.
+	ProtectionLevel string `yaml:"protectionLevel"`
+	// Extractable keys can be exported. Only applied on creation.
+	Extractable bool `yaml:"extractable"`
+	// Context of the key. Defaults to the key name. Only applied on creation.
+	Context string `yaml:"context"`
+
+	keyType restflags.KeyType
+}
+
+// Load reads and validates a YAML manifest file.
+func Load(file string) (*Manifest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key manifest %q: %w", file, err)
+	}
+	return m, nil
+}
+
+// Parse parses and validates a YAML manifest, and fills the default values. Unknown fields are rejected.
+func Parse(data []byte) (*Manifest, error) {
+	m := new(Manifest)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks the manifest, and fills the default values.
+func (m *Manifest) Validate() error {
+	names := map[string]bool{}
+	ids := map[string]bool{}
+	for i := range m.Keys {
+		k := &m.Keys[i]
+		if err := k.validate(); err != nil {
+			if k.Name == "" {
+				return fmt.Errorf("Key #%d: %w", i+1, err)
+			}
+			return fmt.Errorf("Key %q: %w", k.Name, err)
+		}
+		if names[k.Name] {
+			return fmt.Errorf("Duplicate key name %q", k.Name)
+		}
+		names[k.Name] = true
+		if k.Id != "" {
+			if ids[k.Id] {
+				return fmt.Errorf("Duplicate key ID %q", k.Id)
+			}
+			ids[k.Id] = true
+		}
+	}
+	return nil
+}
+
+func (k *Key) validate() error {
+	if k.Name == "" {
+		return errors.New("Missing name")
+	}
+	if k.Id != "" {
+		id, err := uuid.Parse(k.Id)
+		if err != nil {
+			return fmt.Errorf("Invalid ID: %w", err)
+		}
+		k.Id = id.String()
+	}
+
+	if err := k.keyType.Set(k.Type); err != nil {
+		return fmt.Errorf("Invalid type: %w", err)
+	}
+	k.Type = strings.ToLower(k.Type)
+	switch k.keyType {
+	case restflags.ELLIPTIC_CURVE:
+		if k.Size != 0 {
+			return errors.New("The size cannot be set on ec keys, set the curve instead")
+		}
+		if k.Curve == "" {
+			k.Curve = string(restflags.CURVE_P256)
+		}
+		var curve restflags.CurveType
+		if err := curve.Set(k.Curve); err != nil {
+			return fmt.Errorf("Invalid curve: %w", err)
+		}
+	default:
+		if k.Curve != "" {
+			return fmt.Errorf("The curve cannot be set on %s keys", k.Type)
+		}
+		if k.Size == 0 {
+			k.Size = 256
+			if k.keyType == restflags.RSA {
+				k.Size = 2048
+			}
+		}
+	}
+
+	if len(k.Usage) == 0 {
+		return errors.New("Missing usage")
+	}
+	var usage restflags.KeyUsageList
+	if err := usage.Set(strings.Join(k.Usage, ",")); err != nil {
+		return fmt.Errorf("Invalid usage: %w", err)
+	}
+
+	if k.ProtectionLevel != "" {
+		var level restflags.ProtectionLevel
+		if err := level.Set(k.ProtectionLevel); err != nil {
+			return fmt.Errorf("Invalid protection level: %w", err)
+		}
+		k.ProtectionLevel = string(level)
+	}
+	return nil
+}
+
+// Request returns the request creating the validated key.
+func (k *Key) Request() types.CreateImportServiceKeyRequest {
+	keyType := k.keyType.RestModel()
+	keyContext := k.Context
+	if keyContext == "" {
+		keyContext = k.Name
+	}
+	req := types.CreateImportServiceKeyRequest{
+		Name:        k.Name,
+		Context:     &keyContext,
+		Type:        &keyType,
+		Operations:  &[]types.CryptographicUsages{},
+		Extractable: &k.Extractable,
+	}
+	for _, u := range k.Usage {
+		*req.Operations = append(*req.Operations, types.CryptographicUsages(u))
+	}
+	if k.Id != "" {
+		req.Id = &k.Id
+	}
+	if k.Curve != "" {
+		curve := types.Curves(k.Curve)
+		req.Curve = &curve
+	} else {
+		size := types.KeySizes(k.Size)
+		req.Size = &size
+	}
+	if k.ProtectionLevel != "" {
+		level := types.ProtectionLevelEnum(k.ProtectionLevel)
+		req.ProtectionLevel = &level
+	}
+	return req
+}
+
+// Actions of a plan change
+const (
+	ActionCreate    = "create"
+	ActionRename    = "rename"
+	ActionUnchanged = "unchanged"
+)
+
+// Change is the change planned for a key of the manifest.
+type Change struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	// Id is the ID of the matching domain key, or of the created key once applied.
+	Id *uuid.UUID `json:"id,omitempty"`
+	// CurrentName is the name of the domain key to rename.
+	CurrentName string `json:"currentName,omitempty"`
+	// Drift lists the differences with the domain key which cannot be fixed.
+	Drift []string `json:"drift,omitempty"`
+	// Key is the manifest key.
+	Key *Key `json:"-"`
+}
+
+// Plan compares the manifest with the existing domain keys, and returns the change of each manifest key.
+// The destroyed keys are ignored.
+func (m *Manifest) Plan(existing []types.GetServiceKeyResponse) []Change {
+	var keys []types.GetServiceKeyResponse
+	for _, k := range existing {
+		if st := state(&k); st != types.KeyStatesDestroyed && st != types.KeyStatesDestroyedCompromised {
+			keys = append(keys, k)
+		}
+	}
+
+	changes := make([]Change, 0, len(m.Keys))
+	for i := range m.Keys {
+		k := &m.Keys[i]
+		change := Change{Action: ActionCreate, Name: k.Name, Key: k}
+		var matches []types.GetServiceKeyResponse
+		for _, e := range keys {
+			if k.Id != "" && e.Id.String() == k.Id || k.Id == "" && e.Name == k.Name {
+				matches = append(matches, e)
+			}
+		}
+		switch len(matches) {
+		case 0:
+		case 1:
+			current := matches[0]
+			change.Id = &current.Id
+			change.Action = ActionUnchanged
+			if current.Name != k.Name {
+				change.Action = ActionRename
+				change.CurrentName = current.Name
+			}
+			change.Drift = k.drift(&current)
+		default:
+			change.Action = ActionUnchanged
+			change.Drift = []string{fmt.Sprintf("%d keys are named %q, set the key ID in the manifest", len(matches), k.Name)}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// drift returns the differences between the manifest key and the domain key.
+func (k *Key) drift(current *types.GetServiceKeyResponse) []string {
+	var drift []string
+	want := k.Request()
+	if current.Type != *want.Type {
+		drift = append(drift, fmt.Sprintf("type is %s, expected %s", current.Type, *want.Type))
+	}
+	if want.Size != nil && current.Size != nil && *current.Size != *want.Size {
+		drift = append(drift, fmt.Sprintf("size is %d, expected %d", *current.Size, *want.Size))
+	}
+	if want.Curve != nil && current.Curve != nil && *current.Curve != *want.Curve {
+		drift = append(drift, fmt.Sprintf("curve is %s, expected %s", *current.Curve, *want.Curve))
+	}
+	var usage []string
+	if current.Operations != nil {
+		for _, op := range *current.Operations {
+			usage = append(usage, string(op))
+		}
+	}
+	wantUsage := slices.Clone(k.Usage)
+	slices.Sort(usage)
+	slices.Sort(wantUsage)
+	if !slices.Equal(usage, wantUsage) {
+		drift = append(drift, fmt.Sprintf("usage is [%s], expected [%s]", strings.Join(usage, ","), strings.Join(wantUsage, ",")))
+	}
+	if want.ProtectionLevel != nil && current.ProtectionLevel != "" && current.ProtectionLevel != *want.ProtectionLevel {
+		drift = append(drift, fmt.Sprintf("protection level is %s, expected %s", current.ProtectionLevel, *want.ProtectionLevel))
+	}
+	if st := state(current); st != "" && st != types.KeyStatesActive {
+		drift = append(drift, fmt.Sprintf("state is %s, expected active", st))
+	}
+	return drift
+}
+
+func state(key *types.GetServiceKeyResponse) types.KeyStates {
+	if key.Attributes == nil || *key.Attributes == nil {
+		return ""
+	}
+	st, _ := (*key.Attributes)["state"].(string)
+	return types.KeyStates(st)
+}
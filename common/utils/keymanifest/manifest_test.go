@@ -0,0 +1,124 @@
+package keymanifest
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ovh/okms-cli/common/utils"
+	"github.com/ovh/okms-sdk-go/types"
+	"github.com/stretchr/testify/require"
+)
+
+const testManifest = `
+keys:
+  - name: app-encryption
+    type: oct
+    usage: [encrypt, decrypt]
+  - name: app-signing
+    id: 0195A0B5-0000-7000-8000-000000000001
+    type: RSA
+    size: 3072
+    usage: [sign, verify]
+    protectionLevel: hsm
+  - name: app-ecdsa
+    type: ec
+    usage: [sign, verify]
+    extractable: true
+    context: app
+`
+
+func existingKey(id, name string, typ types.KeyTypes, state types.KeyStates, ops ...types.CryptographicUsages) types.GetServiceKeyResponse {
+	return types.GetServiceKeyResponse{
+		Id:         uuid.MustParse(id),
+		Name:       name,
+		Type:       typ,
+		Operations: &ops,
+		Attributes: &map[string]any{"state": string(state)},
+	}
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(testManifest))
+	require.NoError(t, err)
+	require.Len(t, m.Keys, 3)
+	require.EqualValues(t, 256, m.Keys[0].Size)
+	require.Equal(t, "0195a0b5-0000-7000-8000-000000000001", m.Keys[1].Id)
+	require.Equal(t, "rsa", m.Keys[1].Type)
+	require.Equal(t, "HSM", m.Keys[1].ProtectionLevel)
+	require.Equal(t, "P-256", m.Keys[2].Curve)
+
+	req := m.Keys[1].Request()
+	require.Equal(t, types.RSA, *req.Type)
+	require.EqualValues(t, 3072, *req.Size)
+	require.Nil(t, req.Curve)
+	require.Equal(t, "app-signing", *req.Context)
+	require.Equal(t, "0195a0b5-0000-7000-8000-000000000001", *req.Id)
+	require.Equal(t, []types.CryptographicUsages{types.Sign, types.Verify}, *req.Operations)
+	require.Equal(t, types.HSM, *req.ProtectionLevel)
+
+	req = m.Keys[2].Request()
+	require.Equal(t, types.EC, *req.Type)
+	require.Equal(t, types.Curves("P-256"), *req.Curve)
+	require.Nil(t, req.Size)
+	require.Equal(t, "app", *req.Context)
+	require.True(t, *req.Extractable)
+}
+
+func TestParseErrors(t *testing.T) {
+	for manifest, msg := range map[string]string{
+		"keys: [{type: oct, usage: [encrypt]}]":                                                  "Key #1: Missing name",
+		"keys: [{name: a, type: des, usage: [encrypt]}]":                                         `Key "a": Invalid type`,
+		"keys: [{name: a, type: oct}]":                                                           `Key "a": Missing usage`,
+		"keys: [{name: a, type: oct, usage: [print]}]":                                           `Key "a": Invalid usage`,
+		"keys: [{name: a, type: ec, size: 256, usage: [sign]}]":                                  "The size cannot be set on ec keys",
+		"keys: [{name: a, type: ec, curve: P-192, usage: [sign]}]":                               "Invalid curve",
+		"keys: [{name: a, type: rsa, curve: P-256, usage: [sign]}]":                              "The curve cannot be set on rsa keys",
+		"keys: [{name: a, type: oct, usage: [encrypt], protectionLevel: x}]":                     "Invalid protection level",
+		"keys: [{name: a, id: x, type: oct, usage: [encrypt]}]":                                  "Invalid ID",
+		"keys: [{name: a, type: oct, usage: [encrypt], size2: 1}]":                               "field size2 not found",
+		"keys: [{name: a, type: oct, usage: [encrypt]}, {name: a, type: oct, usage: [encrypt]}]": `Duplicate key name "a"`,
+	} {
+		_, err := Parse([]byte(manifest))
+		require.ErrorContains(t, err, msg, manifest)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m, err := Parse([]byte(testManifest))
+	require.NoError(t, err)
+
+	changes := m.Plan([]types.GetServiceKeyResponse{
+		existingKey("0195a0b5-0000-7000-8000-000000000001", "old-signing", types.RSA, types.KeyStatesActive, types.Sign, types.Verify),
+		existingKey("0195a0b5-0000-7000-8000-000000000002", "app-encryption", types.RSA, types.KeyStatesDeactivated, types.Encrypt),
+		existingKey("0195a0b5-0000-7000-8000-000000000003", "app-ecdsa", types.EC, types.KeyStatesDestroyed, types.Sign, types.Verify),
+	})
+	require.Len(t, changes, 3)
+
+	require.Equal(t, ActionUnchanged, changes[0].Action)
+	require.Equal(t, []string{"type is RSA, expected oct", "usage is [encrypt], expected [decrypt,encrypt]", "state is deactivated, expected active"}, changes[0].Drift)
+
+	require.Equal(t, ActionRename, changes[1].Action)
+	require.Equal(t, "old-signing", changes[1].CurrentName)
+	require.Equal(t, "0195a0b5-0000-7000-8000-000000000001", changes[1].Id.String())
+	require.Empty(t, changes[1].Drift)
+
+	// The destroyed key is ignored
+	require.Equal(t, ActionCreate, changes[2].Action)
+	require.Nil(t, changes[2].Id)
+	require.Same(t, &m.Keys[2], changes[2].Key)
+}
+
+func TestPlanAmbiguousName(t *testing.T) {
+	m, err := Parse([]byte("keys: [{name: a, type: oct, size: 128, usage: [encrypt]}]"))
+	require.NoError(t, err)
+
+	key := existingKey("0195a0b5-0000-7000-8000-000000000001", "a", types.Oct, types.KeyStatesActive, types.Encrypt)
+	key.Size = utils.PtrTo(types.N256)
+	changes := m.Plan([]types.GetServiceKeyResponse{key})
+	require.Equal(t, []string{"size is 256, expected 128"}, changes[0].Drift)
+
+	other := existingKey("0195a0b5-0000-7000-8000-000000000002", "a", types.Oct, types.KeyStatesActive, types.Encrypt)
+	changes = m.Plan([]types.GetServiceKeyResponse{key, other})
+	require.Nil(t, changes[0].Id)
+	require.Equal(t, []string{`2 keys are named "a", set the key ID in the manifest`}, changes[0].Drift)
+}
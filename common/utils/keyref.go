@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Prefixes of the key references which are not key IDs.
+const (
+	KeyRefNamePrefix  = "name:"
+	KeyRefAliasPrefix = "alias:"
+)
+
+// IsKeyRef returns whether s is a key reference: a key ID, name:NAME or alias:ALIAS.
+func IsKeyRef(s string) bool {
+	return strings.HasPrefix(s, KeyRefNamePrefix) || strings.HasPrefix(s, KeyRefAliasPrefix) || uuid.Validate(s) == nil
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsKeyRef(t *testing.T) {
+	assert.True(t, IsKeyRef("0b1c5e3a-6f2d-4d8e-9a7b-3c2d1e0f4a5b"))
+	assert.True(t, IsKeyRef("name:ca-key"))
+	assert.True(t, IsKeyRef("alias:ca"))
+
+	assert.False(t, IsKeyRef("revoked.json"))
+	assert.False(t, IsKeyRef("./name:revoked.json"))
+	assert.False(t, IsKeyRef("ca-key"))
+	assert.False(t, IsKeyRef(""))
+}
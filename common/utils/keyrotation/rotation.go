@@ -0,0 +1,109 @@
+// Package keyrotation implements the rotation policies of the service keys, which the KMS has no concept of:
+// when a key is due for rotation, and how the keys replaced by a successor are named once retired.
+package keyrotation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// ParseDuration parses a number of days like 90d, of weeks like 2w, or any duration accepted by time.ParseDuration.
+func ParseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.ParseUint(n, 10, 16)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid duration %q", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration %q, expected a number of days like 90d, or a duration like 36h", s)
+	}
+	return d, nil
+}
+
+// FormatDuration formats a duration as a number of days when it is a whole number of days.
+func FormatDuration(d time.Duration) string {
+	if d != 0 && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
+
+// Policy is the rotation policy of a key.
+type Policy struct {
+	// RotateEvery is the maximum age of the key since its creation.
+	RotateEvery time.Duration
+	// WarnBefore is how long before its rotation a key is reported as due soon. No warning if zero.
+	WarnBefore time.Duration
+}
+
+// ParsePolicy parses and validates the durations of a policy, like 90d. The warning period may be empty.
+func ParsePolicy(rotateEvery, warnBefore string) (Policy, error) {
+	var (
+		p   Policy
+		err error
+	)
+	if p.RotateEvery, err = ParseDuration(rotateEvery); err != nil {
+		return p, err
+	}
+	if warnBefore != "" {
+		if p.WarnBefore, err = ParseDuration(warnBefore); err != nil {
+			return p, err
+		}
+	}
+	return p, p.Validate()
+}
+
+// Validate checks the durations of the policy.
+func (p Policy) Validate() error {
+	if p.RotateEvery <= 0 {
+		return errors.New("Rotation period must be positive")
+	}
+	if p.WarnBefore < 0 || p.WarnBefore >= p.RotateEvery {
+		return errors.New("Warning period must be positive and shorter than the rotation period")
+	}
+	return nil
+}
+
+// Status is the rotation status of a key.
+type Status string
+
+const (
+	StatusOk      Status = "ok"
+	StatusDueSoon Status = "due-soon"
+	StatusOverdue Status = "overdue"
+)
+
+// Check returns the rotation status of a key created at createdAt, and the date its rotation is due.
+func (p Policy) Check(createdAt, now time.Time) (Status, time.Time) {
+	dueAt := createdAt.Add(p.RotateEvery)
+	switch {
+	case !now.Before(dueAt):
+		return StatusOverdue, dueAt
+	case p.WarnBefore > 0 && !now.Before(dueAt.Add(-p.WarnBefore)):
+		return StatusDueSoon, dueAt
+	default:
+		return StatusOk, dueAt
+	}
+}
+
+const retiredSuffix = "-retired-"
+
+// RetiredName returns the name of a key retired at the given date, like app-key-retired-2026-01-31.
+func RetiredName(name string, date time.Time) string {
+	return name + retiredSuffix + date.UTC().Format(time.DateOnly)
+}
+
+// Deactivable returns whether a key retired at the given date is past its grace period.
+func Deactivable(retiredAt time.Time, gracePeriod time.Duration, now time.Time) bool {
+	return !now.Before(retiredAt.Add(gracePeriod))
+}
@@ -0,0 +1,86 @@
+package keyrotation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func date(s string) time.Time {
+	d, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"90d": 90 * day,
+		"2w":  14 * day,
+		"0d":  0,
+		"36h": 36 * time.Hour,
+	} {
+		d, err := ParseDuration(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, d, s)
+	}
+	for _, s := range []string{"", "d", "-1d", "1.5d", "90 days", "1y"} {
+		_, err := ParseDuration(s)
+		require.ErrorContains(t, err, "Invalid duration", s)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	require.Equal(t, "90d", FormatDuration(90*day))
+	require.Equal(t, "36h0m0s", FormatDuration(36*time.Hour))
+	require.Equal(t, "0s", FormatDuration(0))
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("90d", "14d")
+	require.NoError(t, err)
+	require.Equal(t, Policy{RotateEvery: 90 * day, WarnBefore: 14 * day}, p)
+
+	p, err = ParsePolicy("30d", "")
+	require.NoError(t, err)
+	require.Zero(t, p.WarnBefore)
+
+	for _, args := range [][2]string{{"0d", ""}, {"-1h", ""}, {"30d", "30d"}, {"30d", "-1h"}} {
+		_, err := ParsePolicy(args[0], args[1])
+		require.Error(t, err, args)
+	}
+	_, err = ParsePolicy("3 months", "")
+	require.ErrorContains(t, err, "Invalid duration")
+}
+
+func TestCheck(t *testing.T) {
+	p := Policy{RotateEvery: 90 * day, WarnBefore: 14 * day}
+	created := date("2026-01-01")
+
+	status, dueAt := p.Check(created, date("2026-03-01"))
+	require.Equal(t, StatusOk, status)
+	require.Equal(t, date("2026-04-01"), dueAt)
+
+	status, _ = p.Check(created, date("2026-03-18"))
+	require.Equal(t, StatusDueSoon, status)
+	status, _ = p.Check(created, date("2026-04-01"))
+	require.Equal(t, StatusOverdue, status)
+
+	status, _ = Policy{RotateEvery: 90 * day}.Check(created, date("2026-03-31"))
+	require.Equal(t, StatusOk, status)
+}
+
+func TestRetiredName(t *testing.T) {
+	name := RetiredName("app-key", time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC))
+	require.Equal(t, "app-key-retired-2026-01-31", name)
+	require.Equal(t, "app-key-retired-2026-01-31-retired-2026-05-01", RetiredName(name, date("2026-05-01")))
+}
+
+func TestDeactivable(t *testing.T) {
+	retiredAt := date("2026-01-31")
+	require.True(t, Deactivable(retiredAt, 0, retiredAt))
+	require.False(t, Deactivable(retiredAt, 7*day, date("2026-02-06")))
+	require.True(t, Deactivable(retiredAt, 7*day, date("2026-02-07")))
+}
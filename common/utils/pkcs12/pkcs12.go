@@ -0,0 +1,412 @@
+// Package pkcs12 encodes password protected PKCS#12 (RFC 7292) bundles of a private key and its certificate chain.
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+	"unicode/utf16"
+)
+
+// DefaultIterations is the default iteration count of the key derivations, matching OpenSSL's one.
+const DefaultIterations = 2048
+
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidShroudedKeyBag       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyId           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPbeWithSHAAnd3KeyDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBES2                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHmacWithSHA256       = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA1                 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+var (
+	errEmptyPassword         = errors.New("The PKCS#12 password cannot be empty")
+	errUnsupportedPrivateKey = errors.New("Unsupported private key type")
+)
+
+// Options tunes the encryption of the bundle.
+type Options struct {
+	// Legacy selects the triple DES and SHA-1 algorithms, for older systems not supporting the default
+	// AES-256-CBC, PBKDF2 and SHA-256 ones.
+	Legacy bool
+	// Iterations is the iteration count of the key derivations. Defaults to [DefaultIterations].
+	Iterations int
+	// FriendlyName, if not empty, is the alias of the key and its certificate.
+	FriendlyName string
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is the explicitly tagged content, see [explicit].
+	Content asn1.RawValue
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type safeBag struct {
+	Id asn1.ObjectIdentifier
+	// Value is the explicitly tagged bag value, see [explicit].
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	Id   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        pkix.AlgorithmIdentifier
+}
+
+// Encode returns a PKCS#12 bundle of key, its certificate cert and the CA certificates chain, protected by password.
+func Encode(password string, key crypto.PrivateKey, cert *x509.Certificate, chain []*x509.Certificate, opts Options) ([]byte, error) {
+	if password == "" {
+		return nil, errEmptyPassword
+	}
+	if opts.Iterations <= 0 {
+		opts.Iterations = DefaultIterations
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errUnsupportedPrivateKey
+	}
+
+	// The key and its certificate are linked by a local key id
+	localKeyId := sha1.Sum(cert.Raw)
+	attributes, err := bagAttributes(localKeyId[:], opts.FriendlyName)
+	if err != nil {
+		return nil, err
+	}
+
+	certBags := make([]safeBag, 0, 1+len(chain))
+	for i, c := range append([]*x509.Certificate{cert}, chain...) {
+		bag, err := newCertBag(c)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			bag.Attributes = attributes
+		}
+		certBags = append(certBags, bag)
+	}
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	certAlg, encryptedCerts, err := encrypt(password, certContents, opts)
+	if err != nil {
+		return nil, err
+	}
+	certInfo, err := newContentInfo(oidEncryptedData, encryptedData{
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: certAlg,
+			EncryptedContent:           encryptedCerts,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keyAlg, encryptedKey, err := encrypt(password, pkcs8, opts)
+	if err != nil {
+		return nil, err
+	}
+	keyBag, err := newSafeBag(oidShroudedKeyBag, encryptedPrivateKeyInfo{Algorithm: keyAlg, EncryptedData: encryptedKey})
+	if err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = attributes
+	keyContents, err := asn1.Marshal([]safeBag{keyBag})
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := newContentInfo(oidData, keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]contentInfo{certInfo, keyInfo})
+	if err != nil {
+		return nil, err
+	}
+	mac, err := computeMac(password, authSafe, opts)
+	if err != nil {
+		return nil, err
+	}
+	authSafeInfo, err := newContentInfo(oidData, authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPdu{Version: 3, AuthSafe: authSafeInfo, MacData: mac})
+}
+
+func newContentInfo(contentType asn1.ObjectIdentifier, content any) (contentInfo, error) {
+	der, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: contentType, Content: explicit(der)}, nil
+}
+
+func newSafeBag(id asn1.ObjectIdentifier, value any) (safeBag, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{Id: id, Value: explicit(der)}, nil
+}
+
+// explicit wraps der in a [0] EXPLICIT tag. Struct tags are ignored by encoding/asn1 for raw values.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func newCertBag(cert *x509.Certificate) (safeBag, error) {
+	return newSafeBag(oidCertBag, certBag{Id: oidX509Certificate, Data: cert.Raw})
+}
+
+func bagAttributes(localKeyId []byte, friendlyName string) ([]pkcs12Attribute, error) {
+	keyId, err := asn1.Marshal(localKeyId)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{Id: oidLocalKeyId, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: keyId}}}
+	if friendlyName != "" {
+		name, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(friendlyName, false)})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{Id: oidFriendlyName, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: name}})
+	}
+	return attributes, nil
+}
+
+// encrypt encrypts data with a key derived from password, and returns the encryption algorithm identifier.
+func encrypt(password string, data []byte, opts Options) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	if opts.Legacy {
+		params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: opts.Iterations})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		pwd := bmpString(password, true)
+		key := deriveKey(sha1.New, pwd, salt, 1, opts.Iterations, 24)
+		iv := deriveKey(sha1.New, pwd, salt, 2, opts.Iterations, des.BlockSize)
+		block, err := des.NewTripleDESCipher(key)
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		alg := pkix.AlgorithmIdentifier{Algorithm: oidPbeWithSHAAnd3KeyDES, Parameters: asn1.RawValue{FullBytes: params}}
+		return alg, cbcEncrypt(block, iv, data), nil
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, opts.Iterations, 32)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: opts.Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHmacWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	alg := pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+	return alg, cbcEncrypt(block, iv, data), nil
+}
+
+// cbcEncrypt encrypts the PKCS#7 padded data.
+func cbcEncrypt(block cipher.Block, iv, data []byte) []byte {
+	n := block.BlockSize() - len(data)%block.BlockSize()
+	padded := make([]byte, len(data)+n)
+	copy(padded, data)
+	for i := len(data); i < len(padded); i++ {
+		padded[i] = byte(n)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return padded
+}
+
+// computeMac computes the integrity MAC of the authenticated safe.
+func computeMac(password string, authSafe []byte, opts Options) (macData, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return macData{}, err
+	}
+	h, oid := sha256.New, oidSHA256
+	if opts.Legacy {
+		h, oid = sha1.New, oidSHA1
+	}
+	key := deriveKey(h, bmpString(password, true), salt, 3, opts.Iterations, h().Size())
+	mac := hmac.New(h, key)
+	mac.Write(authSafe)
+	return macData{
+		Mac: digestInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+			Digest:    mac.Sum(nil),
+		},
+		MacSalt:    salt,
+		Iterations: opts.Iterations,
+	}, nil
+}
+
+// deriveKey implements the PKCS#12 key derivation function of RFC 7292 appendix B.2. The id selects
+// the derived material: 1 for a key, 2 for an IV and 3 for a MAC key.
+func deriveKey(newHash func() hash.Hash, password, salt []byte, id byte, iterations, size int) []byte {
+	h := newHash()
+	u, v := h.Size(), h.BlockSize()
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	i := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h.Reset()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for range iterations - 1 {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+		if len(out) >= size {
+			break
+		}
+		// Each v bytes block of I is replaced by I + B + 1, with B the concatenation of A up to v bytes
+		b := make([]byte, v)
+		for j := range b {
+			b[j] = a[j%u]
+		}
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// bmpString encodes s in UTF-16 big endian, optionally followed by a null terminator as used by the PKCS#12
+// key derivation function.
+func bmpString(s string, terminated bool) []byte {
+	var out []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	if terminated {
+		out = append(out, 0, 0)
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/ovh/okms-cli/internal/testcerts"
+	"github.com/stretchr/testify/require"
+	xpkcs12 "golang.org/x/crypto/pkcs12"
+)
+
+func TestEncodeLegacy(t *testing.T) {
+	ca, caKey := testcerts.NewCA(t, "ca")
+
+	for name, key := range map[string]any{
+		"rsa":   func() any { k, _ := rsa.GenerateKey(rand.Reader, 2048); return k }(),
+		"ecdsa": func() any { k, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader); return k }(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			leaf := testcerts.Issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "leaf"}}, key.(crypto.Signer).Public(), ca, caKey)
+
+			// Decode supports a single certificate
+			p12, err := Encode("secret", key, leaf, nil, Options{Legacy: true})
+			require.NoError(t, err)
+			gotKey, gotCert, err := xpkcs12.Decode(p12, "secret")
+			require.NoError(t, err)
+			require.Equal(t, leaf.Raw, gotCert.Raw)
+			require.True(t, key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(gotKey))
+
+			_, _, err = xpkcs12.Decode(p12, "wrong")
+			require.ErrorIs(t, err, xpkcs12.ErrIncorrectPassword)
+
+			p12, err = Encode("secret", key, leaf, []*x509.Certificate{ca}, Options{Legacy: true, FriendlyName: "my key"})
+			require.NoError(t, err)
+			blocks, err := xpkcs12.ToPEM(p12, "secret")
+			require.NoError(t, err)
+			require.Len(t, blocks, 3)
+			var certs [][]byte
+			for _, b := range blocks {
+				if b.Type == "CERTIFICATE" {
+					certs = append(certs, b.Bytes)
+				}
+			}
+			require.Equal(t, [][]byte{leaf.Raw, ca.Raw}, certs)
+			require.Equal(t, "my key", blocks[0].Headers["friendlyName"])
+			require.Equal(t, blocks[0].Headers["localKeyId"], blocks[2].Headers["localKeyId"])
+		})
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	cert := testcerts.Issue(t, &x509.Certificate{}, key.Public(), nil, key)
+
+	_, err = Encode("", key, cert, nil, Options{})
+	require.ErrorIs(t, err, errEmptyPassword)
+	_, err = Encode("secret", "not a key", cert, nil, Options{})
+	require.ErrorIs(t, err, errUnsupportedPrivateKey)
+
+	p12, err := Encode("secret", key, cert, nil, Options{})
+	require.NoError(t, err)
+	// The default encryption is not supported by the x/crypto decoder
+	_, err = xpkcs12.ToPEM(p12, "secret")
+	require.Error(t, err)
+}
+
+func TestDeriveKey(t *testing.T) {
+	// Test vector of golang.org/x/crypto/pkcs12, for pbeWithSHAAnd3-KeyTripleDES-CBC
+	salt := []byte("\xff\xff\xff\xff\xff\xff\xff\xff")
+	key := deriveKey(sha1.New, bmpString("sesame", true), salt, 1, 2048, 24)
+	require.Equal(t, []byte("\x7c\xd9\xfd\x3e\x2b\x3b\xe7\x69\x1a\x44\xe3\xbe\xf0\xf9\xea\x0f\xb9\xb8\x97\xd4\xe3\x25\xd9\xd1"), key)
+}
@@ -0,0 +1,354 @@
+// Package tsp implements the server side of the Time-Stamp Protocol (RFC 3161), with
+// time-stamp tokens signed by a [crypto.Signer].
+package tsp
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/ovh/okms-cli/common/utils/cms"
+)
+
+const (
+	// CONTENT_TYPE_QUERY is the media type of time-stamp requests.
+	CONTENT_TYPE_QUERY = "application/timestamp-query"
+	// CONTENT_TYPE_REPLY is the media type of time-stamp responses.
+	CONTENT_TYPE_REPLY = "application/timestamp-reply"
+)
+
+var (
+	// OIDContentTypeTSTInfo is the content type of time-stamp tokens.
+	OIDContentTypeTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+	oidAttributeSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+)
+
+// PKI status values, see RFC 3161 section 2.4.2.
+const (
+	StatusGranted                = 0
+	StatusGrantedWithMods        = 1
+	StatusRejection              = 2
+	StatusWaiting                = 3
+	StatusRevocationWarning      = 4
+	StatusRevocationNotification = 5
+)
+
+// PKI failure info bits, see RFC 3161 section 2.4.2.
+const (
+	FailureBadAlg              = 0
+	FailureBadRequest          = 2
+	FailureBadDataFormat       = 5
+	FailureTimeNotAvailable    = 14
+	FailureUnacceptedPolicy    = 15
+	FailureUnacceptedExtension = 16
+	FailureAddInfoNotAvailable = 17
+	FailureSystemFailure       = 25
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"` // SEQUENCE OF UTF8String
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional,default:false"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type essCertIDv2 struct {
+	// HashAlgorithm is omitted as it defaults to SHA-256.
+	CertHash []byte
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// Request is a parsed time-stamp request.
+type Request struct {
+	HashAlgorithm crypto.Hash
+	HashedMessage []byte
+	Policy        asn1.ObjectIdentifier
+	Nonce         *big.Int
+	CertReq       bool
+	// Extensions are the request extensions. None is supported, so requests having some are rejected.
+	Extensions []pkix.Extension
+}
+
+// ParseRequest parses a DER encoded TimeStampReq. An unsupported hash algorithm is reported as a [*RequestError].
+func ParseRequest(der []byte) (*Request, error) {
+	var req timeStampReq
+	if rest, err := asn1.Unmarshal(der, &req); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("Trailing data after time-stamp request")
+	}
+	if req.Version != 1 {
+		return nil, fmt.Errorf("Unsupported time-stamp request version %d", req.Version)
+	}
+	hash, err := cms.HashFromDigestAlgorithm(req.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, &RequestError{FailureInfo: FailureBadAlg, Message: err.Error()}
+	}
+	return &Request{
+		HashAlgorithm: hash,
+		HashedMessage: req.MessageImprint.HashedMessage,
+		Policy:        req.ReqPolicy,
+		Nonce:         req.Nonce,
+		CertReq:       req.CertReq,
+		Extensions:    req.Extensions,
+	}, nil
+}
+
+// Marshal returns the DER encoding of the request as a TimeStampReq.
+func (r *Request) Marshal() ([]byte, error) {
+	alg, err := cms.DigestAlgorithmIdentifier(r.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: messageImprint{HashAlgorithm: alg, HashedMessage: r.HashedMessage},
+		ReqPolicy:      r.Policy,
+		Nonce:          r.Nonce,
+		CertReq:        r.CertReq,
+		Extensions:     r.Extensions,
+	})
+}
+
+// RequestError is a request failure reported to the client in a rejection response.
+type RequestError struct {
+	FailureInfo int
+	Message     string
+}
+
+func (err *RequestError) Error() string {
+	return err.Message
+}
+
+// Authority issues time-stamp tokens.
+type Authority struct {
+	// Signer signs the time-stamp tokens.
+	Signer crypto.Signer
+	// Certificate is the TSA certificate matching Signer.
+	Certificate *x509.Certificate
+	// Chain are the issuers certificates of Certificate, embedded in tokens when requested.
+	Chain []*x509.Certificate
+	// Policy is the TSA policy used for requests not asking for a specific one.
+	Policy asn1.ObjectIdentifier
+	// AcceptedPolicies are additional policies that requests may ask for.
+	AcceptedPolicies []asn1.ObjectIdentifier
+	// Accuracy is the accuracy of the time source. Zero means unspecified.
+	Accuracy time.Duration
+	// SerialNumber generates unique serial numbers for the tokens.
+	SerialNumber func() *big.Int
+	// Now returns the current time. Defaults to [time.Now].
+	Now func() time.Time
+}
+
+// CheckCertificate checks that the TSA certificate has the time stamping extended key usage.
+func (tsa *Authority) CheckCertificate() error {
+	if len(tsa.Certificate.ExtKeyUsage) != 1 || tsa.Certificate.ExtKeyUsage[0] != x509.ExtKeyUsageTimeStamping {
+		return errors.New("The TSA certificate must have the time stamping extended key usage only")
+	}
+	return nil
+}
+
+// Sign returns a DER encoded time-stamp token (a CMS SignedData containing a TSTInfo) for req.
+// If the request cannot be accepted, the returned error is a [*RequestError].
+func (tsa *Authority) Sign(req *Request) ([]byte, error) {
+	policy := tsa.Policy
+	if req.Policy != nil {
+		if !req.Policy.Equal(tsa.Policy) && !slices.ContainsFunc(tsa.AcceptedPolicies, req.Policy.Equal) {
+			return nil, &RequestError{FailureInfo: FailureUnacceptedPolicy, Message: fmt.Sprintf("Unaccepted policy %s", req.Policy)}
+		}
+		policy = req.Policy
+	}
+	// RFC 3161 section 2.4.1: the extensions that are not understood must be rejected
+	if len(req.Extensions) > 0 {
+		return nil, &RequestError{FailureInfo: FailureUnacceptedExtension, Message: fmt.Sprintf("Unaccepted extension %s", req.Extensions[0].Id)}
+	}
+	if req.HashAlgorithm.Size() != len(req.HashedMessage) {
+		return nil, &RequestError{FailureInfo: FailureBadDataFormat, Message: "Invalid message imprint length"}
+	}
+	hashAlg, err := cms.DigestAlgorithmIdentifier(req.HashAlgorithm)
+	if err != nil {
+		return nil, &RequestError{FailureInfo: FailureBadAlg, Message: err.Error()}
+	}
+
+	now := time.Now
+	if tsa.Now != nil {
+		now = tsa.Now
+	}
+	info := tstInfo{
+		Version:        1,
+		Policy:         policy,
+		MessageImprint: messageImprint{HashAlgorithm: hashAlg, HashedMessage: req.HashedMessage},
+		SerialNumber:   tsa.SerialNumber(),
+		GenTime:        now().UTC().Truncate(time.Second),
+		Nonce:          req.Nonce,
+	}
+	if tsa.Accuracy > 0 {
+		info.Accuracy = accuracy{
+			Seconds: int(tsa.Accuracy / time.Second),
+			Millis:  int(tsa.Accuracy % time.Second / time.Millisecond),
+			Micros:  int(tsa.Accuracy % time.Millisecond / time.Microsecond),
+		}
+	}
+	content, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	certHash := sha256.Sum256(tsa.Certificate.Raw)
+	return cms.Sign(content, tsa.Certificate, tsa.Signer, cms.SignOptions{
+		ContentType:    OIDContentTypeTSTInfo,
+		Certificates:   tsa.Chain,
+		NoCertificates: !req.CertReq,
+		Attributes: []cms.Attribute{{
+			Type:  oidAttributeSigningCertificateV2,
+			Value: signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash[:]}}},
+		}},
+	})
+}
+
+// Respond returns a DER encoded TimeStampResp for the DER encoded TimeStampReq.
+// Invalid or unaccepted requests get a rejection response. An error is returned only
+// if the response cannot be created.
+func (tsa *Authority) Respond(reqDer []byte) ([]byte, error) {
+	var reqErr *RequestError
+	req, err := ParseRequest(reqDer)
+	if errors.As(err, &reqErr) {
+		return RejectionResponse(reqErr.FailureInfo, reqErr.Message)
+	} else if err != nil {
+		return RejectionResponse(FailureBadDataFormat, err.Error())
+	}
+	token, err := tsa.Sign(req)
+	if errors.As(err, &reqErr) {
+		return RejectionResponse(reqErr.FailureInfo, reqErr.Message)
+	} else if err != nil {
+		return nil, err
+	}
+	return GrantedResponse(token)
+}
+
+// GrantedResponse returns a DER encoded TimeStampResp with a granted status and the given token.
+func GrantedResponse(token []byte) ([]byte, error) {
+	return asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: StatusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+}
+
+// RejectionResponse returns a DER encoded TimeStampResp with a rejection status.
+func RejectionResponse(failureInfo int, msg string) ([]byte, error) {
+	failInfo := asn1.BitString{Bytes: make([]byte, failureInfo/8+1), BitLength: failureInfo + 1}
+	failInfo.Bytes[failureInfo/8] |= 0x80 >> (failureInfo % 8)
+	status := pkiStatusInfo{Status: StatusRejection, FailInfo: failInfo}
+	if msg != "" {
+		status.StatusString = []asn1.RawValue{{Tag: asn1.TagUTF8String, Bytes: []byte(msg)}}
+	}
+	return asn1.Marshal(timeStampResp{Status: status})
+}
+
+// Response is a parsed time-stamp response.
+type Response struct {
+	Status       int
+	StatusString []string
+	// Token is the parsed time-stamp token, nil if the request was not granted.
+	Token *cms.SignedData
+	// Info is the parsed content of the token, nil if the request was not granted.
+	Info *Info
+}
+
+// Info is the content of a time-stamp token.
+type Info struct {
+	Policy        asn1.ObjectIdentifier
+	HashAlgorithm crypto.Hash
+	HashedMessage []byte
+	SerialNumber  *big.Int
+	GenTime       time.Time
+	Nonce         *big.Int
+}
+
+// ParseResponse parses a DER encoded TimeStampResp. It does not verify the token's signature.
+func ParseResponse(der []byte) (*Response, error) {
+	var resp timeStampResp
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("Trailing data after time-stamp response")
+	}
+	result := &Response{Status: resp.Status.Status}
+	for _, str := range resp.Status.StatusString {
+		result.StatusString = append(result.StatusString, string(str.Bytes))
+	}
+	if len(resp.TimeStampToken.FullBytes) == 0 {
+		return result, nil
+	}
+	token, err := cms.Parse(resp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !token.ContentType.Equal(OIDContentTypeTSTInfo) {
+		return nil, fmt.Errorf("Unexpected time-stamp token content type %s", token.ContentType)
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(token.Content, &info); err != nil {
+		return nil, err
+	}
+	hash, err := cms.HashFromDigestAlgorithm(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	result.Token = token
+	result.Info = &Info{
+		Policy:        info.Policy,
+		HashAlgorithm: hash,
+		HashedMessage: info.MessageImprint.HashedMessage,
+		SerialNumber:  info.SerialNumber,
+		GenTime:       info.GenTime,
+		Nonce:         info.Nonce,
+	}
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package tsp
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ovh/okms-cli/internal/testcerts"
+	"github.com/stretchr/testify/require"
+)
+
+var testPolicy = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+
+func newTestAuthority(t *testing.T) *Authority {
+	t.Helper()
+	key := testcerts.NewKey(t)
+	ekuValue, err := asn1.Marshal([]asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 8}})
+	require.NoError(t, err)
+	cert := testcerts.Issue(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "test tsa"},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 37}, Critical: true, Value: ekuValue}},
+	}, key.Public(), nil, key)
+
+	tsa := &Authority{
+		Signer:       key,
+		Certificate:  cert,
+		Policy:       testPolicy,
+		Accuracy:     1500 * time.Millisecond,
+		SerialNumber: func() *big.Int { return big.NewInt(1234) },
+		Now:          func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) },
+	}
+	require.NoError(t, tsa.CheckCertificate())
+	return tsa
+}
+
+func TestRespondGranted(t *testing.T) {
+	tsa := newTestAuthority(t)
+	digest := sha256.Sum256([]byte("hello world"))
+	for _, certReq := range []bool{true, false} {
+		reqDer, err := (&Request{HashAlgorithm: crypto.SHA256, HashedMessage: digest[:], Nonce: big.NewInt(99), CertReq: certReq}).Marshal()
+		require.NoError(t, err)
+
+		respDer, err := tsa.Respond(reqDer)
+		require.NoError(t, err)
+		resp, err := ParseResponse(respDer)
+		require.NoError(t, err)
+		require.Equal(t, StatusGranted, resp.Status)
+		require.True(t, resp.Info.Policy.Equal(testPolicy))
+		require.Equal(t, crypto.SHA256, resp.Info.HashAlgorithm)
+		require.Equal(t, digest[:], resp.Info.HashedMessage)
+		require.Equal(t, int64(1234), resp.Info.SerialNumber.Int64())
+		require.Equal(t, int64(99), resp.Info.Nonce.Int64())
+		require.True(t, tsa.Now().Equal(resp.Info.GenTime))
+
+		if certReq {
+			require.Len(t, resp.Token.Certificates, 1)
+		} else {
+			require.Empty(t, resp.Token.Certificates)
+			resp.Token.AddCertificates(tsa.Certificate)
+		}
+		require.NoError(t, resp.Token.Verify(nil))
+	}
+}
+
+func TestRespondRejected(t *testing.T) {
+	tsa := newTestAuthority(t)
+	digest := sha256.Sum256([]byte("hello world"))
+
+	for name, req := range map[string]*Request{
+		"policy": {HashAlgorithm: crypto.SHA256, HashedMessage: digest[:], Policy: asn1.ObjectIdentifier{1, 2, 3}},
+		"length": {HashAlgorithm: crypto.SHA384, HashedMessage: digest[:]},
+		"extension": {HashAlgorithm: crypto.SHA256, HashedMessage: digest[:], Extensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 2, 3}, Value: []byte{0x05, 0x00}},
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reqDer, err := req.Marshal()
+			require.NoError(t, err)
+			respDer, err := tsa.Respond(reqDer)
+			require.NoError(t, err)
+			resp, err := ParseResponse(respDer)
+			require.NoError(t, err)
+			require.Equal(t, StatusRejection, resp.Status)
+			require.Nil(t, resp.Token)
+		})
+	}
+
+	respDer, err := tsa.Respond([]byte("garbage"))
+	require.NoError(t, err)
+	resp, err := ParseResponse(respDer)
+	require.NoError(t, err)
+	require.Equal(t, StatusRejection, resp.Status)
+}
+
+func TestRequestErrors(t *testing.T) {
+	tsa := newTestAuthority(t)
+	digest := sha256.Sum256([]byte("hello world"))
+	var reqErr *RequestError
+
+	_, err := tsa.Sign(&Request{HashAlgorithm: crypto.SHA256, HashedMessage: digest[:], Extensions: []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{1, 2, 3}, Value: []byte{0x05, 0x00}},
+	}})
+	require.ErrorAs(t, err, &reqErr)
+	require.Equal(t, FailureUnacceptedExtension, reqErr.FailureInfo)
+
+	md5 := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}}
+	reqDer, err := asn1.Marshal(timeStampReq{Version: 1, MessageImprint: messageImprint{HashAlgorithm: md5, HashedMessage: make([]byte, 16)}})
+	require.NoError(t, err)
+	_, err = ParseRequest(reqDer)
+	require.ErrorAs(t, err, &reqErr)
+	require.Equal(t, FailureBadAlg, reqErr.FailureInfo)
+}
@@ -0,0 +1,90 @@
+package x509utils
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseCertificates parses all the certificates found in data. data is either a
+// sequence of PEM "CERTIFICATE" blocks, or DER encoded certificates.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if blocks, err := PemDecodeAll(data); err == nil {
+		var certs []*x509.Certificate
+		for _, block := range blocks {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) > 0 {
+			return certs, nil
+		}
+	}
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	return nil, errors.New("No certificate found")
+}
+
+// LoadCertificates reads and parses all the certificates in the given file.
+func LoadCertificates(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCertificates(data)
+}
+
+// OrderChain returns the chain of cert, starting with cert and followed by its issuers found in
+// certs, up to a self-signed root or a certificate whose issuer is not in certs.
+func OrderChain(cert *x509.Certificate, certs []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{cert}
+	for current := cert; !IsSelfSigned(current); {
+		var issuer *x509.Certificate
+		for _, c := range certs {
+			if !slices.Contains(chain, c) && current.CheckSignatureFrom(c) == nil {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain
+}
+
+// IsSelfSigned returns true if cert is issued and signed by itself.
+func IsSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// ParseObjectIdentifier parses an OID in dotted decimal notation (ex: 1.3.6.1.5.5.7.3.1).
+func ParseObjectIdentifier(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("Invalid OID %q", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid OID %q", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
@@ -0,0 +1,29 @@
+package x509utils
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/ovh/okms-cli/internal/testcerts"
+)
+
+func TestOrderChain(t *testing.T) {
+	root, rootKey := testcerts.NewCA(t, "root")
+	inter1Key, inter2Key := testcerts.NewKey(t), testcerts.NewKey(t)
+	inter1 := testcerts.Issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "intermediate 1"}, IsCA: true, BasicConstraintsValid: true}, inter1Key.Public(), root, rootKey)
+	inter2 := testcerts.Issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "intermediate 2"}, IsCA: true, BasicConstraintsValid: true}, inter2Key.Public(), inter1, inter1Key)
+	leaf, _ := testcerts.NewLeaf(t, 2, inter2, inter2Key)
+	other, _ := testcerts.NewCA(t, "other")
+
+	chain := OrderChain(leaf, []*x509.Certificate{root, other, inter1, inter2})
+	if len(chain) != 4 || chain[0] != leaf || chain[1] != inter2 || chain[2] != inter1 || chain[3] != root {
+		t.Fatalf("unexpected chain %v", chain)
+	}
+	if chain := OrderChain(leaf, []*x509.Certificate{inter2, root}); len(chain) != 2 {
+		t.Fatalf("chain should stop at the missing issuer, got %d certificates", len(chain))
+	}
+	if chain := OrderChain(root, []*x509.Certificate{root, inter1}); len(chain) != 1 {
+		t.Fatalf("chain of a root should be the root only, got %d certificates", len(chain))
+	}
+}
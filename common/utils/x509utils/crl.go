@@ -0,0 +1,76 @@
+package x509utils
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrNoRevocationList is returned by [CheckRevocation] when none of the CRLs is issued by the certificate's issuer.
+var ErrNoRevocationList = errors.New("No CRL issued by the certificate issuer")
+
+// ParseRevocationLists parses all the CRLs found in data. data is either a
+// sequence of PEM "X509 CRL" blocks, or a DER encoded CRL.
+func ParseRevocationLists(data []byte) ([]*x509.RevocationList, error) {
+	var crls []*x509.RevocationList
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) > 0 {
+		return crls, nil
+	}
+	if crl, err := x509.ParseRevocationList(data); err == nil {
+		return []*x509.RevocationList{crl}, nil
+	}
+	return nil, errors.New("No CRL found")
+}
+
+// LoadRevocationLists reads and parses all the CRLs in the given file.
+func LoadRevocationLists(file string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRevocationLists(data)
+}
+
+// CheckRevocation checks that cert is not revoked by the CRLs signed by issuer. It fails if one of these
+// CRLs is expired at the given time, and returns [ErrNoRevocationList] if none is signed by issuer.
+func CheckRevocation(cert, issuer *x509.Certificate, crls []*x509.RevocationList, at time.Time) error {
+	found := false
+	for _, crl := range crls {
+		if crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		found = true
+		if !crl.NextUpdate.IsZero() && at.After(crl.NextUpdate) {
+			return fmt.Errorf("The CRL of %s expired at %s", issuer.Subject, crl.NextUpdate.Format(time.RFC3339))
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) != 0 || entry.RevocationTime.After(at) {
+				continue
+			}
+			return fmt.Errorf("Certificate %s was revoked at %s (%s)", cert.Subject, entry.RevocationTime.Format(time.RFC3339), RevocationReasonString(entry.ReasonCode))
+		}
+	}
+	if !found {
+		return ErrNoRevocationList
+	}
+	return nil
+}
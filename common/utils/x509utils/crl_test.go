@@ -0,0 +1,56 @@
+package x509utils
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ovh/okms-cli/internal/testcerts"
+)
+
+func TestCheckRevocation(t *testing.T) {
+	ca, caKey := testcerts.NewCA(t, "ca")
+	other, _ := testcerts.NewCA(t, "other")
+	now := time.Now()
+	crlDer, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Hour),
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(10), RevocationTime: now.Add(-time.Minute), ReasonCode: 1},
+		},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDer})
+	crls, err := ParseRevocationLists(append(data, data...))
+	if err != nil || len(crls) != 2 {
+		t.Fatalf("unexpected CRLs: %v, %v", crls, err)
+	}
+	if crls, err = ParseRevocationLists(crlDer); err != nil || len(crls) != 1 {
+		t.Fatalf("unexpected DER CRLs: %v, %v", crls, err)
+	}
+
+	revoked := &x509.Certificate{SerialNumber: big.NewInt(10)}
+	valid := &x509.Certificate{SerialNumber: big.NewInt(11)}
+	if err := CheckRevocation(revoked, ca, crls, now); err == nil {
+		t.Fatal("expected revoked certificate")
+	}
+	if err := CheckRevocation(revoked, ca, crls, now.Add(-30*time.Minute)); err != nil {
+		t.Fatalf("certificate was not yet revoked: %v", err)
+	}
+	if err := CheckRevocation(valid, ca, crls, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := CheckRevocation(valid, ca, crls, now.Add(2*time.Hour)); err == nil {
+		t.Fatal("expected expired CRL")
+	}
+	if err := CheckRevocation(valid, other, crls, now); !errors.Is(err, ErrNoRevocationList) {
+		t.Fatalf("expected no CRL error, got %v", err)
+	}
+}
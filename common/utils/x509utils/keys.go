@@ -0,0 +1,84 @@
+package x509utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// HashForPublicKey returns the hash function to use when signing with the private key
+// matching pub. ECDSA keys use the hash whose size matches the curve, and RSA keys use SHA-256.
+func HashForPublicKey(pub crypto.PublicKey) (crypto.Hash, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return crypto.SHA256, nil
+		case elliptic.P384():
+			return crypto.SHA384, nil
+		case elliptic.P521():
+			return crypto.SHA512, nil
+		}
+		return 0, fmt.Errorf("Unsupported curve %s", k.Curve.Params().Name)
+	default:
+		return 0, fmt.Errorf("Unsupported key type %T", pub)
+	}
+}
+
+// PublicKeysEqual reports whether a and b are the same public key.
+func PublicKeysEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// SignatureAlgorithmFor returns the x509 signature algorithm to use with the given public key
+// and hash function. RSA keys use PKCS#1 v1.5 signatures.
+func SignatureAlgorithmFor(pub crypto.PublicKey, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("Unsupported key type %T", pub)
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("Unsupported hash algorithm %s", hash)
+}
+
+// ParsePrivateKey parses a PEM or DER encoded PKCS#8, PKCS#1 or SEC 1 private key.
+func ParsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(data); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("Failed to parse the private key, expected a PKCS#8, PKCS#1 or SEC 1 key")
+}
@@ -0,0 +1,51 @@
+package x509utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPkcs8, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	ecPkcs8, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	ecSec1, _ := x509.MarshalECPrivateKey(ecKey)
+
+	for name, tc := range map[string]struct {
+		data []byte
+		key  crypto.PrivateKey
+	}{
+		"pkcs8 pem":  {pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaPkcs8}), rsaKey},
+		"pkcs8 der":  {ecPkcs8, ecKey},
+		"pkcs1 pem":  {pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), rsaKey},
+		"sec1 pem":   {pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecSec1}), ecKey},
+		"sec1 der":   {ecSec1, ecKey},
+		"pkcs1 der":  {x509.MarshalPKCS1PrivateKey(rsaKey), rsaKey},
+		"rsa pkcs8":  {rsaPkcs8, rsaKey},
+		"ecdsa pem8": {pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecPkcs8}), ecKey},
+	} {
+		key, err := ParsePrivateKey(tc.data)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !tc.key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(key) {
+			t.Fatalf("%s: unexpected key", name)
+		}
+	}
+	if _, err := ParsePrivateKey([]byte("garbage")); err == nil {
+		t.Fatal("garbage should not parse")
+	}
+}
@@ -0,0 +1,61 @@
+package x509utils
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"strings"
+)
+
+var (
+	oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	// Microsoft UPN otherName, used by OKMS certificates to bind them to a domain.
+	oidOtherNameUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// OkmsDomainId returns the OKMS domain id found in the "okms.domain:" otherName of the subject
+// alternative names extension, if any, in the given certificate or certificate request extensions.
+func OkmsDomainId(extensions []pkix.Extension) (string, error) {
+	for _, ext := range extensions {
+		// See https://datatracker.ietf.org/doc/html/rfc5280#section-4.2.1.6
+		if !ext.Id.Equal(oidExtensionSubjectAltName) {
+			continue
+		}
+		var seq asn1.RawValue
+		_, err := asn1.Unmarshal(ext.Value, &seq)
+		if err != nil {
+			return "", err
+		}
+		for rest := seq.Bytes; len(rest) > 0; {
+			var val asn1.RawValue
+			rest, err = asn1.Unmarshal(rest, &val)
+			if err != nil {
+				return "", err
+			}
+			if val.Tag != 0 {
+				continue
+			}
+
+			var oid asn1.ObjectIdentifier
+			rem, err := asn1.Unmarshal(val.Bytes, &oid)
+			if err != nil {
+				return "", err
+			}
+			if !oid.Equal(oidOtherNameUPN) {
+				continue
+			}
+			if _, err = asn1.Unmarshal(rem, &val); err != nil {
+				return "", err
+			}
+			var othername string
+			if _, err := asn1.Unmarshal(val.Bytes, &othername); err != nil {
+				return "", err
+			}
+			prefix := "okms.domain:"
+			if strings.HasPrefix(othername, prefix) {
+				return othername[len(prefix):], nil
+			}
+		}
+	}
+	return "", errors.New("No OKMS domain id found")
+}
@@ -0,0 +1,72 @@
+package x509utils
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func sanExtension(t *testing.T, names ...asn1.RawValue) pkix.Extension {
+	t.Helper()
+	value, err := asn1.Marshal(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidExtensionSubjectAltName, Value: value}
+}
+
+func otherName(t *testing.T, oid asn1.ObjectIdentifier, value string) asn1.RawValue {
+	t.Helper()
+	utf8, err := asn1.MarshalWithParams(value, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, err := asn1.Marshal(struct {
+		Id    asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}{oid, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: utf8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(bytes, &seq); err != nil {
+		t.Fatal(err)
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: seq.Bytes}
+}
+
+func TestOkmsDomainId(t *testing.T) {
+	dns := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("example.com")}
+	exts := []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 14}, Value: []byte{4, 1, 1}},
+		sanExtension(t, dns, otherName(t, asn1.ObjectIdentifier{1, 2, 3}, "okms.domain:wrong"), otherName(t, oidOtherNameUPN, "okms.domain:1234")),
+	}
+	id, err := OkmsDomainId(exts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1234" {
+		t.Fatalf("unexpected domain id %q", id)
+	}
+
+	if _, err := OkmsDomainId([]pkix.Extension{sanExtension(t, dns, otherName(t, oidOtherNameUPN, "user@example.com"))}); err == nil {
+		t.Fatal("expected error without OKMS domain")
+	}
+	if _, err := OkmsDomainId(nil); err == nil {
+		t.Fatal("expected error without extensions")
+	}
+}
+
+func TestPemDecodeAll(t *testing.T) {
+	data := []byte("header\n-----BEGIN CERTIFICATE-----\nAQID\n-----END CERTIFICATE-----\ntext\n-----BEGIN X509 CRL-----\nBAUG\n-----END X509 CRL-----\n")
+	blocks, err := PemDecodeAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Type != "CERTIFICATE" || blocks[1].Type != "X509 CRL" {
+		t.Fatalf("unexpected blocks %v", blocks)
+	}
+	if _, err := PemDecodeAll([]byte{0x30, 0x03, 0x02, 0x01, 0x01}); err == nil {
+		t.Fatal("expected error on DER data")
+	}
+}
@@ -12,3 +12,20 @@ func PemDecode(data []byte) (p *pem.Block, err error) {
 	}
 	return
 }
+
+// PemDecodeAll decodes all the PEM blocks found in data, ignoring the text between them.
+func PemDecodeAll(data []byte) ([]*pem.Block, error) {
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New("Failed to decode PEM blocks")
+	}
+	return blocks, nil
+}
@@ -0,0 +1,46 @@
+package x509utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// revocationReasons are the CRL reason codes names, as defined in RFC 5280 section 5.3.1.
+var revocationReasons = map[int]string{
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
+}
+
+// ParseRevocationReason parses a CRL reason code given either by its RFC 5280 name
+// (case insensitive, ex: keyCompromise) or by its numerical value.
+func ParseRevocationReason(s string) (int, error) {
+	if code, err := strconv.Atoi(s); err == nil {
+		if _, ok := revocationReasons[code]; ok {
+			return code, nil
+		}
+		return 0, fmt.Errorf("Invalid revocation reason code %d", code)
+	}
+	for code, name := range revocationReasons {
+		if strings.EqualFold(name, s) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid revocation reason %q", s)
+}
+
+// RevocationReasonString returns the RFC 5280 name of a CRL reason code.
+func RevocationReasonString(code int) string {
+	if name, ok := revocationReasons[code]; ok {
+		return name
+	}
+	return strconv.Itoa(code)
+}
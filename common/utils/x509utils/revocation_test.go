@@ -0,0 +1,23 @@
+package x509utils
+
+import "testing"
+
+func TestParseRevocationReason(t *testing.T) {
+	for in, want := range map[string]int{"keyCompromise": 1, "KEYCOMPROMISE": 1, "superseded": 4, "9": 9, "0": 0} {
+		code, err := ParseRevocationReason(in)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", in, err)
+		}
+		if code != want {
+			t.Fatalf("unexpected code for %q: got %d want %d", in, code, want)
+		}
+		if name := RevocationReasonString(code); name == "" {
+			t.Fatalf("empty name for code %d", code)
+		}
+	}
+	for _, in := range []string{"", "7", "11", "compromised"} {
+		if _, err := ParseRevocationReason(in); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
@@ -29,21 +29,12 @@ func (b *BigInt) UnmarshalJSON(data []byte) error {
 	// try string
 	var s string
 	if err := json.Unmarshal(data, &s); err == nil {
-		// support decimal and optional 0x/0X-prefixed hexadecimal inputs
-		if strings.HasPrefix(strings.ToLower(s), "0x") {
-			z, ok := new(big.Int).SetString(s[2:], 16)
-			if !ok {
-				return fmt.Errorf("invalid hex big.Int string")
-			}
-			b.Int = z
-			return nil
+		z, err := ParseBigInt(s)
+		if err != nil {
+			return err
 		}
-		// decimal only (do not accept plain hex without 0x prefix)
-		if z, ok := new(big.Int).SetString(s, 10); ok {
-			b.Int = z
-			return nil
-		}
-		return fmt.Errorf("invalid decimal big.Int string")
+		b.Int = z
+		return nil
 	}
 	// try number (unquoted)
 	var n json.Number
@@ -57,3 +48,20 @@ func (b *BigInt) UnmarshalJSON(data []byte) error {
 	}
 	return fmt.Errorf("invalid big.Int JSON")
 }
+
+// ParseBigInt parses a decimal, or 0x/0X-prefixed hexadecimal, integer string.
+func ParseBigInt(s string) (*big.Int, error) {
+	// support decimal and optional 0x/0X-prefixed hexadecimal inputs
+	if strings.HasPrefix(strings.ToLower(s), "0x") {
+		z, ok := new(big.Int).SetString(s[2:], 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid hex big.Int string")
+		}
+		return z, nil
+	}
+	// decimal only (do not accept plain hex without 0x prefix)
+	if z, ok := new(big.Int).SetString(s, 10); ok {
+		return z, nil
+	}
+	return nil, fmt.Errorf("invalid decimal big.Int string")
+}
@@ -59,3 +59,20 @@ func TestUnmarshalJSON_Null(t *testing.T) {
 		t.Fatalf("expected nil Int for null, got %v", b.Int)
 	}
 }
+
+func TestParseBigInt(t *testing.T) {
+	for in, want := range map[string]int64{"12345": 12345, "0x3039": 12345, "0X3039": 12345} {
+		z, err := ParseBigInt(in)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", in, err)
+		}
+		if z.Int64() != want {
+			t.Fatalf("unexpected value for %q: got %v want %v", in, z, want)
+		}
+	}
+	for _, in := range []string{"", "3039ab", "0xzz"} {
+		if _, err := ParseBigInt(in); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
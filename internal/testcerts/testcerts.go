@@ -0,0 +1,74 @@
+// Package testcerts creates the certificates used by the tests.
+package testcerts
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// NewKey generates an ECDSA P-256 key.
+func NewKey(t testing.TB) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+// NewCA creates a self-signed CA certificate named cn, and its ECDSA P-256 key.
+func NewCA(t testing.TB, cn string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key := NewKey(t)
+	cert := Issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: cn},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, key.Public(), nil, key)
+	return cert, key
+}
+
+// NewLeaf creates a certificate with the given serial number issued by ca, and its ECDSA P-256 key.
+func NewLeaf(t testing.TB, serial int64, ca *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key := NewKey(t)
+	return Issue(t, &x509.Certificate{SerialNumber: big.NewInt(serial)}, key.Public(), ca, caKey), key
+}
+
+// Issue creates a certificate from tmpl for pub, signed by parent with parentKey, or self-signed with parentKey
+// if parent is nil. A random serial number, a "test" subject and a validity of one hour around now are set
+// when tmpl has none.
+func Issue(t testing.TB, tmpl *x509.Certificate, pub crypto.PublicKey, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	c := *tmpl
+	if c.SerialNumber == nil {
+		serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+		require.NoError(t, err)
+		c.SerialNumber = serial
+	}
+	if c.Subject.String() == "" {
+		c.Subject = pkix.Name{CommonName: "test"}
+	}
+	if c.NotBefore.IsZero() {
+		c.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if c.NotAfter.IsZero() {
+		c.NotAfter = time.Now().Add(time.Hour)
+	}
+	if parent == nil {
+		parent = &c
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &c, parent, pub, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
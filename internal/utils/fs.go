@@ -20,3 +20,25 @@ func ExpandTilde(in string) (string, error) {
 	}
 	return in, nil
 }
+
+// WritePrivateFile writes data to the file at path, expanding a leading tilde. The file is only readable
+// and writable by its owner, even if it already existed with wider permissions.
+func WritePrivateFile(path string, data []byte) error {
+	path, err := ExpandTilde(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
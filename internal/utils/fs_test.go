@@ -2,6 +2,7 @@ package utils
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -44,3 +45,23 @@ func TestExpandTildeError(t *testing.T) {
 		t.Fatalf("Expected an error, but got nil")
 	}
 }
+
+func TestWritePrivateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "private")
+	if err := os.WriteFile(path, []byte("previous content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePrivateFile(path, []byte("secret")); err != nil {
+		t.Fatalf("WritePrivateFile call failed: %s", err.Error())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("Expected mode 0600, got %o", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "secret" {
+		t.Fatalf("Unexpected content %q", data)
+	}
+}